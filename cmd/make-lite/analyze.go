@@ -0,0 +1,216 @@
+// cmd/make-lite/analyze.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nodeSpan is when the executor ran a node.
+type nodeSpan struct {
+	node       *buildNode
+	start, end time.Time
+}
+
+func (s *nodeSpan) duration() time.Duration {
+	return s.end.Sub(s.start)
+}
+
+// analysis records when each node of a build ran, for --analyze.
+type analysis struct {
+	mu    sync.Mutex
+	start time.Time
+	spans map[*buildNode]*nodeSpan
+}
+
+func newAnalysis() *analysis {
+	return &analysis{start: time.Now(), spans: make(map[*buildNode]*nodeSpan)}
+}
+
+// record notes that node ran from start to now. A nil analysis records nothing.
+func (a *analysis) record(node *buildNode, start time.Time) {
+	if a == nil {
+		return
+	}
+	end := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spans[node] = &nodeSpan{node: node, start: start, end: end}
+}
+
+// criticalPath returns the chain of nodes that decided how long the build
+// took, first to last: starting from the node that finished last, each one is
+// preceded by the dep it waited for longest, the one that finished last. Deps
+// that only checked they were up to date are looked through to their own deps.
+// Only nodes whose recipe ran are returned.
+func (a *analysis) criticalPath() []*nodeSpan {
+	var last *nodeSpan
+	for _, span := range a.spans {
+		if last == nil || span.end.After(last.end) {
+			last = span
+		}
+	}
+	var path []*nodeSpan
+	for span := last; span != nil; span = a.gate(span.node) {
+		if span.node.ran {
+			path = append(path, span)
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// gate returns the span of the dep of node that finished last, or nil.
+func (a *analysis) gate(node *buildNode) *nodeSpan {
+	var latest *nodeSpan
+	for _, dep := range node.deps {
+		if span := a.spans[dep]; span != nil && (latest == nil || span.end.After(latest.end)) {
+			latest = span
+		}
+	}
+	return latest
+}
+
+// slowest returns the nodes whose recipe ran, slowest first, at most n.
+func (a *analysis) slowest(n int) []*nodeSpan {
+	var spans []*nodeSpan
+	for _, span := range a.spans {
+		if span.node.ran {
+			spans = append(spans, span)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].duration() != spans[j].duration() {
+			return spans[i].duration() > spans[j].duration()
+		}
+		return spans[i].node.name < spans[j].node.name
+	})
+	if len(spans) > n {
+		spans = spans[:n]
+	}
+	return spans
+}
+
+// report prints the critical path and the slowest recipes of the build, and
+// writes the Chrome trace to path.
+func (a *analysis) report(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	wall := time.Since(a.start)
+	critical := a.criticalPath()
+	slowest := a.slowest(AnalyzeSlowestCount)
+	width := 0
+	for _, span := range append(append([]*nodeSpan(nil), critical...), slowest...) {
+		width = max(width, len(span.node.name))
+	}
+
+	var onPath time.Duration
+	for _, span := range critical {
+		onPath += span.duration()
+	}
+	fmt.Printf(StatusCriticalPath, formatSpan(onPath), formatSpan(wall))
+	if len(critical) == 0 {
+		fmt.Print(StatusAnalyzeNoRecipes)
+	}
+	for _, span := range critical {
+		fmt.Printf(AnalyzeSpanFormat, width, span.node.name, formatSpan(span.duration()))
+	}
+	if len(slowest) > 0 {
+		fmt.Printf(StatusSlowestRecipes, len(slowest))
+		for _, span := range slowest {
+			fmt.Printf(AnalyzeSpanFormat, width, span.node.name, formatSpan(span.duration()))
+		}
+	}
+
+	if err := a.writeTrace(path, critical); err != nil {
+		return fmt.Errorf(ErrorAnalyzeTrace, path, err)
+	}
+	fmt.Printf(StatusAnalyzeTrace, path)
+	return nil
+}
+
+func formatSpan(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// traceEvent is an event of the Chrome trace event format, which
+// chrome://tracing and Perfetto load.
+type traceEvent struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp int64          `json:"ts"`            // Microseconds since the build started
+	Duration  int64          `json:"dur,omitempty"` // Microseconds
+	PID       int            `json:"pid"`
+	TID       int            `json:"tid"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+// writeTrace writes the recipes that ran as a Chrome trace. Recipes that
+// overlap are put on separate rows, as job slots, and those on the critical
+// path are in the `critical` category too, so they can be picked out.
+func (a *analysis) writeTrace(path string, critical []*nodeSpan) error {
+	onPath := make(map[*buildNode]bool)
+	for _, span := range critical {
+		onPath[span.node] = true
+	}
+	var spans []*nodeSpan
+	for _, span := range a.spans {
+		if span.node.ran {
+			spans = append(spans, span)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if !spans[i].start.Equal(spans[j].start) {
+			return spans[i].start.Before(spans[j].start)
+		}
+		return spans[i].node.name < spans[j].node.name
+	})
+
+	events := []traceEvent{}
+	var lanes []time.Time // When the last recipe on each row ends
+	for _, span := range spans {
+		lane := len(lanes)
+		for i, end := range lanes {
+			if !end.After(span.start) {
+				lane = i
+				break
+			}
+		}
+		if lane == len(lanes) {
+			lanes = append(lanes, span.end)
+			events = append(events, traceEvent{Name: "thread_name", Phase: "M", PID: 1, TID: lane + 1, Args: map[string]any{"name": fmt.Sprintf("job %d", lane+1)}})
+		}
+		lanes[lane] = span.end
+		category := "recipe"
+		if onPath[span.node] {
+			category = "recipe,critical"
+		}
+		var targets []string
+		for _, rule := range span.node.rules {
+			targets = append(targets, rule.Targets...)
+		}
+		events = append(events, traceEvent{
+			Name:      span.node.name,
+			Category:  category,
+			Phase:     "X",
+			Timestamp: span.start.Sub(a.start).Microseconds(),
+			Duration:  max(span.duration().Microseconds(), 1),
+			PID:       1,
+			TID:       lane + 1,
+			Args:      map[string]any{"targets": strings.Join(targets, " "), "critical": onPath[span.node]},
+		})
+	}
+	data, err := json.MarshalIndent(map[string]any{"traceEvents": events, "displayTimeUnit": "ms"}, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
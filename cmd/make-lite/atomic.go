@@ -0,0 +1,55 @@
+// cmd/make-lite/atomic.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// atomicTempPath names the file an atomic rule's recipe writes instead of its
+// target: hidden, next to the target so that renaming it is atomic, and unique.
+func atomicTempPath(target string) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tmp-"+hex.EncodeToString(suffix))
+}
+
+// executeAtomically runs the recipe of a rule covered by `.ATOMIC:` with `$@`
+// set to a temporary file, which replaces the target only once the recipe
+// succeeded, so an interrupted or failed recipe never leaves a truncated
+// target that looks up to date. Only the first target, the one `$@` names, is
+// produced this way. A recipe that leaves the temporary file missing is
+// assumed to have written the target itself. Other rules run as usual.
+func (e *Engine) executeAtomically(rule *Rule) error {
+	if !e.makefile.Atomic.Covers(rule) || len(rule.Targets) == 0 {
+		return e.executeSandboxed(rule)
+	}
+	target := rule.Targets[0]
+	temp := atomicTempPath(target)
+	atomic := *rule
+	atomic.Automatic = map[string]string{"@": temp}
+	for name, value := range rule.Automatic {
+		if name != "@" {
+			atomic.Automatic[name] = value
+		}
+	}
+
+	if err := e.executeSandboxed(&atomic); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if _, err := os.Lstat(temp); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.Rename(temp, target); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf(ErrorAtomicRename, temp, target, err)
+	}
+	if e.isDebug {
+		fmt.Printf(DebugAtomicRename, temp, target)
+	}
+	return nil
+}
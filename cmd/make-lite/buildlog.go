@@ -0,0 +1,310 @@
+// cmd/make-lite/buildlog.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of build log records, in the order they are written.
+const (
+	BuildLogStart = "build" // The build starts
+	BuildLogRule  = "rule"  // A recipe ran
+	BuildLogEnd   = "end"   // The build finished
+)
+
+// buildLogRecord is one line of a build log. Which fields are set depends on
+// its type.
+type buildLogRecord struct {
+	Type     string   `json:"type"`
+	Time     string   `json:"time"` // When the build or recipe started, or the build ended
+	BuildID  string   `json:"build_id,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Dir      string   `json:"dir,omitempty"`
+	Goals    []string `json:"goals,omitempty"`
+	Targets  []string `json:"targets,omitempty"`
+	Commands []string `json:"commands,omitempty"` // Expanded commands, in the order they ran
+
+	DurationMS      int64  `json:"duration_ms,omitempty"`
+	ExitStatus      *int   `json:"exit_status,omitempty"` // Of the command that failed, or 0; unset if none ran to an exit
+	Error           string `json:"error,omitempty"`
+	Output          string `json:"output,omitempty"` // What the recipe wrote to stdout and stderr
+	OutputTruncated bool   `json:"output_truncated,omitempty"`
+}
+
+// logCapture keeps the end of a recipe's output, up to BuildLogOutputLimit.
+type logCapture struct {
+	mu        sync.Mutex
+	data      []byte
+	truncated bool
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = append(c.data, p...)
+	if over := len(c.data) - BuildLogOutputLimit; over > 0 {
+		c.data = append(c.data[:0], c.data[over:]...)
+		c.truncated = true
+	}
+	return len(p), nil
+}
+
+// runningRecipe is a recipe whose record is written once it finishes.
+type runningRecipe struct {
+	record  buildLogRecord
+	start   time.Time
+	capture *logCapture
+}
+
+// buildLog writes the log of one build, one JSON record per line, so a CI
+// failure can be looked into after the fact. A nil log records nothing.
+type buildLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	running map[string]*runningRecipe // By first target
+}
+
+// buildLogDir is where builds in the working directory leave their logs.
+func buildLogDir() string {
+	return filepath.Join(StateDir, BuildLogDir)
+}
+
+// openBuildLog starts the log of a build under buildLogDir, named by the time
+// it starts, and removes the oldest logs beyond BuildLogKeep.
+func openBuildLog(buildID string, goals []string) (*buildLog, error) {
+	dir := buildLogDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	logs, err := buildLogs()
+	if err != nil {
+		return nil, err
+	}
+	for len(logs) >= BuildLogKeep {
+		_ = os.Remove(filepath.Join(dir, logs[0]))
+		logs = logs[1:]
+	}
+	start := time.Now()
+	path := filepath.Join(dir, start.Format("2006-01-02T15-04-05.000")+".jsonl")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &buildLog{f: f, start: start, running: make(map[string]*runningRecipe)}
+	wd, _ := os.Getwd()
+	l.write(buildLogRecord{Type: BuildLogStart, Time: start.Format(time.RFC3339Nano), BuildID: buildID, Version: AppVersion, Dir: wd, Goals: goals})
+	return l, nil
+}
+
+// buildLogs lists the log files in buildLogDir, oldest first.
+func buildLogs() ([]string, error) {
+	entries, err := os.ReadDir(buildLogDir())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".jsonl") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// write appends a record. A log that cannot be written is given up on
+// quietly, as the build itself is not affected.
+func (l *buildLog) write(record buildLogRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// begin, command and end record a rule's recipe as it starts, runs each
+// command and finishes.
+func (l *buildLog) begin(rule *Rule) {
+	if l == nil || len(rule.Targets) == 0 || len(rule.Recipe) == 0 {
+		return
+	}
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running[rule.Targets[0]] = &runningRecipe{
+		record:  buildLogRecord{Type: BuildLogRule, Time: start.Format(time.RFC3339Nano), Targets: rule.Targets},
+		start:   start,
+		capture: &logCapture{},
+	}
+}
+
+func (l *buildLog) command(rule *Rule, command string) {
+	if l == nil || len(rule.Targets) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r := l.running[rule.Targets[0]]; r != nil {
+		r.record.Commands = append(r.record.Commands, strings.TrimLeft(command, " \t"))
+	}
+}
+
+func (l *buildLog) end(rule *Rule, err error) {
+	if l == nil || len(rule.Targets) == 0 {
+		return
+	}
+	l.mu.Lock()
+	r := l.running[rule.Targets[0]]
+	delete(l.running, rule.Targets[0])
+	l.mu.Unlock()
+	if r == nil {
+		return
+	}
+	record := r.record
+	record.DurationMS = time.Since(r.start).Milliseconds()
+	var exitErr *exec.ExitError
+	if err == nil {
+		status := 0
+		record.ExitStatus = &status
+	} else {
+		if errors.As(err, &exitErr) {
+			status := exitErr.ExitCode()
+			record.ExitStatus = &status
+		}
+		record.Error = err.Error()
+	}
+	r.capture.mu.Lock()
+	record.Output, record.OutputTruncated = string(r.capture.data), r.capture.truncated
+	r.capture.mu.Unlock()
+	l.write(record)
+}
+
+// capture returns the writer that keeps a running recipe's output, or nil.
+func (l *buildLog) capture(rule *Rule) io.Writer {
+	if l == nil || len(rule.Targets) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r := l.running[rule.Targets[0]]; r != nil {
+		return r.capture
+	}
+	return nil
+}
+
+// close ends the log with how the build went.
+func (l *buildLog) close(err error) {
+	if l == nil {
+		return
+	}
+	record := buildLogRecord{Type: BuildLogEnd, Time: time.Now().Format(time.RFC3339Nano), DurationMS: time.Since(l.start).Milliseconds()}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	l.write(record)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// runLogCommand prints a build log for `make-lite log last`.
+func runLogCommand(w io.Writer, args []string) error {
+	if len(args) != 1 || args[0] != "last" {
+		return errors.New(ErrorLogArgs)
+	}
+	logs, err := buildLogs()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf(ErrorNoBuildLog, buildLogDir())
+	}
+	return writeBuildLog(w, filepath.Join(buildLogDir(), logs[len(logs)-1]))
+}
+
+// writeBuildLog pretty-prints the build log at path: each recipe that ran,
+// with its commands and output, and how the build ended.
+func writeBuildLog(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	ended := false
+	for {
+		var record buildLogRecord
+		if err := decoder.Decode(&record); err != nil {
+			// The log ends, or its last line was cut short by a killed build.
+			break
+		}
+		switch record.Type {
+		case BuildLogStart:
+			fmt.Fprintf(w, BuildLogHeader, record.BuildID, strings.Join(record.Goals, " "), formatLogTime(record.Time), record.Dir)
+			fmt.Fprintf(w, BuildLogFile, path)
+		case BuildLogRule:
+			status, detail := BuildLogRuleOK, ""
+			if record.Error != "" {
+				status, detail = BuildLogRuleFailed, ": "+record.Error
+			}
+			fmt.Fprintf(w, BuildLogRuleFormat, status, strings.Join(record.Targets, " "), formatLogDuration(record.DurationMS), detail)
+			for _, command := range record.Commands {
+				fmt.Fprintf(w, BuildLogCommandFormat, strings.ReplaceAll(command, "\n", "\n      "))
+			}
+			if record.OutputTruncated {
+				fmt.Fprint(w, BuildLogOutputTruncated)
+			}
+			if output := strings.TrimSuffix(record.Output, "\n"); output != "" {
+				for _, line := range strings.Split(output, "\n") {
+					fmt.Fprintf(w, BuildLogOutputFormat, strings.TrimSuffix(line, "\r"))
+				}
+			}
+		case BuildLogEnd:
+			ended = true
+			if record.Error != "" {
+				fmt.Fprintf(w, BuildLogFailed, formatLogDuration(record.DurationMS), record.Error)
+			} else {
+				fmt.Fprintf(w, BuildLogSucceeded, formatLogDuration(record.DurationMS))
+			}
+		}
+	}
+	if !ended {
+		fmt.Fprint(w, BuildLogUnfinished)
+	}
+	return nil
+}
+
+// formatLogTime shows a logged time to the second, in local time.
+func formatLogTime(value string) string {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatLogDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
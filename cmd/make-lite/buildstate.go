@@ -0,0 +1,274 @@
+// cmd/make-lite/buildstate.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// buildState is what earlier runs recorded about the targets they built.
+type buildState struct {
+	Recipes  map[string]string       `json:"recipes"`            // Digest of the expanded recipe that last built each file target
+	Depfiles map[string][]string     `json:"depfiles,omitempty"` // Prerequisites read from the depfile of each target, such as headers
+	Restat   map[string]restatRecord `json:"restat,omitempty"`   // `.RESTAT` targets last rewritten with unchanged content
+}
+
+// errProbeSideEffect stops the expansion of a recipe that is only expanded to
+// compare it with the state file, when it would run a command or have another
+// side effect.
+var errProbeSideEffect = errors.New("recipe expansion has side effects")
+
+// sideEffectFunctions are the functions a probing expansion refuses to call.
+var sideEffectFunctions = map[string]bool{
+	"error":   true,
+	"warning": true,
+	"info":    true,
+	"eval":    true,
+	"file":    true,
+	"x":       true,
+}
+
+// statePath is where the build state of the working directory is kept.
+func statePath() string {
+	return filepath.Join(StateDir, BuildStateFile)
+}
+
+// loadState reads the build state on first use. A missing, corrupt or
+// outdated state file starts an empty one.
+func (e *Engine) loadState() *buildState {
+	if e.state == nil {
+		e.state = &buildState{}
+		if err := readStateFile(statePath(), e.state); err != nil && e.isDebug && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, DebugBuildStateDiscarded, err)
+		}
+		if e.state.Recipes == nil {
+			e.state.Recipes = make(map[string]string)
+		}
+		if e.state.Depfiles == nil {
+			e.state.Depfiles = make(map[string][]string)
+		}
+		if e.state.Restat == nil {
+			e.state.Restat = make(map[string]restatRecord)
+		}
+	}
+	return e.state
+}
+
+// saveState writes the build state back if this run changed it.
+func (e *Engine) saveState() error {
+	if !e.stateChanged {
+		return nil
+	}
+	if err := writeStateFile(statePath(), e.state); err != nil {
+		return fmt.Errorf(ErrorBuildState, err)
+	}
+	e.stateChanged = false
+	return nil
+}
+
+// recipeDigest expands a rule's recipe without running it and returns a digest
+// of the command lines, which covers every variable value they use. It is
+// called with the rule in scope, and reports false for a recipe that cannot be
+// expanded without side effects, such as `$(shell ...)`, or not at all.
+func (e *Engine) recipeDigest(rule *Rule) (string, bool) {
+	e.vars.probing = true
+	defer func() { e.vars.probing = false }()
+	hash := sha256.New()
+	for _, cmdLine := range rule.Recipe {
+		if strings.TrimSpace(cmdLine) == "" {
+			continue
+		}
+		expandedCmd, _, err := e.expandRecipeLine(rule, cmdLine)
+		if err != nil {
+			return "", false
+		}
+		hash.Write([]byte(strings.TrimSpace(expandedCmd) + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// stateKey is the target a rule's recipe is recorded under, or "" for rules
+// that are not tracked: recipes that make no file, and double-colon rules,
+// several of which may build the same target.
+func stateKey(rule *Rule) string {
+	if rule.DoubleColon || len(rule.Targets) == 0 || len(rule.Recipe) == 0 {
+		return ""
+	}
+	for _, target := range rule.Targets {
+		if info, err := os.Stat(target); err != nil || info.IsDir() {
+			return ""
+		}
+	}
+	return rule.Targets[0]
+}
+
+// recipeChanged reports whether the recipe of a rule that is otherwise up to
+// date expands differently from when its targets were built, for instance
+// because CFLAGS changed. A target built before the state file existed has
+// its current recipe recorded instead.
+func (e *Engine) recipeChanged(rule *Rule) bool {
+	changed := false
+	_ = e.inRuleScope(rule, func() error {
+		key := stateKey(rule)
+		if key == "" {
+			return nil
+		}
+		digest, ok := e.recipeDigest(rule)
+		if !ok {
+			return nil
+		}
+		recorded, known := e.loadState().Recipes[key]
+		switch {
+		case !known:
+			e.state.Recipes[key] = digest
+			e.stateChanged = true
+		case recorded != digest:
+			changed = true
+		}
+		return nil
+	})
+	return changed
+}
+
+// recordRecipe records the recipe that just built a rule's targets.
+func (e *Engine) recordRecipe(rule *Rule) {
+	_ = e.inRuleScope(rule, func() error {
+		key := stateKey(rule)
+		if key == "" {
+			return nil
+		}
+		state := e.loadState()
+		digest, ok := e.recipeDigest(rule)
+		if !ok {
+			// A recipe that cannot be compared must not be judged by an old record.
+			if _, known := state.Recipes[key]; known {
+				delete(state.Recipes, key)
+				e.stateChanged = true
+			}
+			return nil
+		}
+		if state.Recipes[key] != digest {
+			state.Recipes[key] = digest
+			e.stateChanged = true
+		}
+		return nil
+	})
+}
+
+// depfilePath is the depfile of a rule, with `%` replaced by its first target
+// without the suffix, or "" if it has none.
+func depfilePath(rule *Rule) string {
+	if rule.Depfile == "" || len(rule.Targets) == 0 {
+		return ""
+	}
+	target := rule.Targets[0]
+	return strings.ReplaceAll(rule.Depfile, "%", strings.TrimSuffix(target, filepath.Ext(target)))
+}
+
+// parseDepfile reads the prerequisites from a depfile in the make syntax that
+// `gcc -MMD` and `clang -MMD` write: `main.o: main.c util.h`, continued with
+// backslashes, with `\ ` for a blank in a name and `$$` for a dollar sign. The
+// prerequisite-less rules that `-MP` adds for each header are skipped.
+func parseDepfile(data string) ([]string, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\\\n", " ")
+	var deps []string
+	seen := make(map[string]bool)
+	for n, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		colon := -1
+		for i := 0; i < len(line); i++ {
+			// A colon followed by a blank ends the targets; `C:\src` is a Windows path.
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == ':' && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t') {
+				colon = i
+				break
+			}
+		}
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: missing ':'", n+1)
+		}
+		for _, dep := range splitWords(strings.ReplaceAll(line[colon+1:], "$$", "$")) {
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// recordDepfile stores the prerequisites a recipe reported in its depfile,
+// once it ran successfully. A recipe that wrote no depfile forgets them.
+func (e *Engine) recordDepfile(rule *Rule) error {
+	path := depfilePath(rule)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state := e.loadState()
+	if os.IsNotExist(err) {
+		if _, known := state.Depfiles[rule.Targets[0]]; known {
+			delete(state.Depfiles, rule.Targets[0])
+			e.stateChanged = true
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(ErrorDepfile, path, err)
+	}
+	deps, err := parseDepfile(string(data))
+	if err != nil {
+		return fmt.Errorf(ErrorDepfile, path, err)
+	}
+	if e.isDebug {
+		fmt.Printf(DebugDepfileRead, path, len(deps))
+	}
+	state.Depfiles[rule.Targets[0]] = deps
+	e.stateChanged = true
+	return nil
+}
+
+// checkDiscoveredDeps reports a rule as stale when a prerequisite its depfile
+// listed is missing or newer than its oldest target. They come from the build
+// state; if it has none yet, as after upgrading, the depfile on disk is read.
+func (e *Engine) checkDiscoveredDeps(rule *Rule, oldestTargetModTime time.Time) (bool, string, error) {
+	path := depfilePath(rule)
+	if path == "" || oldestTargetModTime.IsZero() {
+		return false, "", nil
+	}
+	state := e.loadState()
+	deps, known := state.Depfiles[rule.Targets[0]]
+	if !known {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, "", nil
+		}
+		if deps, err = parseDepfile(string(data)); err != nil {
+			return false, "", fmt.Errorf(ErrorDepfile, path, err)
+		}
+		state.Depfiles[rule.Targets[0]] = deps
+		e.stateChanged = true
+	}
+	for _, dep := range deps {
+		info, err := e.stat(dep)
+		if err != nil {
+			return true, fmt.Sprintf("discovered dependency '%s' is missing", dep), nil
+		}
+		if e.contentModTime(dep, info.ModTime()).After(oldestTargetModTime) {
+			return true, fmt.Sprintf("discovered dependency '%s' is newer", dep), nil
+		}
+	}
+	return false, "", nil
+}
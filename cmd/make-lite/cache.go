@@ -0,0 +1,257 @@
+// cmd/make-lite/cache.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// cacheRoot is the directory of the output cache: --cache-dir, or
+// ~/.cache/make-lite/outputs on Linux.
+func (e *Engine) cacheRoot() (string, error) {
+	if e.opts.CacheDir != "" {
+		return e.opts.CacheDir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, OutputCacheSubdir), nil
+}
+
+// cacheOutputs are the files a cache entry holds for a rule: its targets,
+// then its depfile if it has one.
+func cacheOutputs(rule *Rule) []string {
+	outputs := append([]string(nil), rule.Targets...)
+	if path := depfilePath(rule); path != "" {
+		outputs = append(outputs, path)
+	}
+	return outputs
+}
+
+// actionKey identifies what a `.CACHE` rule's recipe would do: a digest of
+// its expanded recipe, its targets and depfile, the content of every
+// prerequisite file and of the dependencies its depfile listed last time,
+// and the values of the variables named in `.CACHE_ENV`. It reports false
+// for rules whose outputs cannot safely be reused: double-colon rules,
+// services, recipes with side effects when expanded, prerequisites that are
+// directories, and depfile rules that have not recorded their dependencies.
+func (e *Engine) actionKey(rule *Rule) (string, bool) {
+	if !e.makefile.Cache.Covers(rule) || rule.DoubleColon || rule.IsService || len(rule.Recipe) == 0 {
+		return "", false
+	}
+	var lines, files []string
+	cacheable := false
+	_ = e.inRuleScope(rule, func() error {
+		digest, ok := e.recipeDigest(rule)
+		if !ok {
+			return nil
+		}
+		lines = append(lines, "recipe "+digest, "depfile "+depfilePath(rule))
+		for _, target := range rule.Targets {
+			lines = append(lines, "target "+target)
+		}
+		for _, source := range rule.Sources {
+			path := e.sourcePath(source)
+			if _, err := os.Stat(path); os.IsNotExist(err) && e.makefile.HasRule(source) {
+				// A symbolic prerequisite has no content.
+				lines = append(lines, "symbolic "+source)
+				continue
+			}
+			files = append(files, path)
+		}
+		if rule.Depfile != "" {
+			deps, known := e.loadState().Depfiles[rule.Targets[0]]
+			if !known {
+				return nil
+			}
+			files = append(files, deps...)
+		}
+		names, _ := e.vars.Get(CacheEnvVar)
+		for _, name := range splitWords(names) {
+			value, ok := e.vars.Get(name)
+			if !ok {
+				value = os.Getenv(name)
+			}
+			lines = append(lines, "env "+name+"="+value)
+		}
+		cacheable = true
+		return nil
+	})
+	if !cacheable {
+		return "", false
+	}
+
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line + "\n"))
+	}
+	for _, path := range files {
+		digest, ok := fileDigest(path)
+		if !ok {
+			return "", false
+		}
+		hash.Write([]byte("file " + path + " " + digest + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// cacheEntry is the directory holding the outputs of an action.
+func (e *Engine) cacheEntry(key string) (string, error) {
+	root, err := e.cacheRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, key[:2], key), nil
+}
+
+// copyFile copies a file with its permissions, replacing dst atomically.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := in.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Once renamed into place, the temporary file is gone.
+		if removeErr := os.Remove(out.Name()); err == nil && !os.IsNotExist(removeErr) {
+			err = removeErr
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// entryComplete reports whether a cache entry holds every target of a rule.
+func entryComplete(entry string, rule *Rule) bool {
+	for i := range rule.Targets {
+		if _, err := os.Stat(filepath.Join(entry, strconv.Itoa(i))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreFromCache copies the outputs an earlier run of the same action left
+// in the cache into place, instead of running the recipe, and reports whether
+// there were any. An entry missing locally is looked for in the remote cache.
+// Outputs are copies rather than links, so a later recipe that rewrites a
+// target in place cannot damage the cache.
+func (e *Engine) restoreFromCache(rule *Rule, key string) bool {
+	entry, err := e.cacheEntry(key)
+	if err != nil {
+		return false
+	}
+	if !entryComplete(entry, rule) && !(e.fetchRemote(key, entry) && entryComplete(entry, rule)) {
+		if e.isDebug {
+			fmt.Printf(DebugCacheMiss, rule.Targets[0], key)
+		}
+		return false
+	}
+	outputs := cacheOutputs(rule)
+	for i, output := range outputs {
+		cached := filepath.Join(entry, strconv.Itoa(i))
+		if _, err := os.Stat(cached); os.IsNotExist(err) && i >= len(rule.Targets) {
+			// The recipe wrote no depfile that time.
+			continue
+		}
+		if err := copyFile(cached, output); err != nil {
+			fmt.Fprintf(os.Stderr, WarningCacheRestore, output, err)
+			return false
+		}
+	}
+	_ = e.inRuleScope(rule, func() error {
+		e.recipesRun++
+		return nil
+	})
+	fmt.Printf(StatusRestoredFromCache, rule.Targets[0])
+	return true
+}
+
+// storeInCache keeps the outputs of a recipe that just succeeded under its
+// action key, and uploads them to the remote cache. An entry is written to a
+// temporary directory and renamed into place, so concurrent builds sharing
+// the cache never see half an entry. Failing to store is only a warning.
+func (e *Engine) storeInCache(rule *Rule, key string) {
+	entry, err := e.cacheEntry(key)
+	if err == nil {
+		err = storeEntry(entry, rule, cacheOutputs(rule))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, WarningCacheStore, rule.Targets[0], err)
+		return
+	}
+	if e.isDebug {
+		fmt.Printf(DebugCacheStored, rule.Targets[0], entry)
+	}
+	e.pushRemote(key, entry)
+}
+
+// storeEntry writes the cache entry of an action.
+func storeEntry(entry string, rule *Rule, outputs []string) (err error) {
+	if _, err := os.Stat(entry); err == nil {
+		return nil
+	}
+	for _, target := range rule.Targets {
+		if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf(ErrorCacheNotRegularFile, target)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(entry), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(tmp); err == nil {
+			err = removeErr
+		}
+	}()
+	for i, output := range outputs {
+		if _, err := os.Stat(output); os.IsNotExist(err) && i >= len(rule.Targets) {
+			continue
+		}
+		if err := copyFile(output, filepath.Join(tmp, strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, entry); err != nil {
+		if _, statErr := os.Stat(entry); statErr == nil {
+			// Another build stored the same action first.
+			return nil
+		}
+		return err
+	}
+	return nil
+}
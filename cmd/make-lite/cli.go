@@ -3,14 +3,90 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // Config holds the final configuration determined from CLI flags and arguments.
 type Config struct {
 	Makefile string
-	Target   string
+	Targets  []string // Goals to build, in order
+	Command  string   // Subcommand such as "help", "docs", "vars", "owners", "xref", "up", "stop" or "logs"
+	Args     []string // Arguments following the subcommand
 	ShowHelp bool
 	ShowVer  bool
+	List     bool // Print the targets with their descriptions instead of building
+
+	IncludeDirs   []string // Extra directories searched by `include`, from -I and then MAKEFILE_DIRS
+	VerifyIO      bool     // Fail when a recipe breaks its declared input/output contract
+	OutputDir     string   // Separate root for build outputs, from --chdir-output or O=dir
+	Profile       string   // Configuration variant selected with --profile
+	Silent        bool     // Do not echo recipe commands
+	IgnoreErrors  bool     // Keep going when a recipe command fails
+	Offline       bool     // Never download remote includes; use only cached copies
+	Builtins      bool     // Preload every default variable set, as with a bare `.DEFAULTS:`
+	Strict        bool     // Enable the checks of `.STRICT:` for every makefile
+	LegacyDollar  bool     // Expand an undefined `$name` to nothing, as older versions did
+	ShellFallback string   // When unknown `$(words with spaces)` run as commands: auto, on or off
+	TraceVars     []string // Variables whose assignments and expansions are logged, from --trace-var
+	Jobs          int      // Recipes run at once, from -j; 0 means one per CPU
+	Retry         int      // Retries of every failed recipe, from --retry
+	MinFreeMemory string   // Free memory below which no more recipes start, from --min-free-memory
+	CacheDir      string   // Output cache for `.CACHE` targets, from --cache-dir
+	CacheRemote   string   // Shared output cache URL, from --cache-remote
+	CacheMode     string   // read, write or readwrite, from --cache-remote-mode
+	Sandbox       string   // warn or error, from --sandbox; "" runs recipes in place
+	OutputPrefix  bool     // Prefix recipe output lines with their target, from --output-prefix
+	PTY           ptyFlag  // auto, on or off, from --pty
+	Analyze       string   // Chrome trace file, from --analyze
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ptyFlag is the --pty mode. A bare --pty means on, and --pty=false off.
+type ptyFlag string
+
+func (f *ptyFlag) String() string {
+	return string(*f)
+}
+
+func (f *ptyFlag) Set(value string) error {
+	switch value {
+	case "true":
+		value = PTYOn
+	case "false":
+		value = PTYOff
+	}
+	*f = ptyFlag(value)
+	return nil
+}
+
+func (f *ptyFlag) IsBoolFlag() bool {
+	return true
+}
+
+// subcommands are the words that run a make-lite command instead of building a target.
+var subcommands = map[string]bool{
+	"help":   true,
+	"docs":   true,
+	"up":     true,
+	"vars":   true,
+	"stop":   true,
+	"logs":   true,
+	"owners": true,
+	"xref":   true,
+	"log":    true,
 }
 
 // ParseCLI parses command-line arguments and returns a Config struct.
@@ -21,17 +97,78 @@ func ParseCLI() *Config {
 	flag.BoolVar(&cfg.ShowHelp, "help", false, "Display help message.")
 	flag.BoolVar(&cfg.ShowVer, "v", false, "Display program version.")
 	flag.BoolVar(&cfg.ShowVer, "version", false, "Display program version.")
+	flag.BoolVar(&cfg.List, "l", false, "List the targets with their descriptions.")
+	flag.BoolVar(&cfg.List, "list", false, "List the targets with their descriptions.")
+	flag.BoolVar(&cfg.Silent, "s", false, "Do not echo recipe commands.")
+	flag.BoolVar(&cfg.Silent, "silent", false, "Do not echo recipe commands.")
+	flag.BoolVar(&cfg.IgnoreErrors, "i", false, "Ignore errors from recipe commands.")
+	flag.BoolVar(&cfg.IgnoreErrors, "ignore-errors", false, "Ignore errors from recipe commands.")
+	flag.IntVar(&cfg.Jobs, "j", 1, "Run up to `n` recipes at once; 0 runs one per CPU.")
+	flag.IntVar(&cfg.Jobs, "jobs", 1, "Run up to `n` recipes at once; 0 runs one per CPU.")
+	flag.StringVar(&cfg.MinFreeMemory, "min-free-memory", MinFreeMemoryDefault, "With -j, start no more recipes beside the running ones while less than `amount` of memory is available; 0 turns this off.")
+	flag.IntVar(&cfg.Retry, "retry", 0, "Run a failed recipe again up to `n` times, waiting 1s, 2s, 4s... in between.")
+	flag.StringVar(&cfg.Analyze, "analyze", "", "After the build, print its critical path and slowest recipes, and write a Chrome trace of it to `file`.")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", "", "Keep the outputs of .CACHE targets in `dir` instead of the user cache directory.")
+	flag.StringVar(&cfg.CacheRemote, "cache-remote", "", "Share the outputs of .CACHE targets through the HTTP server or s3://bucket/prefix at `url`.")
+	flag.StringVar(&cfg.CacheMode, "cache-remote-mode", RemoteCacheReadWrite, "Restore from the remote cache (read), upload to it (write) or both (readwrite) as `mode`.")
+	flag.BoolVar(&cfg.OutputPrefix, "output-prefix", false, "Start every line of recipe output with [target], so the output of parallel jobs can be told apart.")
+	flag.StringVar(&cfg.Sandbox, "sandbox", "", "Run each recipe in a directory holding only its declared prerequisites; when one fails there, warn and run it again in place, or error, as `mode` says.")
+	flag.BoolVar(&cfg.VerifyIO, "verify-io", false, "Fail if a recipe does not update its declared outputs or writes other files.")
+	flag.StringVar(&cfg.Profile, "profile", "", "Build the configuration variant declared as `name` with `profile name: ...`.")
+	flag.StringVar(&cfg.OutputDir, "chdir-output", "", "Build targets in `dir`, keeping the source tree clean (same as O=dir).")
+	flag.BoolVar(&cfg.Strict, "strict", false, "Enable strict mode, as if the makefile declared .STRICT:.")
+	flag.BoolVar(&cfg.LegacyDollar, "legacy-dollar", false, "Expand $name to nothing when name is not a make-lite variable, instead of keeping it for the shell.")
+	flag.StringVar(&cfg.ShellFallback, "shell-fallback", "auto", "Run unknown $(command args) expressions in the shell when `mode` is on; off makes them errors, and auto is off in strict mode and CI.")
+	flag.BoolVar(&cfg.Offline, "offline", false, "Use only cached copies of remote includes, and no remote cache; never download.")
+	flag.BoolVar(&cfg.Builtins, "builtins", false, "Preload conventional variables such as CC, CXX, GO, RM and PREFIX, as if the makefile began with .DEFAULTS:.")
+	cfg.PTY = PTYAuto
+	flag.Var(&cfg.PTY, "pty", "Run recipes under a pseudo-terminal so tools keep their color and progress output: on, off or auto, which is on when make-lite's output is a terminal.")
+	var includeDirs stringList
+	flag.Var(&includeDirs, "I", "Search `dir` for included makefiles (repeatable).")
+	var traceVars stringList
+	flag.Var(&traceVars, "trace-var", "Log every assignment and expansion of variable `name`, with where it came from (repeatable).")
 
 	flag.Usage = printHelp
 	flag.Parse()
 
-	args := flag.Args()
+	var args []string
+	for _, arg := range flag.Args() {
+		// O=dir selects the output root, as in the Linux kernel build.
+		if dir, ok := strings.CutPrefix(arg, "O="); ok {
+			if cfg.OutputDir == "" {
+				cfg.OutputDir = dir
+			}
+			continue
+		}
+		args = append(args, arg)
+	}
 	if len(args) > 0 {
-		cfg.Target = args[0]
+		cfg.Targets = args
+		if subcommands[args[0]] {
+			cfg.Command = args[0]
+			cfg.Args = args[1:]
+		}
 	}
 
 	cfg.Makefile = DefaultMakefile
 
+	// Resolved now, as targets may be built in a separate output root.
+	for _, path := range []*string{&cfg.CacheDir, &cfg.Analyze} {
+		if *path != "" {
+			if abs, err := filepath.Abs(*path); err == nil {
+				*path = abs
+			}
+		}
+	}
+
+	cfg.IncludeDirs = includeDirs
+	cfg.TraceVars = traceVars
+	for _, dir := range filepath.SplitList(os.Getenv(IncludeDirsEnvVar)) {
+		if dir != "" {
+			cfg.IncludeDirs = append(cfg.IncludeDirs, dir)
+		}
+	}
+
 	return cfg
 }
 
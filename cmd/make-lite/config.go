@@ -1,13 +1,194 @@
 package main
 
+import "time"
+
 // --- Application Metadata ---
 var AppVersion = "1.2.0"
 
 const DefaultMakefile = "Makefile.mk-lite"
 
+// IncludeDirsEnvVar lists extra include search directories, separated like PATH.
+const IncludeDirsEnvVar = "MAKEFILE_DIRS"
+
+// SourceDirVar names the source tree root for recipes when targets are built in a separate output root.
+const SourceDirVar = "SRCDIR"
+
+// ProfileOutputVar is the profile variable that selects the profile's output root.
+const ProfileOutputVar = "O"
+
+// VPathVar names the variable listing the directories searched for sources that are not found in the working directory.
+const VPathVar = "VPATH"
+
+// DirDepsIgnoreVar names the variable listing patterns of files that do not count towards the freshness of `.DIRDEPS` directories.
+const DirDepsIgnoreVar = ".DIRDEPS_IGNORE"
+
+// SymlinksVar names the variable choosing whose timestamp a symbolic link has: follow, nofollow or newest.
+const SymlinksVar = ".SYMLINKS"
+
+// CacheEnvVar names the variable listing the environment variables whose values are part of the action key of `.CACHE` targets.
+const CacheEnvVar = ".CACHE_ENV"
+
+// ContainerVar sets the image recipes run in, usually as a target-specific
+// variable; ContainerEngineVar overrides the command that runs containers, and
+// ContainerEnvVar lists host environment variables passed into them.
+const (
+	ContainerVar       = ".CONTAINER"
+	ContainerEngineVar = ".CONTAINER_ENGINE"
+	ContainerEnvVar    = ".CONTAINER_ENV"
+)
+
+// ResourceLimitsVar sets how much of each `.RESOURCE:` resource the jobs of
+// a parallel build may hold at once; a resource it does not list has 1.
+const ResourceLimitsVar = ".RESOURCE_LIMITS"
+
+// CacheTokenEnvVar holds a bearer token sent to an HTTP --cache-remote.
+const CacheTokenEnvVar = "MAKE_LITE_CACHE_TOKEN"
+
+// RemoteCacheTimeout bounds each request to the shared output cache.
+const RemoteCacheTimeout = 30 * time.Second
+
+// BuildIDVar names the variable holding the unique ID of this invocation.
+const BuildIDVar = "BUILD_ID"
+
+// Version control metadata variables, filled in from the repository holding the source tree.
+const (
+	VCSTypeVar     = "VCS_TYPE"
+	VCSRevisionVar = "VCS_REVISION"
+	VCSBranchVar   = "VCS_BRANCH"
+	VCSDirtyVar    = "VCS_DIRTY"
+)
+
+// Read-only variables describing the makefiles being parsed.
+const (
+	CurDirVar       = "CURDIR"        // The directory make-lite parses the makefile in
+	MakefileListVar = "MAKEFILE_LIST" // Every makefile parsed so far, in include order
+	MakeCmdGoalsVar = "MAKECMDGOALS"  // The goals given on the command line
+)
+
+// Platform variables describing the machine make-lite runs on. They are defaults
+// that the environment or the makefile can override.
+const (
+	OSVar       = "OS"       // runtime.GOOS, e.g. linux, darwin or windows
+	ArchVar     = "ARCH"     // runtime.GOARCH, e.g. amd64 or arm64
+	HostnameVar = "HOSTNAME" // The machine's host name
+)
+
+// defaultVariableSets are the conventional variables that `.DEFAULTS: name...`
+// preloads, or all of them with --builtins or a bare `.DEFAULTS:`. Like the
+// platform variables they are only defaults: the environment and any makefile
+// assignment other than `?=` override them.
+var defaultVariableSets = map[string]map[string]string{
+	"c": {
+		"CC":      "cc",
+		"CXX":     "c++",
+		"AR":      "ar",
+		"RM":      "rm -f",
+		"INSTALL": "install",
+		"PREFIX":  "/usr/local",
+		"DESTDIR": "",
+	},
+	"go": {
+		"GO":      "go",
+		"RM":      "rm -f",
+		"INSTALL": "install",
+		"PREFIX":  "/usr/local",
+		"DESTDIR": "",
+	},
+}
+
+// builtinVariables are provided by make-lite itself, so a makefile that
+// references them does not require them from the environment.
+var builtinVariables = map[string]bool{
+	BuildIDVar:      true,
+	CurDirVar:       true,
+	MakefileListVar: true,
+	MakeCmdGoalsVar: true,
+	VCSTypeVar:      true,
+	VCSRevisionVar:  true,
+	VCSBranchVar:    true,
+	VCSDirtyVar:     true,
+	OSVar:           true,
+	ArchVar:         true,
+	HostnameVar:     true,
+	ShellStatusVar:  true,
+}
+
+// ShellStatusVar holds the exit status of the last $(shell ...) or implicit
+// shell command run during expansion.
+const ShellStatusVar = ".SHELLSTATUS"
+
+// RecipePrefixVar names the special variable whose first character marks recipe lines instead of indentation.
+const RecipePrefixVar = ".RECIPEPREFIX"
+
+// Custom functions are executables named FunctionPluginPrefix + name, looked
+// up in FunctionPluginDir under StateDir and then on PATH.
+const (
+	FunctionPluginPrefix = "make-lite-fn-"
+	FunctionPluginDir    = "functions"
+)
+
+// StateDir holds runtime state (service PID and log files, the build state
+// in BuildStateFile and build logs in BuildLogDir) relative to the working
+// directory.
+const (
+	StateDir       = ".make-lite"
+	BuildStateFile = "build-state.json"
+	BuildLogDir    = "log"
+)
+
+// AnalyzeSlowestCount is how many of the slowest recipes --analyze lists.
+const AnalyzeSlowestCount = 10
+
+// BuildLogKeep is how many build logs are kept; older ones are removed.
+const BuildLogKeep = 20
+
+// BuildLogOutputLimit is how much of each recipe's output a build log keeps,
+// from its end, where the error usually is.
+const BuildLogOutputLimit = 1 << 20
+
+// ServiceStartGrace is how long a freshly started service must stay alive to be considered healthy.
+const ServiceStartGrace = 500 * time.Millisecond
+
+// DefaultRetryDelay is the wait before the first retry of a failed recipe,
+// unless `.RETRY:` gives another one.
+const DefaultRetryDelay = time.Second
+
+// InterruptGrace is how long the recipes running when make-lite is
+// interrupted get to exit before they are killed.
+const InterruptGrace = 2 * time.Second
+
+// RecipeOutputDrain is how long a recipe command that has exited waits for
+// its output to be passed on, when it goes through make-lite rather than
+// straight to make-lite's stdout. A process it left running in the background
+// may hold the output open for good; the recipe is not kept waiting for it.
+const RecipeOutputDrain = 100 * time.Millisecond
+
+// MinFreeMemoryDefault is the --min-free-memory used when none is given.
+const MinFreeMemoryDefault = "512M"
+
+// MemoryRecheckInterval is how often a build held back for lack of free
+// memory checks again, besides whenever a running recipe finishes.
+const MemoryRecheckInterval = 500 * time.Millisecond
+
+// MemInfoPath is where Linux reports how much memory is available.
+const MemInfoPath = "/proc/meminfo"
+
+// ServiceStopTimeout is how long `stop` waits after SIGTERM before resorting to SIGKILL.
+const ServiceStopTimeout = 5 * time.Second
+
+// OutputCacheSubdir is where the outputs of `.CACHE` targets are kept under the user cache directory, by action key.
+const OutputCacheSubdir = "make-lite/outputs"
+
+// Remote includes are downloaded once and cached under the user cache directory, by digest.
+const (
+	RemoteDigestSeparator = "@sha256:"
+	RemoteCacheSubdir     = "make-lite/includes"
+	RemoteIncludeTimeout  = 30 * time.Second
+)
+
 // --- CLI UI Strings ---
 const (
-	HelpUsage         = "Usage: make-lite [options] [target]\n\n"
+	HelpUsage         = "Usage: make-lite [options] [target...]\n       make-lite help\n       make-lite docs\n       make-lite vars [--output=text|json]\n       make-lite up [service...]\n       make-lite stop|logs <service>\n       make-lite owners <file>\n       make-lite xref <variable>\n       make-lite log last\n\n"
 	HelpDescription   = "A simple, predictable build tool inspired by Make."
 	HelpOptionsHeader = "\nOptions:"
 	VersionFormat     = "make-lite version %s\n"
@@ -15,16 +196,242 @@ const (
 
 // --- Main Application Flow Messages ---
 const (
-	ErrorMakefileNotFound    = "Error: Makefile '%s' not found.\n"
-	ErrorParsingMakefile     = "Error parsing makefile: %v\n"
-	ErrorNoRulesNoTarget     = "Error: No rules found in makefile and no target specified."
-	ErrorInitEngine          = "Error initializing build engine: %v\n"
-	ErrorBuildFailed         = "Build failed: %v\n"
-	StatusUsingDefaultTarget = "make-lite: No target specified, using default target '%s'.\n"
-	StatusBuildSuccess       = "make-lite: Build finished successfully."
-	ErrorMissingDependency   = "Dependency '%s' not found for target '%s', and no rule available to create it."
-	ErrorUnsupportedFunction = "GNU Make function '$(%s ...)' is not supported."
-	WarningVarRedefined      = "make-lite: Warning: variable '%s' redefined at %s:%d. Previous definition at %s:%d. The last definition will be used.\n"
+	ErrorMakefileNotFound          = "Error: Makefile '%s' not found.\n"
+	ErrorParsingMakefile           = "Error parsing makefile: %v\n"
+	ErrorNoRulesNoTarget           = "Error: No rules found in makefile and no target specified."
+	ErrorInitEngine                = "Error initializing build engine: %v\n"
+	ErrorBuildFailed               = "Build failed: %v\n"
+	ErrorCommandFailed             = "Error: %v\n"
+	ErrorOutputDir                 = "Error: cannot use output directory: %v\n"
+	ErrorUnknownProfile            = "unknown profile '%s' (declared profiles: %s)"
+	ErrorUnknownDefaults           = "unknown .DEFAULTS set '%s' (available sets: %s)"
+	ErrorCommandNoArgs             = "'%s' does not take arguments"
+	ErrorRemoteNoDigest            = "remote include %s must pin its content with @sha256:<digest>"
+	ErrorRemoteBadDigest           = "remote include %s has an invalid sha256 digest (expected 64 hex characters)"
+	ErrorRemoteInsecure            = "remote include %s must use https://"
+	ErrorRemoteOffline             = "remote include %s is not in the cache and --offline forbids downloading it"
+	ErrorRemoteFetch               = "failed to download remote include %s: %v"
+	ErrorRemoteDigestMismatch      = "remote include %s does not match its pinned digest: expected sha256:%s, got sha256:%s"
+	ErrorRemoteCacheDir            = "failed to use the remote include cache: %w"
+	ErrorBuildState                = "failed to save the build state: %w"
+	ErrorUnknownRuleAttribute      = "unknown rule attribute '%s'; expected 'depfile'"
+	ErrorDepfile                   = "failed to read depfile '%s': %w"
+	ErrorInterrupted               = "interrupted by signal: %s"
+	ErrorAtomicRename              = "failed to move '%s' into place as '%s': %w"
+	DebugRemoteIncludeCached       = "DEBUG: Using cached copy of %s from %s\n"
+	DebugRemoteIncludeFetch        = "DEBUG: Downloading remote include %s\n"
+	ErrorStrictUndefinedVariable   = "undefined variable '%s' (strict mode)"
+	ErrorStrictDuplicateTarget     = "target '%s' is already defined at %s (strict mode)"
+	ErrorStrictMissingPrerequisite = "prerequisite '%s' of '%s' does not exist after its rule ran (strict mode)"
+	ErrorStrictTargetNotProduced   = "recipe for target '%s' succeeded but did not create it (strict mode)"
+	ErrorUnterminatedHeredoc       = "here-document is missing its terminating %q line"
+	ErrorFileFunctionOp            = "invalid $(file ...) operation in '%s'; expected <, > or >>"
+	ErrorFileFunctionNoName        = "missing file name in $(file %s)"
+	ErrorFileFunctionReadText      = "$(file %s) reads a file and takes no text argument"
+	ErrorEvalOutsideParse          = "$(eval ...) can only define variables and rules while the makefile is read, not in rule lines or recipes"
+	ErrorExpansionLineOutput       = "a line consisting of expansions must expand to nothing, got %q; use $(eval ...) to define rules or variables"
+	ErrorUnterminatedDefine        = "missing 'endef' for 'define %s'"
+	ErrorEnvFileRequired           = "required env file %s not found"
+	ErrorConfigFileRequired        = "required config file %s not found"
+	ErrorConfigFileFormat          = "cannot load config file %s: unknown format '%s'; expected .json, .yaml, .yml or .toml"
+	ErrorConfigNotMapping          = "the top level of a config file must be a mapping of keys to values"
+	ErrorConfigOption              = "invalid load_config option '%s'; expected prefix=NAME or --required"
+	DebugEnvFileMissing            = "DEBUG: env file %s not found, skipping it (use 'load_env --required' to fail instead)\n"
+	DebugConfigFileMissing         = "DEBUG: config file %s not found, skipping it (use 'load_config --required' to fail instead)\n"
+	ErrorReadOnlyVariable          = "variable '%s' is read-only"
+	ErrorConstantVariable          = "variable '%s' is read-only: it was declared constant at %s"
+	ErrorReadOnlyUndefined         = "cannot make undefined variable '%s' read-only"
+	ErrorConstantOperator          = "'const' takes a plain assignment (= or :=), not %s"
+	ErrorInvalidNamespace          = "invalid namespace '%s'; expected a name such as 'docker'"
+	ErrorCircularVariable          = "circular variable reference detected: %s"
+	ErrorUnknownShellFallback      = "unknown --shell-fallback mode '%s'; expected 'auto', 'on' or 'off'"
+	ErrorShellFallbackDisabled     = "'$(%s)' is not a variable or function; write $(shell %s) to run it as a command"
+	ErrorUnknownOutputFormat       = "unknown output format '%s'; expected 'text' or 'json'"
+	ErrorInvalidJobs               = "invalid --jobs value %d; expected 0 (one per CPU) or more"
+	ErrorInvalidSymlinks           = "invalid .SYMLINKS value '%s'; expected follow, nofollow or newest"
+	ErrorInvalidCacheRemote        = "invalid --cache-remote URL '%s'; expected http://, https:// or s3://bucket/prefix"
+	ErrorInvalidCacheRemoteMode    = "invalid --cache-remote-mode '%s'; expected read, write or readwrite"
+	ErrorCacheNotRegularFile       = "target '%s' is not a regular file"
+	ErrorS3Credentials             = "the s3:// remote cache needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"
+	ErrorInvalidSandbox            = "invalid --sandbox mode '%s'; expected warn or error"
+	ErrorInvalidPTY                = "invalid --pty mode '%s'; expected auto, on or off"
+	ErrorPTYUnsupported            = "--pty is not supported on this system"
+	ErrorSandboxSetup              = "failed to set up the recipe sandbox: %w"
+	ErrorSandboxFailed             = "%w (in the sandbox, which holds only the declared prerequisites; the recipe may read a file it does not list)"
+	ErrorInvalidRetry              = "invalid --retry value %d; expected 0 or more"
+	ErrorInvalidMinFreeMemory      = "invalid --min-free-memory value '%s'; expected an amount such as 512M or 2G, or 0"
+	ErrorContainerSyntax           = "invalid .CONTAINER; expected `.CONTAINER: target... image`, such as `.CONTAINER: build golang:1.22`"
+	ErrorNoContainerEngine         = "recipe for target '%s' runs in a container, but neither docker nor podman is on PATH; set .CONTAINER_ENGINE"
+	ErrorResourceSyntax            = "invalid .RESOURCE; expected `.RESOURCE: target name[=amount]...`, such as `.RESOURCE: link memory=8G`"
+	ErrorResourceLimits            = "invalid .RESOURCE_LIMITS '%s'; expected name=amount pairs, such as `memory=32G`"
+	ErrorRetrySyntax               = "invalid .RETRY; expected `.RETRY: target... count [delay]`, such as `.RETRY: push 3 10s`"
+	StatusUsingDefaultTarget       = "make-lite: No target specified, using default target '%s'.\n"
+	StatusBuildSuccess             = "make-lite: Build finished successfully."
+	StatusWaitingForJobs           = "make-lite: Waiting for %d unfinished job(s)...\n"
+	StatusInterrupted              = "make-lite: Interrupted by %s; stopping %d running command(s)...\n"
+	StatusDeletingTarget           = "make-lite: Deleting file '%s'\n"
+	StatusRestoredFromCache        = "make-lite: Restored '%s' from the output cache.\n"
+	StatusRetrying                 = "make-lite: Recipe for target '%s' failed: %v; retrying in %s (attempt %d of %d)\n"
+	DebugBuildID                   = "DEBUG: build ID is %s\n"
+	DebugBuildStateDiscarded       = "DEBUG: starting a new build state: %v\n"
+	DebugRestatUnchanged           = "DEBUG: '%s' was rewritten with unchanged content; its dependents stay up to date\n"
+	DebugDirDepNewest              = "DEBUG: newest file in directory '%s' is '%s'\n"
+	DebugCacheMiss                 = "DEBUG: no cached outputs of '%s' for action %s\n"
+	DebugCacheStored               = "DEBUG: stored the outputs of '%s' in %s\n"
+	DebugRemoteCacheFetched        = "DEBUG: downloaded cache entry %s\n"
+	DebugRemoteCacheStored         = "DEBUG: uploaded cache entry %s\n"
+	DebugSandboxRun                = "DEBUG: running the recipe of '%s' in sandbox %s with %d declared inputs\n"
+	DebugSandboxSkipped            = "DEBUG: not sandboxing '%s': it has a target or input outside the working directory\n"
+	DebugResourceWait              = "DEBUG: '%s' waits for resource '%s'\n"
+	DebugMemoryWait                = "DEBUG: '%s' waits for free memory (%.0fM available)\n"
+	DebugJobserverJoined           = "DEBUG: joined the jobserver %s of the parent make\n"
+	DebugContainerRun              = "DEBUG: running in container image %s with %s\n"
+	DebugAtomicRename              = "DEBUG: moved '%s' into place as '%s'\n"
+	DebugGoPackageFiles            = "DEBUG: Go package '%s' is built from %d files\n"
+	DebugDepfileRead               = "DEBUG: read %[2]d prerequisites from depfile '%[1]s'\n"
+	ErrorMissingDependency         = "Dependency '%s' not found for target '%s', and no rule available to create it."
+	ErrorUnsupportedFunction       = "GNU Make function '$(%s ...)' is not supported."
+	ErrorFunctionArgCount          = "insufficient number of arguments (%d) to function '%s' (need %d)"
+	ErrorFunctionNonNumeric        = "non-numeric %s argument to '%s' function: '%s'"
+	ErrorMathOperator              = "unknown operator '%s' in $(math ...); expected +, -, *, / or %%"
+	ErrorMathDivisionByZero        = "division by zero in $(math %s)"
+	ErrorFunctionPluginName        = "invalid custom function name '%s'"
+	ErrorFunctionPluginNotFound    = "custom function '%s' not found: no executable %s in %s or on PATH"
+	ErrorFunctionPluginFailed      = "custom function '%s' failed: %w\nstderr: %s"
+	ErrorFunctionError             = "*** %s"
+	ErrorFunctionIndexTooSmall     = "%s argument to '%s' function must be greater than 0, not %d"
+	WarningFunction                = "make-lite: Warning: %s\n"
+	WarningCacheStore              = "make-lite: Warning: could not store '%s' in the output cache: %v\n"
+	WarningCacheRestore            = "make-lite: Warning: could not restore '%s' from the output cache: %v\n"
+	WarningRemoteCacheDown         = "make-lite: Warning: remote cache unavailable, continuing with the local cache only: %v\n"
+	WarningRemoteCacheCorrupt      = "make-lite: Warning: ignoring damaged remote cache entry %s: %v\n"
+	WarningPTYUnavailable          = "make-lite: Warning: could not open a pseudo-terminal (%v); recipes run without one.\n"
+	WarningSandboxFailed           = "make-lite: Warning: recipe for target '%s' failed in the sandbox, which holds only its declared prerequisites: %v; it may read a file it does not list. Running it again in the working directory.\n"
+	WarningJobserverUnavailable    = "make-lite: Warning: jobserver unavailable, not sharing job slots with other makes: %v\n"
+	WarningTargetNotProduced       = "make-lite: Warning: recipe for target '%s' succeeded but did not create it\n"
+	WarningVarRedefined            = "make-lite: Warning: variable '%s' redefined at %s:%d. Previous definition at %s:%d. The last definition will be used.\n"
+)
+
+// --- Variable Tracing (--trace-var) ---
+const (
+	TraceVarInitial          = "make-lite: [trace %s] %s value %q\n"
+	TraceVarAssign           = "make-lite: [trace %s] %s assignment at %s sets %q (was %s): %s\n"
+	TraceVarPrevious         = "%q from %s"
+	TraceVarUndefined        = "undefined"
+	TraceVarScope            = "make-lite: [trace %s] rule-specific value %q in effect\n"
+	TraceVarExpand           = "make-lite: [trace %s] expands to %q (%s)\n"
+	TraceVarExpandUndefined  = "make-lite: [trace %s] referenced while undefined\n"
+	TraceDecisionApplied     = "applied"
+	TraceDecisionAppended    = "appended"
+	TraceDecisionOverride    = "applied, overrides the %s value"
+	TraceDecisionConditional = "ignored, ?= only sets undefined variables"
+	TraceDecisionPrecedence  = "ignored, the %s value has higher precedence"
+	TraceOriginLocal         = "foreach or call argument"
+	TraceOriginRule          = "target- or pattern-specific"
+)
+
+// --- Service Messages ---
+const (
+	StatusServiceStarted     = "make-lite: Service '%s' started (pid %d), logging to %s.\n"
+	StatusServiceRunning     = "make-lite: Service '%s' is already running (pid %d).\n"
+	StatusServiceStopped     = "make-lite: Service '%s' stopped.\n"
+	StatusServiceNotRunning  = "make-lite: Service '%s' is not running.\n"
+	ErrorServiceExited       = "service '%s' exited during startup; see %s"
+	ErrorNotAService         = "target '%s' is not declared as a service"
+	ErrorServiceNoLogs       = "no logs found for service '%s'"
+	ErrorPortInUse           = "port %d required by service '%s' is already in use by %s"
+	ErrorServiceNameRequired = "'%s' requires exactly one service name"
+	ErrorNoServices          = "no services are declared in the makefile"
+	StatusUpStreaming        = "make-lite: Streaming logs for %s. Press Ctrl-C to stop.\n"
+	StatusUpStopping         = "make-lite: Stopping services...\n"
+	StatusServiceExited      = "make-lite: Service '%s' exited.\n"
+	ServiceLogLineFormat     = "%-*s | %s\n"
+	ServiceLogStartFormat    = "make-lite: build %s starting service '%s' at %s\n"
+)
+
+// --- Target List Messages ---
+const (
+	StatusTargetListHeader = "Targets (default: %s):\n"
+	TargetListFormat       = "  %-*s  %s\n"
+	TargetGroupHeader      = "\n%s:\n"
+	StatusNoTargets        = "No targets are defined.\n"
+)
+
+// --- Owners Messages ---
+const (
+	StatusOwnersHeader   = "Rules that read %s:\n"
+	StatusOwnersIndirect = "Also rebuilt as a consequence:\n"
+	StatusNoOwners       = "No rule reads %s.\n"
+	ErrorOwnersArgs      = "'owners' expects exactly one file path"
+)
+
+// --- Xref Messages ---
+const (
+	StatusXrefAssignments = "Assignments of %s:\n"
+	StatusXrefReferences  = "References to %s:\n"
+	StatusXrefNone        = "  (none)\n"
+	ErrorXrefArgs         = "'xref' expects exactly one variable name"
+)
+
+// --- Build Log Messages ---
+const (
+	WarningBuildLog         = "make-lite: Warning: could not start the build log: %v\n"
+	ErrorLogArgs            = "'log' expects 'last'"
+	ErrorNoBuildLog         = "no build log in %s yet; every build writes one"
+	BuildLogHeader          = "Build %s of '%s', started %s in %s\n"
+	BuildLogFile            = "Log: %s\n\n"
+	BuildLogRuleFormat      = "%-6s %s (%s)%s\n"
+	BuildLogRuleOK          = "ok"
+	BuildLogRuleFailed      = "FAILED"
+	BuildLogCommandFormat   = "    $ %s\n"
+	BuildLogOutputFormat    = "    | %s\n"
+	BuildLogOutputTruncated = "    | (earlier output dropped)\n"
+	BuildLogSucceeded       = "\nBuild succeeded after %s.\n"
+	BuildLogFailed          = "\nBuild failed after %s: %s\n"
+	BuildLogUnfinished      = "\nBuild did not finish; it was killed or is still running.\n"
+)
+
+// --- Build Analysis Messages ---
+const (
+	StatusCriticalPath     = "make-lite: Critical path: %s of %s wall time\n"
+	StatusSlowestRecipes   = "make-lite: Slowest recipes (%d):\n"
+	StatusAnalyzeNoRecipes = "  (no recipe ran)\n"
+	AnalyzeSpanFormat      = "  %-*s  %s\n"
+	StatusAnalyzeTrace     = "make-lite: Trace written to %s; open it in chrome://tracing or https://ui.perfetto.dev.\n"
+	ErrorAnalyzeTrace      = "failed to write the trace to %s: %w"
+)
+
+// --- Mutex Messages ---
+const (
+	StatusMutexWaiting = "make-lite: Waiting for mutex '%s' held by another process...\n"
+	ErrorMutexLock     = "failed to lock mutex '%s': %w"
+)
+
+// --- Persistent Worker Messages ---
+const (
+	ErrorUnknownWorker = "unknown worker '%s'; declare it with 'worker %[1]s:'"
+	ErrorWorkerExited  = "worker '%s' exited unexpectedly: %v"
+	ErrorWorkerFailed  = "worker '%s' reported exit code %d"
+	DebugWorkerRequest = "DEBUG: sending request to worker '%s': [%s]\n"
+)
+
+// --- I/O Contract Messages ---
+const (
+	ErrorIOContract        = "I/O contract violated:\n  - %s"
+	ViolationNotProduced   = "declared output '%s' was not produced"
+	ViolationNotModified   = "declared output '%s' was not modified"
+	ViolationUndeclared    = "undeclared file '%s' was written"
+	ViolationListSeparator = "\n  - "
+)
+
+// --- Multi-Goal Summary ---
+const (
+	StatusGoalSummary = "make-lite: Goal summary: build %s\n"
+	GoalStatusFormat  = "  %-*s  %-10s %s\n"
+	GoalBuilt         = "built"
+	GoalUpToDate      = "up to date"
+	GoalFailed        = "failed"
+	GoalSkipped       = "skipped"
 )
 
 // --- Engine Status Messages ---
@@ -32,10 +439,13 @@ const (
 	StatusBuildingTarget        = "make-lite: Building target '%s'.\n"
 	StatusBuildingTargetBecause = "make-lite: Building target '%s' because %s.\n"
 	StatusTargetsUpToDate       = "make-lite: Targets '%s' are up to date.\n"
+	StatusErrorIgnored          = "make-lite: [%s] %v (ignored)\n"
+	DebugVPathFound             = "make-lite: Found source '%s' at '%s'.\n"
 	DebugExecutingCommand       = "DEBUG: executing recipe command: [%s]\n"
 	DebugShellCommand           = "DEBUG: executing shell command: [%s]\n"
 	DebugShellStdout            = "DEBUG: shell stdout: [%s]\n"
 	DebugShellStderr            = "DEBUG: shell stderr: [%s]\n"
+	DebugFunctionPlugin         = "DEBUG: running custom function '%s' (%s) with arguments %q\n"
 )
 
 // --- Parser Configuration ---
@@ -43,33 +453,5 @@ const (
 // unsupportedMakeFunctions is a set of common GNU Make functions that make-lite
 // explicitly does not support. Attempting to use them will result in an error.
 var unsupportedMakeFunctions = map[string]struct{}{
-	"subst":      {},
-	"patsubst":   {},
-	"strip":      {},
-	"findstring": {},
-	"filter":     {},
-	"filter-out": {},
-	"sort":       {},
-	"word":       {},
-	"words":      {},
-	"wordlist":   {},
-	"firstword":  {},
-	"lastword":   {},
-	"dir":        {},
-	"notdir":     {},
-	"suffix":     {},
-	"basename":   {},
-	"addsuffix":  {},
-	"addprefix":  {},
-	"join":       {},
-	"foreach":    {},
-	"if":         {},
-	"or":         {},
-	"and":        {},
-	"call":       {},
-	"origin":     {},
-	"value":      {},
-	"info":       {},
-	"warning":    {},
-	"error":      {},
+	"value": {},
 }
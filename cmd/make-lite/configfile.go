@@ -0,0 +1,665 @@
+// cmd/make-lite/configfile.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile reads a JSON, YAML or TOML file, chosen by its extension, and
+// defines a variable for every value in it. Nested keys are joined with `_`
+// after the prefix, so `service: {port: 8080}` becomes `<prefix>service_port`.
+// The values are defaults, as if assigned with `?=`. A missing file is skipped
+// unless it is required.
+func (p *Parser) loadConfigFile(filename, prefix string, required bool) error {
+	var parse func(content []byte) ([]envEntry, error)
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".json":
+		parse = parseJSONConfig
+	case ".yaml", ".yml":
+		parse = func(content []byte) ([]envEntry, error) { return parseYAMLConfig(string(content)) }
+	case ".toml":
+		parse = func(content []byte) ([]envEntry, error) { return parseTOMLConfig(string(content)) }
+	default:
+		return fmt.Errorf(ErrorConfigFileFormat, filename, ext)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			if p.variableStore.isDebug {
+				fmt.Fprintf(os.Stderr, DebugConfigFileMissing, filename)
+			}
+			return nil
+		}
+		if os.IsNotExist(err) {
+			return fmt.Errorf(ErrorConfigFileRequired, filename)
+		}
+		return fmt.Errorf("could not load config file %s: %w", filename, err)
+	}
+	entries, err := parse(content)
+	if err != nil {
+		return fmt.Errorf("in config file %s: %w", filename, err)
+	}
+	for _, entry := range entries {
+		name := prefix + entry.key
+		p.variableStore.Set(name, entry.value, sourceMakefileConditional, filename, entry.line)
+		p.variables = append(p.variables, &VariableDef{
+			Name:     name,
+			RawValue: entry.value,
+			Op:       opLoadConfig,
+			Origin:   traceLocation(filename, entry.line),
+		})
+	}
+	return nil
+}
+
+// configKey joins the path to a value into a variable name. Characters that
+// cannot appear in a name, such as `-` or `.`, become `_`.
+func configKey(path []string) string {
+	key := []byte(strings.Join(path, "_"))
+	for i, c := range key {
+		if !isVariableChar(c) {
+			key[i] = '_'
+		}
+	}
+	return string(key)
+}
+
+// configList joins the items of a list of scalars into a word list. An item
+// containing blanks is quoted, so rule lines still see it as one name.
+func configList(items []string) string {
+	for i, item := range items {
+		if strings.ContainsAny(item, " \t") {
+			items[i] = strconv.Quote(item)
+		}
+	}
+	return strings.Join(items, " ")
+}
+
+// parseJSONConfig flattens a JSON document in the order its keys are written.
+// Numbers are kept as written and null is empty.
+func parseJSONConfig(content []byte) ([]envEntry, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	lineAt := func() int {
+		return bytes.Count(content[:dec.InputOffset()], []byte("\n")) + 1
+	}
+	var entries []envEntry
+	var flatten func(path []string, tok json.Token) error
+	flatten = func(path []string, tok json.Token) error {
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				value, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := flatten(append(path[:len(path):len(path)], key.(string)), value); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+		case json.Delim('['):
+			line := lineAt()
+			items := []string{}
+			nested := false
+			for i := 0; dec.More(); i++ {
+				value, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if delim, ok := value.(json.Delim); ok {
+					nested = true
+					if err := flatten(append(path[:len(path):len(path)], strconv.Itoa(i)), delim); err != nil {
+						return err
+					}
+					continue
+				}
+				items = append(items, jsonScalar(value))
+			}
+			if len(items) > 0 || !nested {
+				entries = append(entries, envEntry{key: configKey(path), value: configList(items), line: line})
+			}
+			_, err := dec.Token()
+			return err
+		default:
+			entries = append(entries, envEntry{key: configKey(path), value: jsonScalar(tok), line: lineAt()})
+			return nil
+		}
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("line 1: %s", ErrorConfigNotMapping)
+	}
+	if err := flatten(nil, tok); err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineAt(), err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("line %d: unexpected data after the top-level object", lineAt())
+	}
+	return entries, nil
+}
+
+// jsonScalar formats a JSON string, number, boolean or null as a value.
+func jsonScalar(tok json.Token) string {
+	switch v := tok.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// yamlFrame is a mapping or sequence that the lines being read belong to.
+type yamlFrame struct {
+	indent   int      // Indentation of the keys or `-` items in it
+	path     []string // Path of the mapping or sequence
+	sequence bool     // Its lines are `-` items
+	items    int      // Number of mapping items seen, for a sequence
+}
+
+// parseYAMLConfig flattens the common subset of YAML used for configuration:
+// nested block mappings, block sequences of scalars or mappings, flow
+// sequences of scalars, plain and quoted scalars, `|` and `>` block scalars
+// and comments. Anchors, aliases, tags and flow mappings are rejected.
+func parseYAMLConfig(content string) ([]envEntry, error) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var entries []envEntry
+	lists := make(map[string]int) // Entry index of each sequence of scalars, by key
+	stack := []yamlFrame{{indent: -1}}
+	for i := 0; i < len(lines); i++ {
+		raw := lines[i]
+		text := strings.TrimRight(stripYAMLComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		indent := len(text) - len(trimmed)
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+		}
+		item, isItem := cutYAMLItem(trimmed)
+		for len(stack) > 1 {
+			if top := stack[len(stack)-1]; top.indent > indent || !isItem && top.sequence && top.indent == indent {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			break
+		}
+		if len(stack) == 1 {
+			stack = append(stack, yamlFrame{indent: indent})
+		}
+		top := &stack[len(stack)-1]
+		if top.indent != indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+
+		if isItem {
+			if len(top.path) == 0 {
+				return nil, fmt.Errorf("line %d: %s", i+1, ErrorConfigNotMapping)
+			}
+			top.sequence = true
+			if key, value, isMap := cutYAMLKey(item); isMap {
+				// `- key: value` starts a mapping; its other keys are indented to match.
+				path := append(top.path[:len(top.path):len(top.path)], strconv.Itoa(top.items))
+				top.items++
+				itemIndent := indent + len(trimmed) - len(item)
+				stack = append(stack, yamlFrame{indent: itemIndent, path: path})
+				next, err := yamlValue(&entries, &stack, lines, i, itemIndent, key, value)
+				if err != nil {
+					return nil, err
+				}
+				i = next
+				continue
+			}
+			value, err := yamlScalar(item, i+1)
+			if err != nil {
+				return nil, err
+			}
+			key := configKey(top.path)
+			if at, ok := lists[key]; ok {
+				entries[at].value += " " + configList([]string{value})
+			} else {
+				lists[key] = len(entries)
+				entries = append(entries, envEntry{key: key, value: configList([]string{value}), line: i + 1})
+			}
+			continue
+		}
+
+		key, value, ok := cutYAMLKey(trimmed)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key: value' or '- item', got %q", i+1, trimmed)
+		}
+		next, err := yamlValue(&entries, &stack, lines, i, indent, key, value)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+	}
+	return entries, nil
+}
+
+// yamlValue handles `key: value` on line i, whose key is at the given indent
+// within the innermost frame, and returns the last line it used.
+func yamlValue(entries *[]envEntry, stack *[]yamlFrame, lines []string, i, indent int, key, value string) (int, error) {
+	frame := (*stack)[len(*stack)-1]
+	path := append(frame.path[:len(frame.path):len(frame.path)], key)
+	switch {
+	case value == "":
+		// A nested mapping or sequence follows, if the next line is indented
+		// further or starts a `-` item; otherwise the value is empty.
+		if next, ok := nextYAMLIndent(lines, i, indent); ok {
+			*stack = append(*stack, yamlFrame{indent: next, path: path})
+		} else {
+			*entries = append(*entries, envEntry{key: configKey(path), line: i + 1})
+		}
+		return i, nil
+	case value[0] == '|' || value[0] == '>':
+		text, last := yamlBlockScalar(lines, i, indent, value)
+		*entries = append(*entries, envEntry{key: configKey(path), value: text, line: i + 1})
+		return last, nil
+	case value[0] == '[':
+		items, err := yamlFlowSequence(value, i+1)
+		if err != nil {
+			return i, err
+		}
+		*entries = append(*entries, envEntry{key: configKey(path), value: configList(items), line: i + 1})
+		return i, nil
+	}
+	scalar, err := yamlScalar(value, i+1)
+	if err != nil {
+		return i, err
+	}
+	*entries = append(*entries, envEntry{key: configKey(path), value: scalar, line: i + 1})
+	return i, nil
+}
+
+// nextYAMLIndent returns the indentation of the content nested under a key
+// with an empty value on line i, and false if nothing is nested under it.
+func nextYAMLIndent(lines []string, i, indent int) (int, bool) {
+	for _, line := range lines[i+1:] {
+		text := strings.TrimRight(stripYAMLComment(line), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		next := len(text) - len(trimmed)
+		_, isItem := cutYAMLItem(trimmed)
+		return next, next > indent || next == indent && isItem
+	}
+	return 0, false
+}
+
+// cutYAMLItem recognizes a `- item` sequence entry.
+func cutYAMLItem(line string) (string, bool) {
+	if line == "-" {
+		return "", true
+	}
+	item, ok := strings.CutPrefix(line, "- ")
+	return strings.TrimLeft(item, " "), ok
+}
+
+// cutYAMLKey splits `key: value`. The key may be quoted.
+func cutYAMLKey(line string) (string, string, bool) {
+	if line != "" && (line[0] == '"' || line[0] == '\'') {
+		end := strings.IndexByte(line[1:], line[0])
+		if end < 0 || !strings.HasPrefix(line[end+2:], ":") {
+			return "", "", false
+		}
+		return line[1 : end+1], strings.TrimSpace(line[end+3:]), true
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] == ':' && (i+1 == len(line) || line[i+1] == ' ') {
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a ` # comment`, leaving `#` inside quotes alone.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == ':' || line[i-1] == '[' || line[i-1] == ',' || line[i-1] == '-' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar decodes a plain, single-quoted or double-quoted scalar. `~` and
+// `null` are empty.
+func yamlScalar(value string, line int) (string, error) {
+	switch {
+	case value == "" || value == "~" || value == "null":
+		return "", nil
+	case value[0] == '&' || value[0] == '*' || value[0] == '!':
+		return "", fmt.Errorf("line %d: YAML anchors, aliases and tags are not supported", line)
+	case value[0] == '{':
+		return "", fmt.Errorf("line %d: YAML flow mappings are not supported; use an indented block", line)
+	case value[0] == '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return "", fmt.Errorf("line %d: unterminated quoted string %s", line, value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case value[0] == '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("line %d: invalid quoted string %s", line, value)
+		}
+		return unquoted, nil
+	}
+	return value, nil
+}
+
+// yamlFlowSequence decodes a one-line `[a, "b c", 3]` sequence of scalars.
+func yamlFlowSequence(value string, line int) ([]string, error) {
+	inner, ok := strings.CutSuffix(value[1:], "]")
+	if !ok {
+		return nil, fmt.Errorf("line %d: flow sequences must end on the same line: %s", line, value)
+	}
+	items := []string{}
+	for _, part := range splitOutsideQuotes(inner, ',') {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		if part[0] == '[' {
+			return nil, fmt.Errorf("line %d: nested flow sequences are not supported", line)
+		}
+		item, err := yamlScalar(part, line)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// yamlBlockScalar reads the `|` (literal) or `>` (folded) block scalar that
+// follows line i and returns it with the index of its last line. A `-`
+// indicator strips the final newline, which is never kept in a variable anyway.
+func yamlBlockScalar(lines []string, i, indent int, header string) (string, int) {
+	var body []string
+	last := i
+	blockIndent := -1
+	for j := i + 1; j < len(lines); j++ {
+		line := strings.TrimRight(lines[j], " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			body = append(body, "")
+			continue
+		}
+		lineIndent := len(line) - len(trimmed)
+		if lineIndent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = lineIndent
+		}
+		body = append(body, line[min(blockIndent, lineIndent):])
+		last = j
+	}
+	body = body[:last-i]
+	if header[0] == '>' {
+		return strings.TrimRight(strings.Join(body, " "), " "), last
+	}
+	return strings.TrimRight(strings.Join(body, "\n"), "\n"), last
+}
+
+// splitOutsideQuotes splits s at every sep that is not inside quotes.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseTOMLConfig flattens the common subset of TOML: `[table]` and
+// `[[array.of.tables]]` headers, bare, quoted and dotted keys, strings
+// (including multi-line ones), numbers, booleans, dates, arrays of scalars,
+// which may span lines, and one-level inline tables.
+func parseTOMLConfig(content string) ([]envEntry, error) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var entries []envEntry
+	var table []string
+	tableItems := make(map[string]int) // Number of `[[name]]` tables so far, by name
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if header, ok := strings.CutPrefix(line, "[["); ok {
+			name, ok := strings.CutSuffix(header, "]]")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid array of tables header %s", i+1, line)
+			}
+			path := tomlKeyPath(name)
+			key := strings.Join(path, ".")
+			table = append(path, strconv.Itoa(tableItems[key]))
+			tableItems[key]++
+			continue
+		}
+		if header, ok := strings.CutPrefix(line, "["); ok {
+			name, ok := strings.CutSuffix(header, "]")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid table header %s", i+1, line)
+			}
+			table = tomlKeyPath(name)
+			continue
+		}
+		key, value, ok := splitTOMLAssignment(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key = value', got %q", i+1, line)
+		}
+		start := i
+		// Arrays and multi-line strings continue until they are closed.
+		for !tomlValueComplete(value) && i+1 < len(lines) {
+			i++
+			if strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+				value += "\n" + lines[i]
+			} else {
+				value += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+			}
+		}
+		path := append(table[:len(table):len(table)], tomlKeyPath(key)...)
+		if inner, ok := strings.CutPrefix(value, "{"); ok {
+			fields, ok := strings.CutSuffix(inner, "}")
+			if !ok {
+				return nil, fmt.Errorf("line %d: inline tables must end on the same line", start+1)
+			}
+			for _, field := range splitOutsideQuotes(fields, ',') {
+				if strings.TrimSpace(field) == "" {
+					continue
+				}
+				fieldKey, fieldValue, ok := splitTOMLAssignment(strings.TrimSpace(field))
+				if !ok {
+					return nil, fmt.Errorf("line %d: invalid inline table field %q", start+1, field)
+				}
+				decoded, err := tomlValue(fieldValue, start+1)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, envEntry{key: configKey(append(path[:len(path):len(path)], tomlKeyPath(fieldKey)...)), value: decoded, line: start + 1})
+			}
+			continue
+		}
+		decoded, err := tomlValue(value, start+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, envEntry{key: configKey(path), value: decoded, line: start + 1})
+	}
+	return entries, nil
+}
+
+// splitTOMLAssignment splits `key = value` at the first `=` outside a quoted key.
+func splitTOMLAssignment(line string) (string, string, bool) {
+	parts := splitOutsideQuotes(line, '=')
+	if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(line[len(parts[0])+1:]), true
+}
+
+// tomlKeyPath splits a dotted key such as `server."host name".port`.
+func tomlKeyPath(key string) []string {
+	var path []string
+	for _, part := range splitOutsideQuotes(key, '.') {
+		path = append(path, trimQuotes(strings.TrimSpace(part)))
+	}
+	return path
+}
+
+// stripTOMLComment removes a `# comment` outside strings.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlValueComplete reports whether a value is whole or continues on the next
+// line, as an array whose brackets are not yet balanced or an unterminated
+// multi-line string does.
+func tomlValueComplete(value string) bool {
+	for _, delim := range []string{`"""`, "'''"} {
+		if rest, ok := strings.CutPrefix(value, delim); ok {
+			return strings.Contains(rest, delim)
+		}
+	}
+	if !strings.HasPrefix(value, "[") {
+		return true
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// tomlUnescape decodes the escapes of a TOML basic string body.
+func tomlUnescape(body string, line int) (string, error) {
+	var b strings.Builder
+	for body != "" {
+		r, _, rest, err := strconv.UnquoteChar(body, 0)
+		if err != nil {
+			return "", fmt.Errorf("line %d: invalid escape in string", line)
+		}
+		b.WriteRune(r)
+		body = rest
+	}
+	return b.String(), nil
+}
+
+// tomlValue decodes a string, number, boolean, date or array of those.
+func tomlValue(value string, line int) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"""`):
+		return tomlUnescape(strings.TrimPrefix(strings.TrimSuffix(value[3:], `"""`), "\n"), line)
+	case strings.HasPrefix(value, "'''"):
+		return strings.TrimPrefix(strings.TrimSuffix(value[3:], "'''"), "\n"), nil
+	case strings.HasPrefix(value, `"`):
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return "", fmt.Errorf("line %d: unterminated string %s", line, value)
+		}
+		return tomlUnescape(value[1:len(value)-1], line)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("line %d: unterminated string %s", line, value)
+		}
+		return value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, "["):
+		inner, ok := strings.CutSuffix(value[1:], "]")
+		if !ok {
+			return "", fmt.Errorf("line %d: unterminated array", line)
+		}
+		var items []string
+		for _, part := range splitOutsideQuotes(inner, ',') {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			if part[0] == '[' || part[0] == '{' {
+				return "", fmt.Errorf("line %d: arrays of arrays or tables are not supported; use [[tables]]", line)
+			}
+			item, err := tomlValue(part, line)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return configList(items), nil
+	case value == "":
+		return "", fmt.Errorf("line %d: missing value", line)
+	}
+	// Numbers, booleans and dates are kept as written, without digit separators.
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+	return value, nil
+}
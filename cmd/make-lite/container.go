@@ -0,0 +1,163 @@
+// cmd/make-lite/container.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// containerBuildEnv are the variables make-lite itself sets for recipes,
+// which are passed into containers like those the makefile computes.
+var containerBuildEnv = []string{BuildIDVar, SourceDirVar, VCSTypeVar, VCSRevisionVar, VCSBranchVar, VCSDirtyVar}
+
+// containerEnv picks the variables of a recipe's environment that go into
+// its container: those the makefile sets or changes, make-lite's own, and
+// the host variables listed in `.CONTAINER_ENV`. The rest of the host
+// environment, such as PATH, HOME and GOPATH, describes the host, and would
+// hide the image's own settings. The caller holds the rule's scope.
+func (e *Engine) containerEnv(env []string) []string {
+	passed := make(map[string]bool)
+	for _, name := range containerBuildEnv {
+		passed[name] = true
+	}
+	names, _ := e.vars.Get(ContainerEnvVar)
+	for _, name := range splitWords(names) {
+		passed[name] = true
+	}
+	var selected []string
+	for _, pair := range env {
+		name, value, _ := strings.Cut(pair, "=")
+		if inherited, ok := os.LookupEnv(name); passed[name] || !ok || inherited != value {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// parseContainer reads the prerequisites of `.CONTAINER: target... image`
+// and records the image for each target.
+func (m *Makefile) parseContainer(words []string) error {
+	if len(words) < 2 {
+		return errors.New(ErrorContainerSyntax)
+	}
+	if m.Containers == nil {
+		m.Containers = make(map[string]string)
+	}
+	image := words[len(words)-1]
+	for _, target := range words[:len(words)-1] {
+		m.Containers[target] = image
+	}
+	return nil
+}
+
+// containerImage returns the image a rule's recipe runs in: the one
+// `.CONTAINER:` gives any of its targets, or else the `.CONTAINER` variable
+// in the rule's scope, which a target-specific assignment sets for one rule
+// and a global one for all. It is "" for recipes run on the host. The caller
+// holds the rule's scope.
+func (e *Engine) containerImage(rule *Rule) string {
+	for _, target := range rule.Targets {
+		if image, ok := e.makefile.Containers[target]; ok {
+			return image
+		}
+	}
+	image, _ := e.vars.Get(ContainerVar)
+	return strings.TrimSpace(image)
+}
+
+// containerRun is how a recipe runs in a container.
+type containerRun struct {
+	image  string
+	engine []string // Command that runs containers, with its leading arguments
+	env    []string // Names of the variables passed in
+}
+
+// recipeContainer returns how a rule's recipe runs in a container, or nil
+// for a recipe run on the host. The caller holds the rule's scope.
+func (e *Engine) recipeContainer(rule *Rule) (*containerRun, error) {
+	image := e.containerImage(rule)
+	if image == "" {
+		return nil, nil
+	}
+	engine, err := e.containerEngine(rule)
+	if err != nil {
+		return nil, err
+	}
+	return &containerRun{image: image, engine: engine, env: e.containerEnv(e.vars.getEnvironment())}, nil
+}
+
+// containerEngine returns the command that runs containers: the words of
+// `.CONTAINER_ENGINE`, such as `podman` or `sudo docker`, or else docker or
+// podman, whichever is found first on PATH. The caller holds the rule's scope.
+func (e *Engine) containerEngine(rule *Rule) ([]string, error) {
+	value, _ := e.vars.Get(ContainerEngineVar)
+	if words := splitWords(value); len(words) > 0 {
+		return words, nil
+	}
+	for _, name := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return []string{name}, nil
+		}
+	}
+	return nil, fmt.Errorf(ErrorNoContainerEngine, rule.Targets[0])
+}
+
+// recipeCommand returns the command that runs one shell script of a recipe:
+// `sh -c script` on the host, or, for a rule with a container image, the same
+// inside a throwaway container. The working directory, and the source tree
+// of an out-of-tree build, are mounted at their own paths, so absolute paths
+// mean the same inside and out, and the recipe starts where it would on the
+// host. Variables are passed by name with `-e`, taking their values from the
+// engine's environment, so secrets never appear on its command line. The
+// container runs with an init process that forwards interruptions to the
+// recipe, and the engine exits with the recipe's exit code. Docker runs it as
+// the invoking user, so the files it writes are not owned by root; rootless
+// Podman maps root to that user already.
+func (e *Engine) recipeCommand(rule *Rule, script string, env []string, container *containerRun) (*exec.Cmd, error) {
+	if container == nil {
+		cmd := exec.Command(e.shellPath, "-c", script)
+		cmd.Dir = rule.Sandbox
+		cmd.Env = env
+		if e.jobserver != nil {
+			cmd.Env, cmd.ExtraFiles = e.jobserver.attach(env)
+		}
+		return cmd, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	workDir := wd
+	if rule.Sandbox != "" {
+		workDir = rule.Sandbox
+	}
+	engine := container.engine
+	args := append(append([]string(nil), engine[1:]...), "run", "--rm", "--init", "-w", workDir)
+	for _, dir := range []string{wd, e.opts.SourceDir, rule.Sandbox} {
+		if dir != "" && (dir == wd || !strings.HasPrefix(dir, wd+string(filepath.Separator))) {
+			args = append(args, "-v", dir+":"+dir)
+		}
+	}
+	if e.ptyEnabled() {
+		// The engine's output is a pseudo-terminal; the recipe gets one too.
+		args = append(args, "-t")
+	}
+	if filepath.Base(engine[0]) == "docker" && os.Getuid() >= 0 {
+		args = append(args, "--user", strconv.Itoa(os.Getuid())+":"+strconv.Itoa(os.Getgid()))
+	}
+	for _, name := range container.env {
+		args = append(args, "-e", name)
+	}
+	args = append(args, container.image, "sh", "-c", script)
+	if e.isDebug {
+		fmt.Fprintf(os.Stderr, DebugContainerRun, container.image, strings.Join(engine, " "))
+	}
+	cmd := exec.Command(engine[0], args...)
+	cmd.Env = env
+	return cmd, nil
+}
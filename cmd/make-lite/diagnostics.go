@@ -0,0 +1,112 @@
+// cmd/make-lite/diagnostics.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseError is a makefile error tied to a location. Its message ends with the
+// offending source lines, all of them for a continued line, and a caret under
+// the column the error refers to.
+type ParseError struct {
+	File      string
+	Line      int      // First physical line
+	EndLine   int      // Last physical line; greater than Line for continued lines
+	CaretLine int      // Physical line holding the caret
+	Column    int      // 1-based byte column of the caret within CaretLine
+	Source    []string // Physical lines Line through EndLine, if the file could be read
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	if e.EndLine > e.Line {
+		fmt.Fprintf(&b, "at %s:%d-%d: %v", e.File, e.Line, e.EndLine, e.Err)
+	} else {
+		fmt.Fprintf(&b, "at %s:%d: %v", e.File, e.Line, e.Err)
+	}
+	width := len(strconv.Itoa(e.EndLine))
+	for i, text := range e.Source {
+		lineNumber := e.Line + i
+		fmt.Fprintf(&b, "\n %*d | %s", width, lineNumber, text)
+		if lineNumber == e.CaretLine {
+			fmt.Fprintf(&b, "\n %*s | %s^", width, "", caretPadding(text, e.Column))
+		}
+	}
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// caretPadding returns the blanks that put a caret under a column of text,
+// keeping tabs so the caret lines up however the terminal renders them.
+func caretPadding(text string, column int) string {
+	var pad strings.Builder
+	for i, r := range text {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return pad.String()
+}
+
+// position maps a byte offset in a line's content to the physical line and
+// 1-based column it came from, following backslash continuations.
+func (l processedLine) position(offset int) (int, int) {
+	for i, part := range l.parts {
+		if i == len(l.parts)-1 {
+			return l.originLine + i, offset + 1
+		}
+		// joinContinuations drops trailing blanks and the backslash of each continued part.
+		n := len(strings.TrimRight(part, " \t")) - 1
+		if offset < n {
+			return l.originLine + i, offset + 1
+		}
+		offset -= n
+	}
+	return l.originLine, offset + 1
+}
+
+// errorAt returns a ParseError for a line, with the caret at a byte offset in
+// its content, or at its first non-blank character if offset is negative.
+func (p *Parser) errorAt(line processedLine, offset int, format string, args ...any) error {
+	if offset < 0 {
+		offset = len(line.content) - len(strings.TrimLeft(line.content, " \t"))
+	}
+	caretLine, column := line.position(offset)
+	endLine := line.originLine
+	if len(line.parts) > 0 {
+		endLine += len(line.parts) - 1
+	}
+	var source []string
+	if lines := p.sources[line.originFile]; line.originLine >= 1 && endLine <= len(lines) {
+		source = lines[line.originLine-1 : endLine]
+	}
+	return &ParseError{
+		File:      line.originFile,
+		Line:      line.originLine,
+		EndLine:   endLine,
+		CaretLine: caretLine,
+		Column:    column,
+		Source:    source,
+		Err:       fmt.Errorf(format, args...),
+	}
+}
+
+// offsetOf returns the offset of the first occurrence of s in a line's
+// content, or -1 to fall back to the start of the line.
+func (l processedLine) offsetOf(s string) int {
+	if s == "" {
+		return -1
+	}
+	return strings.Index(l.content, s)
+}
@@ -0,0 +1,87 @@
+// cmd/make-lite/dirdeps.go
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirTree is the newest file found in a directory prerequisite's tree.
+type dirTree struct {
+	newest  string // Path of the newest file, or "" for a tree without files
+	modTime time.Time
+}
+
+// isDirDep reports whether a directory prerequisite is listed in
+// `.DIRDEPS:`, or `.DIRDEPS:` has no list.
+func (e *Engine) isDirDep(name string) bool {
+	return e.makefile.DirDeps.All || e.makefile.DirDeps.Targets[filepath.Clean(name)]
+}
+
+// ignoredInTree reports whether a path inside a directory prerequisite
+// matches one of the `.DIRDEPS_IGNORE` patterns. A pattern with a slash is
+// matched against the path relative to the directory, any other against the
+// base name, so `*.tmp` ignores temporary files at any depth.
+func ignoredInTree(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		name := filepath.Base(rel)
+		if strings.Contains(pattern, "/") {
+			name = filepath.ToSlash(rel)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newestInTree finds the most recently modified file in a directory tree,
+// skipping what `.DIRDEPS_IGNORE` matches; an ignored directory is not
+// entered. The modification times of directories themselves do not count, as
+// creating an ignored file changes them too. Symbolic links to files are
+// timed under `.SYMLINKS`; links to directories are not followed, so a link
+// back up the tree cannot loop. Results are kept until the next recipe runs,
+// which may write into the tree.
+func (e *Engine) newestInTree(dir string) dirTree {
+	e.statMu.Lock()
+	tree, ok := e.trees[dir]
+	e.statMu.Unlock()
+	if ok {
+		return tree
+	}
+	ignore, _ := e.vars.Get(DirDepsIgnoreVar)
+	patterns := splitWords(ignore)
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if ignoredInTree(rel, patterns) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			info, err = e.statLink(path)
+		}
+		if err == nil && !info.IsDir() && info.ModTime().After(tree.modTime) {
+			tree = dirTree{newest: path, modTime: info.ModTime()}
+		}
+		return nil
+	})
+	if e.isDebug {
+		fmt.Printf(DebugDirDepNewest, dir, tree.newest)
+	}
+	e.statMu.Lock()
+	e.trees[dir] = tree
+	e.statMu.Unlock()
+	return tree
+}
@@ -0,0 +1,123 @@
+// cmd/make-lite/docs.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeDocs renders a Markdown reference of the makefile's targets and
+// variables, generated from the parsed structures.
+func writeDocs(w io.Writer, makefile *Makefile, makefileName string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Building\n\n")
+	fmt.Fprintf(&b, "_Generated by `make-lite docs` from `%s`. Do not edit by hand._\n\n", makefileName)
+
+	b.WriteString("## Targets\n\n")
+	if len(makefile.Rules) == 0 {
+		b.WriteString("No targets are defined.\n\n")
+	} else {
+		fmt.Fprintf(&b, "Running `make-lite` without arguments builds %s.\n\n", markdownCode(makefile.Rules[0].Targets[0]))
+		b.WriteString("| Target | Depends on | Description |\n|---|---|---|\n")
+		aliases := make(map[string][]string)
+		for alias, target := range makefile.Aliases {
+			aliases[target] = append(aliases[target], alias)
+		}
+		for _, rule := range makefile.Rules {
+			var deps []string
+			for _, source := range rule.Sources {
+				// Listing every source file would drown the table; only other targets are shown.
+				if makefile.HasRule(source) {
+					deps = append(deps, markdownCode(source))
+				}
+			}
+			description := rule.Description
+			if rule.IsService {
+				description = strings.TrimSpace("Service. " + description)
+				for _, port := range rule.Ports {
+					description += fmt.Sprintf(" Port %d.", port)
+				}
+			}
+			targets := markdownCodeList(rule.Targets)
+			var ruleAliases []string
+			for _, t := range rule.Targets {
+				ruleAliases = append(ruleAliases, aliases[t]...)
+			}
+			if len(ruleAliases) > 0 {
+				sort.Strings(ruleAliases)
+				targets += fmt.Sprintf(" (alias %s)", markdownCodeList(ruleAliases))
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", targets, strings.Join(deps, ", "), markdownCell(description))
+		}
+		b.WriteString("\n")
+	}
+
+	// The last definition of a variable wins, so each name is listed once with its final definition.
+	order, last := makefile.FinalVariables()
+
+	b.WriteString("## Variables\n\n")
+	if len(order) == 0 {
+		b.WriteString("No variables are defined.\n\n")
+	} else {
+		b.WriteString("| Variable | Default | Overridable from environment | Description |\n|---|---|---|---|\n")
+		for _, name := range order {
+			def := last[name]
+			value := markdownCode(def.RawValue)
+			overridable := "no"
+			switch def.Op {
+			case "?=", opLoadConfig:
+				overridable = "yes"
+			case opLoadEnv:
+				value = "_from env file_"
+				overridable = "yes"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", markdownCode(name), value, overridable, markdownCell(def.Description))
+		}
+		b.WriteString("\n")
+	}
+
+	var required []string
+	for _, name := range makefile.RequiredVariables() {
+		required = append(required, markdownCode(name))
+	}
+	b.WriteString("## Required Environment Variables\n\n")
+	if len(required) == 0 {
+		b.WriteString("None. Every referenced variable is defined in the makefile.\n")
+	} else {
+		b.WriteString("These variables are referenced but not defined by the makefile, so they must come from the environment:\n\n")
+		for _, name := range required {
+			fmt.Fprintf(&b, "-   %s\n", name)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCode wraps s in a code span that is safe inside a table cell.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	fence := "`"
+	if strings.Contains(s, "`") {
+		fence = "``"
+	}
+	return fence + markdownCell(s) + fence
+}
+
+// markdownCodeList renders each item as a code span, separated by commas.
+func markdownCodeList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = markdownCode(item)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// markdownCell escapes the pipe characters that would otherwise split a table cell.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,22 +19,119 @@ type Engine struct {
 	visiting  map[string]bool
 	shellPath string
 	isDebug   bool
+	opts      EngineOptions
+	symlinks  string // Timestamp policy for symbolic links, from `.SYMLINKS`
+
+	startedServices []string                  // Services started (or found running) during this run, in start order
+	workers         map[string]*workerProcess // Persistent workers started on demand, by name
+	recipesRun      int                       // Recipes and services run so far, to tell built goals from up-to-date ones
+
+	state        *buildState         // Loaded from the state file on first use
+	goPackages   map[string][]string // Files of the Go packages named as prerequisites, by directory; nil if not a package
+	stateChanged bool                // The state needs to be written back
+
+	statMu sync.Mutex             // Guards stats and trees, which freshness checks of jobs running at once share
+	stats  map[string]os.FileInfo // Files stat'ed during this build, by path
+	trees  map[string]dirTree     // `.DIRDEPS` directories walked since the last recipe ran
+
+	remote     remoteCache // Shared output cache from --cache-remote, or nil
+	remoteMu   sync.Mutex  // Guards remoteDown
+	remoteDown bool        // The shared cache failed during this build and is no longer used
+
+	jobserver *jobserver // GNU make jobserver shared with nested makes, or nil; used by the executor only
+
+	buildLog *buildLog // Log of the running build, or nil
+	analysis *analysis // Timings of the running build for --analyze, or nil
+
+	ptyMu sync.Mutex // Guards pty
+	pty   bool       // Recipes run under a pseudo-terminal, from --pty
+
+	mu sync.Mutex // Guards the variable store and the fields above while jobs run at once
+
+	procMu        sync.Mutex         // Guards the fields below, which the signal handler uses
+	running       map[*exec.Cmd]bool // Recipe commands running now
+	interruptedBy os.Signal          // Set once SIGINT or SIGTERM arrived; no new command starts
+	interrupted   chan struct{}      // Closed when interruptedBy is set, to end waits early
+}
+
+// EngineOptions holds the CLI switches that change how rules are executed.
+type EngineOptions struct {
+	VerifyIO     bool   // Check each recipe's declared outputs and undeclared writes
+	SourceDir    string // Source tree root when targets are built in a separate output root
+	Silent       bool   // Do not echo any command, like `.SILENT:` without targets
+	IgnoreErrors bool   // Ignore every command failure, like `.IGNORE:` without targets
+	Retry        int    // Retries of every failed recipe, like `.RETRY:` for each target
+	Jobs         int    // Recipes run at once; 0 means one per CPU
+	CacheDir     string // Output cache directory; empty for the user cache directory
+
+	MinFreeMemory float64 // Bytes of available memory below which no recipe starts beside running ones; 0 never waits
+
+	CacheRemote     string // URL of the shared output cache, from --cache-remote
+	CacheRemoteMode string // Whether the shared cache is read, written or both
+	Offline         bool   // Leave the shared cache alone, as with remote includes
+
+	Sandbox      string // Run recipes with only their declared inputs: "", warn or error
+	OutputPrefix bool   // Start every line of recipe output with `[target] `
+	PTY          string // Run recipes under a pseudo-terminal: auto, on or off
+	Analyze      string // File to write a Chrome trace of the build to, after reporting its critical path
 }
 
 // NewEngine creates a new build engine.
-func NewEngine(mf *Makefile, vs *VariableStore, isDebug bool) (*Engine, error) {
+func NewEngine(mf *Makefile, vs *VariableStore, isDebug bool, opts EngineOptions) (*Engine, error) {
 	shell, err := exec.LookPath("sh")
 	if err != nil {
 		return nil, fmt.Errorf("could not find 'sh' in PATH. 'make-lite' requires a POSIX-compliant shell")
 	}
-	return &Engine{
+	symlinks, err := symlinkPolicy(vs)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Sandbox {
+	case "", SandboxWarn, SandboxError:
+	default:
+		return nil, fmt.Errorf(ErrorInvalidSandbox, opts.Sandbox)
+	}
+	pty, err := usePTY(opts.PTY)
+	if err != nil {
+		return nil, err
+	}
+	var remote remoteCache
+	if opts.CacheRemote != "" {
+		switch opts.CacheRemoteMode {
+		case RemoteCacheRead, RemoteCacheWrite, RemoteCacheReadWrite:
+		default:
+			return nil, fmt.Errorf(ErrorInvalidCacheRemoteMode, opts.CacheRemoteMode)
+		}
+		if remote, err = newRemoteCache(opts.CacheRemote); err != nil {
+			return nil, err
+		}
+		if opts.Offline {
+			remote = nil
+		}
+	}
+	e := &Engine{
 		makefile:  mf,
 		vars:      vs,
 		built:     make(map[string]bool),
 		visiting:  make(map[string]bool),
 		shellPath: shell,
 		isDebug:   isDebug,
-	}, nil
+		opts:      opts,
+		workers:   make(map[string]*workerProcess),
+		symlinks:  symlinks,
+		remote:    remote,
+		pty:       pty,
+
+		goPackages:  make(map[string][]string),
+		stats:       make(map[string]os.FileInfo),
+		trees:       make(map[string]dirTree),
+		running:     make(map[*exec.Cmd]bool),
+		interrupted: make(chan struct{}),
+	}
+	// jobs() tells a make-lite under another make's jobserver apart by it, so the
+	// count for a new jobserver is taken before there is one.
+	e.jobserver = setupJobserver(e.jobs(), isDebug)
+	return e, nil
 }
 
 // Build is the main entry point to start building a target.
@@ -42,67 +140,71 @@ func (e *Engine) Build(targetName string) error {
 	if err != nil {
 		return fmt.Errorf("failed to expand target name '%s': %w", targetName, err)
 	}
-	return e.buildRecursive(expandedTarget)
+	return e.buildGoal(expandedTarget)
 }
 
-// buildRecursive performs the core dependency resolution and execution.
-func (e *Engine) buildRecursive(targetName string) error {
-	if e.built[targetName] {
-		return nil
-	}
-	if e.visiting[targetName] {
-		return fmt.Errorf("circular dependency detected: target '%s' is a dependency of itself", targetName)
-	}
-	e.visiting[targetName] = true
-	defer func() { delete(e.visiting, targetName) }()
-
-	rule, exists := e.makefile.RuleMap[targetName]
-	if !exists {
-		info, err := os.Stat(targetName)
-		if err == nil && !info.IsDir() {
-			e.built[targetName] = true
-			return nil
+// expandSecondary expands the `.SECONDEXPANSION` prerequisites of a target's
+// rules, with `$@` and the target-specific variables in scope. Rules are shared
+// by all their targets, so each expanded rule is a copy.
+func (e *Engine) expandSecondary(rules []*Rule, targetName string) ([]*Rule, error) {
+	expanded := make([]*Rule, len(rules))
+	for i, rule := range rules {
+		expanded[i] = rule
+		if rule.SecondarySources == "" {
+			continue
 		}
-		return fmt.Errorf("don't know how to make target '%s'", targetName)
-	}
-
-	for _, sourceName := range rule.Sources {
-		// sourceName is already expanded by the parser
-		sourceFiles := strings.Fields(sourceName)
-		for _, sourceFile := range sourceFiles {
-			if err := e.buildRecursive(sourceFile); err != nil {
-				return err
-			}
+		scope, private := e.ruleScope(rule)
+		scope["@"] = targetName
+		e.vars.SetScope(scope, private)
+		e.vars.SetNamespace(rule.Namespace)
+		sources, err := e.vars.Expand(escapeBlanks(rule.SecondarySources), true)
+		e.vars.SetNamespace("")
+		e.vars.SetScope(nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand prerequisites of '%s': %w", targetName, err)
 		}
+		copied := *rule
+		copied.Sources, copied.Waits = splitWaits(splitWords(sources))
+		expanded[i] = &copied
 	}
+	return expanded, nil
+}
 
-	needsRun, reason, err := e.checkFreshness(rule)
-	if err != nil {
-		return err
+// sourcePath locates a file that no rule builds. Such a file is looked up in
+// the working directory, then (with a separate output root) in the source tree,
+// then in the directories of matching `vpath` directives and of VPATH.
+func (e *Engine) sourcePath(name string) string {
+	if filepath.IsAbs(name) || e.makefile.HasRule(name) {
+		return name
 	}
-
-	if needsRun {
-		if e.isDebug {
-			if reason == "" {
-				fmt.Printf(StatusBuildingTarget, targetName)
-			} else {
-				fmt.Printf(StatusBuildingTargetBecause, targetName, reason)
-			}
+	if _, err := e.stat(name); err == nil {
+		return name
+	}
+	var dirs []string
+	if e.opts.SourceDir != "" {
+		dirs = append(dirs, e.opts.SourceDir)
+	}
+	for _, v := range e.makefile.VPaths {
+		if v.Matches(name) {
+			dirs = append(dirs, v.Dirs...)
 		}
-		if err := e.executeRecipe(rule); err != nil {
-			return fmt.Errorf("recipe for target '%s' failed: %w", targetName, err)
+	}
+	vpath, _ := e.vars.Get(VPathVar)
+	dirs = append(dirs, splitSearchPath(vpath)...)
+	for _, dir := range dirs {
+		// Search directories are relative to the makefile, which is in the source tree.
+		if e.opts.SourceDir != "" && !filepath.IsAbs(dir) {
+			dir = filepath.Join(e.opts.SourceDir, dir)
 		}
-	} else {
-		if e.isDebug {
-			targetList := strings.Join(rule.Targets, "', '")
-			fmt.Printf(StatusTargetsUpToDate, targetList)
+		candidate := filepath.Join(dir, name)
+		if _, err := e.stat(candidate); err == nil {
+			if e.isDebug && dir != e.opts.SourceDir {
+				fmt.Printf(DebugVPathFound, name, candidate)
+			}
+			return candidate
 		}
 	}
-
-	for _, t := range rule.Targets {
-		e.built[t] = true
-	}
-	return nil
+	return name
 }
 
 // checkFreshness determines if a rule's recipe needs to be executed per the PRD.
@@ -114,9 +216,14 @@ func (e *Engine) checkFreshness(rule *Rule) (bool, string, error) {
 		return true, "it has no targets", nil
 	}
 
+	// As in GNU Make, a `::` rule without prerequisites runs every time.
+	if rule.DoubleColon && len(rule.Sources) == 0 {
+		return true, "it is a double-colon rule without prerequisites", nil
+	}
+
 	for _, targetName := range rule.Targets {
 		// targetName is already expanded by parser
-		info, err := os.Stat(targetName)
+		info, err := e.stat(targetName)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return true, "", nil
@@ -136,17 +243,25 @@ func (e *Engine) checkFreshness(rule *Rule) (bool, string, error) {
 		return true, "it is a symbolic target", nil
 	}
 
+	if stale, reason, err := e.checkDiscoveredDeps(rule, oldestTargetModTime); stale || err != nil {
+		return stale, reason, err
+	}
+
 	if len(rule.Sources) == 0 {
 		return false, "", nil
 	}
 
 	for _, sourceName := range rule.Sources {
 		// sourceName is already expanded by parser
-		info, err := os.Stat(sourceName)
+		path := e.sourcePath(sourceName)
+		info, err := e.stat(path)
 		if err != nil {
 			if os.IsNotExist(err) {
 				// Check if the missing "file" is actually another rule target (a phony dependency).
-				if _, isRule := e.makefile.RuleMap[sourceName]; isRule {
+				if e.makefile.HasRule(sourceName) {
+					if e.makefile.Strict {
+						return false, "", fmt.Errorf(ErrorStrictMissingPrerequisite, sourceName, rule.Targets[0])
+					}
 					// It's a phony dependency. It has already been run.
 					// It does not influence the freshness of the current file-based target.
 					// So we just continue to the next source.
@@ -157,60 +272,299 @@ func (e *Engine) checkFreshness(rule *Rule) (bool, string, error) {
 			}
 			return false, "", err
 		}
-		if info.ModTime().After(oldestTargetModTime) {
+		if info.IsDir() && e.isDirDep(sourceName) {
+			if tree := e.newestInTree(path); tree.modTime.After(oldestTargetModTime) {
+				return true, fmt.Sprintf("'%s' in directory '%s' is newer", tree.newest, sourceName), nil
+			}
+			continue
+		}
+		if e.contentModTime(path, info.ModTime()).After(oldestTargetModTime) {
 			return true, fmt.Sprintf("source '%s' is newer", sourceName), nil
 		}
+		if !info.IsDir() {
+			continue
+		}
+		files, _ := e.goPackageFiles(path)
+		for _, file := range files {
+			if info, err := e.stat(file); err == nil && info.ModTime().After(oldestTargetModTime) {
+				return true, fmt.Sprintf("Go source '%s' of package '%s' is newer", file, sourceName), nil
+			}
+		}
 	}
 
 	return false, "", nil
 }
 
+// ruleScope collects the target-specific variables of a rule's targets. They
+// are layered over the global value: first the assignments of every matching
+// `%` pattern, then those naming the target itself, each in definition order.
+// A `?=` assignment only applies when the variable is not defined otherwise,
+// and `+=` appends to the value of the layers below. A variable is private,
+// and kept out of the recipe environment, if any layer that set it is.
+func (e *Engine) ruleScope(rule *Rule) (map[string]string, map[string]bool) {
+	scope := make(map[string]string)
+	private := make(map[string]bool)
+	for name, value := range rule.Automatic {
+		scope[name] = value
+	}
+	for _, target := range rule.Targets {
+		for _, pv := range e.makefile.PatternVars {
+			if _, ok := matchWordPattern(pv.Pattern, target); ok {
+				e.applyTargetVar(scope, private, pv.TargetVar)
+			}
+		}
+		for _, tv := range e.makefile.TargetVars[target] {
+			e.applyTargetVar(scope, private, tv)
+		}
+	}
+	return scope, private
+}
+
+// applyTargetVar layers one target-specific assignment onto a rule's scope.
+func (e *Engine) applyTargetVar(scope map[string]string, private map[string]bool, tv TargetVar) {
+	current, inScope := scope[tv.Name]
+	if !inScope {
+		current, inScope = e.vars.Get(tv.Name)
+	}
+	switch {
+	case tv.Op == "?=" && inScope:
+		return
+	case tv.Op == "+=" && current != "":
+		scope[tv.Name] = current + " " + tv.Value
+	default:
+		scope[tv.Name] = tv.Value
+	}
+	if tv.Private {
+		private[tv.Name] = true
+	}
+}
+
+// runRecipe executes a rule's recipe with its target-specific variables in
+// scope, checking its I/O contract when --verify-io is set.
+func (e *Engine) runRecipe(rule *Rule) (err error) {
+	var mutexes string
+	_ = e.inRuleScope(rule, func() error {
+		e.recipesRun++
+		mutexes, _ = e.vars.Get(".MUTEX")
+		return nil
+	})
+	if strings.TrimSpace(mutexes) != "" {
+		release, err := acquireMutexes(strings.Fields(mutexes))
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	e.buildLog.begin(rule)
+	defer func() { e.buildLog.end(rule, err) }()
+	if !e.opts.VerifyIO {
+		return e.executeAtomically(rule)
+	}
+	before, err := snapshotWorkspace()
+	if err != nil {
+		return err
+	}
+	if err := e.executeAtomically(rule); err != nil {
+		return err
+	}
+	return verifyIOContract(rule, before)
+}
+
+// looksLikeFile reports whether a target names a file rather than a task:
+// it has a directory part, as in `bin/app`, or an extension, as in `out.txt`.
+func looksLikeFile(target string) bool {
+	return strings.ContainsRune(target, '/') || filepath.Ext(strings.TrimPrefix(filepath.Base(target), ".")) != ""
+}
+
+// checkTargetsProduced catches a recipe that succeeded without creating its
+// file targets, usually because of a typo in the output path, before rules
+// depending on them fail with a confusing missing prerequisite. A target is
+// taken to be a file if it existed before the recipe ran or looks like one;
+// other missing targets are symbolic. Strict mode makes this an error.
+func (e *Engine) checkTargetsProduced(rule *Rule, before map[string]time.Time) error {
+	if len(rule.Recipe) == 0 {
+		return nil
+	}
+	for _, target := range rule.Targets {
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			continue
+		}
+		if _, existed := before[target]; !existed && !looksLikeFile(target) {
+			continue
+		}
+		if e.makefile.Strict {
+			return fmt.Errorf(ErrorStrictTargetNotProduced, target)
+		}
+		fmt.Fprintf(os.Stderr, WarningTargetNotProduced, target)
+	}
+	return nil
+}
+
 // executeRecipe runs the commands for a given rule.
 func (e *Engine) executeRecipe(rule *Rule) error {
+	// Strict mode leaves creating output directories to the recipe, so a typo
+	// in a target path cannot silently create a stray tree.
 	for _, targetName := range rule.Targets {
+		if e.makefile.Strict {
+			break
+		}
 		// targetName is already expanded
 		dir := filepath.Dir(targetName)
 		if dir != "." && dir != "/" && dir != "" {
-			if err := os.MkdirAll(dir, 0755); err != nil {
+			if err := os.MkdirAll(filepath.Join(rule.Sandbox, dir), 0755); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", dir, err)
 			}
 		}
 	}
 
+	var worker string
+	var container *containerRun
+	err := e.inRuleScope(rule, func() (err error) {
+		worker, _ = e.vars.Get(".WORKER")
+		container, err = e.recipeContainer(rule)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if worker != "" {
+		return e.executeWithWorker(rule, worker)
+	}
+	if e.makefile.OneShell {
+		return e.executeOneShell(rule, container)
+	}
+
 	for _, cmdLine := range rule.Recipe {
 		if strings.TrimSpace(cmdLine) == "" {
 			continue
 		}
 
-		commandToExecute := cmdLine
-		suppressEcho := false
-		if strings.HasPrefix(strings.TrimSpace(commandToExecute), "@") {
-			suppressEcho = true
-			atIndex := strings.Index(commandToExecute, "@")
-			commandToExecute = commandToExecute[:atIndex] + commandToExecute[atIndex+1:]
-		}
-
-		expandedCmd, err := e.vars.Expand(commandToExecute, false)
+		var expandedCmd string
+		var mods lineModifiers
+		var env []string
+		err := e.inRuleScope(rule, func() (err error) {
+			expandedCmd, mods, err = e.expandRecipeLine(rule, cmdLine)
+			env = e.vars.getEnvironment()
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("error expanding command '%s': %w", cmdLine, err)
+			return err
 		}
 
-		if !suppressEcho {
-			fmt.Println(expandedCmd)
+		if !mods.silent {
+			e.echo(rule, expandedCmd)
 		}
 
 		if e.isDebug {
 			fmt.Fprintf(os.Stderr, DebugExecutingCommand, expandedCmd)
 		}
+		e.buildLog.command(rule, expandedCmd)
+
+		cmd, err := e.recipeCommand(rule, expandedCmd, env, container)
+		if err != nil {
+			return err
+		}
+		var done func()
+		cmd.Stdout, cmd.Stderr, done = e.recipeOutput(rule)
+
+		err = e.runRecipeCommand(cmd)
+		done()
+		if err != nil {
+			if !mods.ignoreError || e.interruption() != nil {
+				return err
+			}
+			fmt.Printf(StatusErrorIgnored, rule.Targets[0], err)
+		}
+	}
+	return nil
+}
+
+// executeOneShell runs a whole recipe as one shell script, so `cd`, shell
+// variables and multi-line constructs carry over between lines, and a recipe
+// with a container image starts a single container. As in GNU Make, only the
+// first line's modifiers apply; those on later lines are dropped.
+func (e *Engine) executeOneShell(rule *Rule, container *containerRun) error {
+	var script, env []string
+	var mods lineModifiers
+	err := e.inRuleScope(rule, func() error {
+		for _, cmdLine := range rule.Recipe {
+			if strings.TrimSpace(cmdLine) == "" {
+				continue
+			}
+			expandedCmd, lineMods, err := e.expandRecipeLine(rule, cmdLine)
+			if err != nil {
+				return err
+			}
+			if len(script) == 0 {
+				mods = lineMods
+			}
+			script = append(script, strings.TrimSpace(expandedCmd))
+		}
+		env = e.vars.getEnvironment()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	scriptText := strings.Join(script, "\n")
 
-		cmd := exec.Command(e.shellPath, "-c", expandedCmd)
-		cmd.Env = e.vars.getEnvironment()
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	if !mods.silent {
+		e.echo(rule, scriptText)
+	}
+	if e.isDebug {
+		fmt.Fprintf(os.Stderr, DebugExecutingCommand, scriptText)
+	}
+	e.buildLog.command(rule, scriptText)
 
-		if err := cmd.Run(); err != nil {
+	cmd, err := e.recipeCommand(rule, scriptText, env, container)
+	if err != nil {
+		return err
+	}
+	var done func()
+	cmd.Stdout, cmd.Stderr, done = e.recipeOutput(rule)
+	err = e.runRecipeCommand(cmd)
+	done()
+	if err != nil {
+		if !mods.ignoreError || e.interruption() != nil {
 			return err
 		}
+		fmt.Printf(StatusErrorIgnored, rule.Targets[0], err)
 	}
 	return nil
 }
+
+// lineModifiers are the prefix characters of a recipe line.
+type lineModifiers struct {
+	silent      bool // '@': do not echo the command
+	ignoreError bool // '-': report a failure but carry on with the recipe
+}
+
+// expandRecipeLine strips the leading '@', '-' and '+' modifiers, in any
+// combination, and expands the variables in a single recipe line. The rule's
+// .SILENT and .IGNORE settings and the matching CLI flags apply as well. '+'
+// is accepted for GNU Make compatibility and ignored, as no mode skips commands.
+func (e *Engine) expandRecipeLine(rule *Rule, cmdLine string) (string, lineModifiers, error) {
+	mods := lineModifiers{
+		silent:      e.opts.Silent || e.makefile.Silent.Covers(rule),
+		ignoreError: e.opts.IgnoreErrors || e.makefile.Ignore.Covers(rule),
+	}
+	body := strings.TrimLeft(cmdLine, " \t")
+	indent := cmdLine[:len(cmdLine)-len(body)]
+	for ; len(body) > 0 && strings.ContainsRune("@-+", rune(body[0])); body = strings.TrimLeft(body[1:], " \t") {
+		switch body[0] {
+		case '@':
+			mods.silent = true
+		case '-':
+			mods.ignoreError = true
+		}
+	}
+	expandedCmd, err := e.vars.Expand(indent+body, false)
+	if err != nil {
+		return "", mods, fmt.Errorf("error expanding command '%s': %w", cmdLine, err)
+	}
+	return expandedCmd, mods, nil
+}
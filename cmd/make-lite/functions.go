@@ -0,0 +1,832 @@
+// cmd/make-lite/functions.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// makeFunction is a GNU Make function make-lite implements itself, without a
+// shell. Its arguments are split on top-level commas and expanded before call
+// receives them. The last argument keeps any further commas, so
+// `$(subst $(comma),;,a,b)` and `$(wildcard a,b)` behave as in GNU Make.
+type makeFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(vs *VariableStore, args []string) (string, error)
+}
+
+// makeFunctions are the functions recognized in `$(name args)` expressions.
+var makeFunctions = map[string]makeFunction{
+	"wildcard":   {1, 1, (*VariableStore).wildcard},
+	"subst":      {3, 3, (*VariableStore).subst},
+	"strip":      {1, 1, (*VariableStore).strip},
+	"findstring": {2, 2, (*VariableStore).findstring},
+	"patsubst":   {3, 3, (*VariableStore).patsubst},
+	"dir":        {1, 1, wordFunction(dirPart)},
+	"notdir":     {1, 1, wordFunction(notdirPart)},
+	"suffix":     {1, 1, wordFunction(suffixPart)},
+	"basename":   {1, 1, wordFunction(basenamePart)},
+	"abspath":    {1, 1, (*VariableStore).abspath},
+	"realpath":   {1, 1, (*VariableStore).realpath},
+	"addprefix":  {2, 2, (*VariableStore).addprefix},
+	"addsuffix":  {2, 2, (*VariableStore).addsuffix},
+	"join":       {2, 2, (*VariableStore).join},
+	"filter":     {2, 2, (*VariableStore).filter},
+	"filter-out": {2, 2, (*VariableStore).filterOut},
+	"sort":       {1, 1, (*VariableStore).sortWords},
+	"word":       {2, 2, (*VariableStore).word},
+	"words":      {1, 1, (*VariableStore).words},
+	"wordlist":   {3, 3, (*VariableStore).wordlist},
+	"firstword":  {1, 1, (*VariableStore).firstword},
+	"lastword":   {1, 1, (*VariableStore).lastword},
+	"error":      {1, 1, (*VariableStore).errorFunction},
+	"warning":    {1, 1, (*VariableStore).warning},
+	"info":       {1, 1, (*VariableStore).info},
+	"origin":     {1, 1, (*VariableStore).origin},
+	"flavor":     {1, 1, (*VariableStore).flavor},
+	"eval":       {1, 1, (*VariableStore).eval},
+	"file":       {1, 2, (*VariableStore).file},
+	"math":       {1, 1, (*VariableStore).math},
+	"x":          {1, 1, (*VariableStore).runFunctionPlugin},
+}
+
+// lazyMakeFunction is a function that expands its own arguments, and only
+// those it needs, such as the template of $(foreach ...) once per word.
+type lazyMakeFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(vs *VariableStore, args []string, visiting map[string]bool) (string, error)
+}
+
+// lazyMakeFunctions are recognized like makeFunctions but receive their
+// arguments unexpanded. They are registered in init because they call back
+// into expand, which looks them up.
+var lazyMakeFunctions map[string]lazyMakeFunction
+
+func init() {
+	lazyMakeFunctions = map[string]lazyMakeFunction{
+		"foreach": {3, 3, (*VariableStore).foreach},
+		"if":      {2, 3, (*VariableStore).ifFunction},
+		"and":     {1, math.MaxInt, (*VariableStore).and},
+		"or":      {1, math.MaxInt, (*VariableStore).or},
+		"call":    {1, math.MaxInt, (*VariableStore).call},
+		"intcmp":  {2, 5, (*VariableStore).intcmp},
+	}
+}
+
+// cutFunctionCall splits the content of a `$(...)` expression into a function
+// name and its raw arguments if it names a known function followed by blanks.
+func cutFunctionCall(content string) (string, string, bool) {
+	i := strings.IndexAny(content, " \t")
+	if i < 0 {
+		return "", "", false
+	}
+	name := content[:i]
+	_, eager := makeFunctions[name]
+	_, lazy := lazyMakeFunctions[name]
+	if !eager && !lazy {
+		return "", "", false
+	}
+	return name, strings.TrimLeft(content[i:], " \t"), true
+}
+
+// splitFunctionArgs splits raw function arguments on commas that are not
+// nested inside parentheses, into at most n arguments.
+func splitFunctionArgs(raw string, n int) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(raw) && len(args) < n-1; i++ {
+		switch raw[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, raw[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, raw[start:])
+}
+
+// callFunction splits and checks the arguments of a function call, expands
+// them unless the function is lazy, then evaluates it.
+func (vs *VariableStore) callFunction(name, rawArgs string, visiting map[string]bool) (string, error) {
+	if fn, ok := lazyMakeFunctions[name]; ok {
+		args := splitFunctionArgs(rawArgs, fn.maxArgs)
+		if len(args) < fn.minArgs {
+			return "", fmt.Errorf(ErrorFunctionArgCount, len(args), name, fn.minArgs)
+		}
+		return fn.call(vs, args, visiting)
+	}
+	if vs.probing && sideEffectFunctions[name] {
+		return "", errProbeSideEffect
+	}
+	fn := makeFunctions[name]
+	args := splitFunctionArgs(rawArgs, fn.maxArgs)
+	if len(args) < fn.minArgs {
+		return "", fmt.Errorf(ErrorFunctionArgCount, len(args), name, fn.minArgs)
+	}
+	for i, arg := range args {
+		expanded, err := vs.expand(arg, true, visiting)
+		if err != nil {
+			return "", err
+		}
+		args[i] = expanded
+	}
+	return fn.call(vs, args)
+}
+
+// wildcard implements $(wildcard pattern...): the existing files matching each
+// glob pattern, sorted per pattern. Relative patterns are resolved against the
+// makefile's directory (CURDIR), so the result is the same while parsing and
+// in recipes run in a separate output root, and results keep the pattern's form.
+func (vs *VariableStore) wildcard(args []string) (string, error) {
+	base, _ := vs.Get(CurDirVar)
+	var matches []string
+	for _, pattern := range strings.Fields(args[0]) {
+		resolved := pattern
+		if !filepath.IsAbs(pattern) && base != "" {
+			resolved = filepath.Join(base, pattern)
+		}
+		found, err := filepath.Glob(resolved)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern in $(wildcard %s): %w", pattern, err)
+		}
+		for _, match := range found {
+			if resolved != pattern {
+				rel, err := filepath.Rel(base, match)
+				if err != nil {
+					return "", err
+				}
+				match = rel
+			}
+			matches = append(matches, match)
+		}
+	}
+	return strings.Join(matches, " "), nil
+}
+
+// subst implements $(subst from,to,text): every occurrence of from in text is
+// replaced by to. As in GNU Make, an empty from appends to to the text.
+func (vs *VariableStore) subst(args []string) (string, error) {
+	from, to, text := args[0], args[1], args[2]
+	if from == "" {
+		return text + to, nil
+	}
+	return strings.ReplaceAll(text, from, to), nil
+}
+
+// strip implements $(strip text): text without leading and trailing blanks,
+// and with each run of inner blanks replaced by a single space.
+func (vs *VariableStore) strip(args []string) (string, error) {
+	return strings.Join(strings.Fields(args[0]), " "), nil
+}
+
+// findstring implements $(findstring find,text): find if it occurs in text,
+// and nothing otherwise.
+func (vs *VariableStore) findstring(args []string) (string, error) {
+	if strings.Contains(args[1], args[0]) {
+		return args[0], nil
+	}
+	return "", nil
+}
+
+// cutSubstitutionRef splits the content of a substitution reference such as
+// `$(SRCS:.c=.o)` into the variable name and the unexpanded from and to parts.
+// The name may not contain blanks, so `$(date +%H:%M)` remains a command.
+func cutSubstitutionRef(content string) (string, string, string, bool) {
+	depth, colon := 0, -1
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ':':
+			if depth == 0 && colon < 0 {
+				colon = i
+			}
+		case '=':
+			if depth == 0 && colon >= 0 {
+				name := content[:colon]
+				if name == "" || strings.ContainsAny(name, " \t") {
+					return "", "", "", false
+				}
+				return name, content[colon+1 : i], content[i+1:], true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// substitutionRef expands `$(name:from=to)`. Like $(patsubst %from,%to,$(name)),
+// it replaces from at the end of each word of the value; if from contains `%`,
+// it is used as the pattern itself, as in `$(SRCS:%.c=build/%.o)`.
+func (vs *VariableStore) substitutionRef(name, from, to string, visiting map[string]bool) (string, error) {
+	parts := []string{name, from, to}
+	for i, part := range parts {
+		expanded, err := vs.expand(part, true, visiting)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = expanded
+	}
+	value, ok, err := vs.lookup(strings.TrimSpace(parts[0]), visiting)
+	if err != nil {
+		return "", err
+	}
+	if !ok && vs.strict {
+		return "", fmt.Errorf(ErrorStrictUndefinedVariable, parts[0])
+	}
+	from, to = parts[1], parts[2]
+	if !strings.Contains(from, "%") {
+		from, to = "%"+from, "%"+to
+	}
+	return vs.patsubst([]string{from, to, value})
+}
+
+// patsubst implements $(patsubst pattern,replacement,text). Each word of text
+// matching pattern, where the first `%` matches any stem, is replaced by
+// replacement with its first `%` standing for the same stem. Other words are
+// kept, and the result is separated by single spaces.
+func (vs *VariableStore) patsubst(args []string) (string, error) {
+	pattern, replacement := args[0], args[1]
+	words := strings.Fields(args[2])
+	for i, word := range words {
+		if stem, ok := matchWordPattern(pattern, word); ok {
+			words[i] = strings.Replace(replacement, "%", stem, 1)
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// matchWordPattern matches a word against a pattern containing at most one
+// `%`, returning the part of the word `%` matched. Unlike pattern rules, the
+// stem may be empty; a pattern without `%` must equal the word.
+func matchWordPattern(pattern, word string) (string, bool) {
+	prefix, suffix, hasPercent := strings.Cut(pattern, "%")
+	if !hasPercent {
+		return "", pattern == word
+	}
+	if len(word) < len(prefix)+len(suffix) || !strings.HasPrefix(word, prefix) || !strings.HasSuffix(word, suffix) {
+		return "", false
+	}
+	return word[len(prefix) : len(word)-len(suffix)], true
+}
+
+// wordFunction makes a function that maps each whitespace-separated word of
+// its argument, dropping words that map to nothing.
+func wordFunction(mapWord func(string) string) func(*VariableStore, []string) (string, error) {
+	return func(_ *VariableStore, args []string) (string, error) {
+		var result []string
+		for _, word := range strings.Fields(args[0]) {
+			if mapped := mapWord(word); mapped != "" {
+				result = append(result, mapped)
+			}
+		}
+		return strings.Join(result, " "), nil
+	}
+}
+
+// dirPart is the directory of a path up to and including its last slash, or
+// `./` when it has none, as $(dir) returns it.
+func dirPart(word string) string {
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		return word[:i+1]
+	}
+	return "./"
+}
+
+// notdirPart is everything after the last slash of a path.
+func notdirPart(word string) string {
+	return word[strings.LastIndex(word, "/")+1:]
+}
+
+// suffixPart is the suffix of the file name, starting at its last period.
+func suffixPart(word string) string {
+	if i := suffixIndex(word); i >= 0 {
+		return word[i:]
+	}
+	return ""
+}
+
+// basenamePart is a path without the suffix of its file name.
+func basenamePart(word string) string {
+	if i := suffixIndex(word); i >= 0 {
+		return word[:i]
+	}
+	return word
+}
+
+// suffixIndex returns the index of the last period in the file name part of
+// a path, or -1 if it has none.
+func suffixIndex(word string) int {
+	i := strings.LastIndex(word, ".")
+	if i < strings.LastIndex(word, "/") {
+		return -1
+	}
+	return i
+}
+
+// absolutePath resolves a path against the makefile's directory (CURDIR), like
+// relative $(wildcard ...) patterns, and cleans it.
+func (vs *VariableStore) absolutePath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	base, _ := vs.Get(CurDirVar)
+	return filepath.Join(base, path)
+}
+
+// abspath implements $(abspath names...): each name as an absolute path
+// without `.` or `..` components. Symlinks are not resolved and the files
+// need not exist.
+func (vs *VariableStore) abspath(args []string) (string, error) {
+	return wordFunction(vs.absolutePath)(vs, args)
+}
+
+// realpath implements $(realpath names...): the canonical absolute path of
+// each name, with symlinks resolved. Names that do not exist are dropped.
+func (vs *VariableStore) realpath(args []string) (string, error) {
+	return wordFunction(func(path string) string {
+		resolved, err := filepath.EvalSymlinks(vs.absolutePath(path))
+		if err != nil {
+			return ""
+		}
+		return resolved
+	})(vs, args)
+}
+
+// file implements $(file op path[,text]). `< path` reads the file, without its
+// final newline, and gives nothing if it does not exist. `> path,text` writes
+// text and `>> path,text` appends it, each adding a final newline if text lacks
+// one; both expand to nothing. Relative paths are resolved like $(abspath ...).
+func (vs *VariableStore) file(args []string) (string, error) {
+	spec := strings.TrimSpace(args[0])
+	op := strings.TrimRight(spec[:len(spec)-len(strings.TrimLeft(spec, "<>"))], " ")
+	path := strings.TrimSpace(spec[len(op):])
+	if path == "" {
+		return "", fmt.Errorf(ErrorFileFunctionNoName, spec)
+	}
+	path = vs.absolutePath(path)
+
+	switch op {
+	case "<":
+		if len(args) > 1 {
+			return "", fmt.Errorf(ErrorFileFunctionReadText, spec)
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(content), "\n"), nil
+	case ">", ">>":
+		text := ""
+		if len(args) > 1 {
+			text = args[1]
+		}
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if op == ">>" {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path, flags, 0644)
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.WriteString(text); err != nil {
+			f.Close()
+			return "", err
+		}
+		return "", f.Close()
+	default:
+		return "", fmt.Errorf(ErrorFileFunctionOp, spec)
+	}
+}
+
+// addprefix implements $(addprefix prefix,names...).
+func (vs *VariableStore) addprefix(args []string) (string, error) {
+	return wordFunction(func(word string) string { return args[0] + word })(vs, args[1:])
+}
+
+// addsuffix implements $(addsuffix suffix,names...).
+func (vs *VariableStore) addsuffix(args []string) (string, error) {
+	return wordFunction(func(word string) string { return word + args[0] })(vs, args[1:])
+}
+
+// join implements $(join list1,list2): words are concatenated pairwise, and
+// the extra words of the longer list are kept as they are.
+func (vs *VariableStore) join(args []string) (string, error) {
+	first, second := strings.Fields(args[0]), strings.Fields(args[1])
+	result := make([]string, max(len(first), len(second)))
+	for i := range result {
+		if i < len(first) {
+			result[i] = first[i]
+		}
+		if i < len(second) {
+			result[i] += second[i]
+		}
+	}
+	return strings.Join(result, " "), nil
+}
+
+// filter implements $(filter patterns...,text): the words of text matching any
+// of the patterns, each of which may contain one `%`.
+func (vs *VariableStore) filter(args []string) (string, error) {
+	return filterWords(args, true), nil
+}
+
+// filterOut implements $(filter-out patterns...,text), the inverse of filter.
+func (vs *VariableStore) filterOut(args []string) (string, error) {
+	return filterWords(args, false), nil
+}
+
+func filterWords(args []string, keep bool) string {
+	patterns := strings.Fields(args[0])
+	var result []string
+	for _, word := range strings.Fields(args[1]) {
+		matched := slices.ContainsFunc(patterns, func(pattern string) bool {
+			_, ok := matchWordPattern(pattern, word)
+			return ok
+		})
+		if matched == keep {
+			result = append(result, word)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// sortWords implements $(sort list): the words in lexical order, without duplicates.
+func (vs *VariableStore) sortWords(args []string) (string, error) {
+	words := strings.Fields(args[0])
+	slices.Sort(words)
+	return strings.Join(slices.Compact(words), " "), nil
+}
+
+// word implements $(word n,text): the nth word of text, counting from 1, or
+// nothing if text has fewer words.
+func (vs *VariableStore) word(args []string) (string, error) {
+	n, err := wordIndex("word", "first", args[0])
+	if err != nil {
+		return "", err
+	}
+	words := strings.Fields(args[1])
+	if n > len(words) {
+		return "", nil
+	}
+	return words[n-1], nil
+}
+
+// words implements $(words text): the number of words in text.
+func (vs *VariableStore) words(args []string) (string, error) {
+	return strconv.Itoa(len(strings.Fields(args[0]))), nil
+}
+
+// wordlist implements $(wordlist start,end,text): words start through end of
+// text, inclusive and counting from 1.
+func (vs *VariableStore) wordlist(args []string) (string, error) {
+	start, err := wordIndex("wordlist", "first", args[0])
+	if err != nil {
+		return "", err
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil {
+		return "", fmt.Errorf(ErrorFunctionNonNumeric, "second", "wordlist", strings.TrimSpace(args[1]))
+	}
+	words := strings.Fields(args[2])
+	end = min(end, len(words))
+	if start > end {
+		return "", nil
+	}
+	return strings.Join(words[start-1:end], " "), nil
+}
+
+// firstword implements $(firstword names...).
+func (vs *VariableStore) firstword(args []string) (string, error) {
+	if words := strings.Fields(args[0]); len(words) > 0 {
+		return words[0], nil
+	}
+	return "", nil
+}
+
+// lastword implements $(lastword names...).
+func (vs *VariableStore) lastword(args []string) (string, error) {
+	if words := strings.Fields(args[0]); len(words) > 0 {
+		return words[len(words)-1], nil
+	}
+	return "", nil
+}
+
+// wordIndex parses a 1-based word index given as a function's argument.
+func wordIndex(function, position, arg string) (int, error) {
+	arg = strings.TrimSpace(arg)
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf(ErrorFunctionNonNumeric, position, function, arg)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf(ErrorFunctionIndexTooSmall, position, function, n)
+	}
+	return n, nil
+}
+
+// foreach implements $(foreach var,list,text): text is expanded once for each
+// word of list with var set to the word, and the results are joined by
+// spaces. var is visible only while text expands; afterwards any variable of
+// the same name is seen again.
+func (vs *VariableStore) foreach(args []string, visiting map[string]bool) (string, error) {
+	name, err := vs.expand(args[0], true, visiting)
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSpace(name)
+	list, err := vs.expand(args[1], true, visiting)
+	if err != nil {
+		return "", err
+	}
+
+	var results []string
+	for _, word := range strings.Fields(list) {
+		restore := vs.bindLocals(map[string]string{name: word})
+		result, err := vs.expand(args[2], true, visiting)
+		restore()
+		if err != nil {
+			return "", err
+		}
+		results = append(results, result)
+	}
+	return strings.Join(results, " "), nil
+}
+
+// ifFunction implements $(if condition,then[,else]). The condition is true
+// when it expands to anything but blanks; only the chosen branch is expanded.
+func (vs *VariableStore) ifFunction(args []string, visiting map[string]bool) (string, error) {
+	condition, err := vs.expand(args[0], true, visiting)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(condition) != "" {
+		return vs.expand(args[1], true, visiting)
+	}
+	if len(args) == 3 {
+		return vs.expand(args[2], true, visiting)
+	}
+	return "", nil
+}
+
+// and implements $(and condition...): the conditions are expanded in order
+// until one is empty, which makes the result empty. Otherwise the result is
+// the expansion of the last condition.
+func (vs *VariableStore) and(args []string, visiting map[string]bool) (string, error) {
+	var value string
+	for _, arg := range args {
+		expanded, err := vs.expand(arg, true, visiting)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(expanded) == "" {
+			return "", nil
+		}
+		value = expanded
+	}
+	return value, nil
+}
+
+// or implements $(or condition...): the expansion of the first condition that
+// is not empty. The conditions after it are not expanded.
+func (vs *VariableStore) or(args []string, visiting map[string]bool) (string, error) {
+	for _, arg := range args {
+		expanded, err := vs.expand(arg, true, visiting)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(expanded) != "" {
+			return expanded, nil
+		}
+	}
+	return "", nil
+}
+
+// call implements $(call name,arg...): the variable name, usually a define
+// block, is expanded with $(0) set to its name and $(1), $(2), ... to the
+// arguments. $(1) through $(9) are always bound, to nothing if not passed, so
+// they never fall back to running a command. Calling an undefined variable
+// gives nothing.
+func (vs *VariableStore) call(args []string, visiting map[string]bool) (string, error) {
+	bindings := make(map[string]string)
+	for i, arg := range args {
+		expanded, err := vs.expand(arg, true, visiting)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			expanded = strings.TrimSpace(expanded)
+		}
+		bindings[strconv.Itoa(i)] = expanded
+	}
+	for i := len(args); i <= 9; i++ {
+		bindings[strconv.Itoa(i)] = ""
+	}
+	body, ok := vs.Get(bindings["0"])
+	if !ok {
+		return "", nil
+	}
+	restore := vs.bindLocals(bindings)
+	defer restore()
+	return vs.expand(body, true, visiting)
+}
+
+// errorFunction implements $(error text): expanding it fails with text as the
+// error, which the caller reports with the line being parsed or the recipe
+// being run. Inside $(if ...) it only fires when its branch is chosen.
+func (vs *VariableStore) errorFunction(args []string) (string, error) {
+	return "", fmt.Errorf(ErrorFunctionError, args[0])
+}
+
+// warning implements $(warning text): text is printed to stderr and the
+// expansion is empty.
+func (vs *VariableStore) warning(args []string) (string, error) {
+	fmt.Fprintf(os.Stderr, WarningFunction, args[0])
+	return "", nil
+}
+
+// info implements $(info text): text is printed to stdout and the expansion
+// is empty.
+func (vs *VariableStore) info(args []string) (string, error) {
+	fmt.Println(args[0])
+	return "", nil
+}
+
+// origin implements $(origin name): where the variable's current value comes
+// from, in GNU Make's terms. Makefile assignments and env files are `file`,
+// the shell environment is `environment`, a profile chosen with --profile is
+// `command line`, values make-lite provides are `default`, and loop
+// variables, call arguments and automatic variables are `automatic`.
+func (vs *VariableStore) origin(args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	if _, ok := vs.locals[name]; ok {
+		return "automatic", nil
+	}
+	if _, ok := vs.scope[name]; ok {
+		if isSpecialVariable(name) {
+			return "automatic", nil
+		}
+		return "file", nil
+	}
+	entry, ok := vs.vars[vs.resolve(name)]
+	switch {
+	case !ok:
+		return "undefined", nil
+	case entry.source == sourceBuiltin || entry.originFile == "built-in":
+		return "default", nil
+	case entry.source == sourceShellEnv:
+		return "environment", nil
+	case entry.source == sourceProfile:
+		return "command line", nil
+	default:
+		return "file", nil
+	}
+}
+
+// flavor implements $(flavor name): `recursive` for a define block, which is
+// expanded where it is used, `simple` for any other variable, whose value was
+// expanded when it was assigned, or `undefined`.
+func (vs *VariableStore) flavor(args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	if _, ok := vs.Get(name); !ok {
+		return "undefined", nil
+	}
+	if vs.isMacro(name) {
+		return "recursive", nil
+	}
+	return "simple", nil
+}
+
+// eval implements $(eval text): text, already expanded, is parsed as makefile
+// lines at the point of the call, so it can define variables and rules, such as
+// those generated with $(call ...) for each item of a list. It expands to nothing.
+func (vs *VariableStore) eval(args []string) (string, error) {
+	if vs.evaluate == nil {
+		return "", fmt.Errorf(ErrorEvalOutsideParse)
+	}
+	return "", vs.evaluate(args[0])
+}
+
+// intcmp implements $(intcmp lhs,rhs[,lt[,eq[,gt]]]): lhs and rhs are compared
+// as integers and only the matching part is expanded. A missing gt defaults to
+// eq, and a missing eq to nothing. With only lhs and rhs, the result is their
+// value if they are equal and nothing otherwise.
+func (vs *VariableStore) intcmp(args []string, visiting map[string]bool) (string, error) {
+	var operands [2]int64
+	for i, position := range []string{"first", "second"} {
+		expanded, err := vs.expand(args[i], true, visiting)
+		if err != nil {
+			return "", err
+		}
+		if operands[i], err = parseInteger("intcmp", position, expanded); err != nil {
+			return "", err
+		}
+	}
+	lhs, rhs := operands[0], operands[1]
+	if len(args) == 2 {
+		if lhs == rhs {
+			return strconv.FormatInt(lhs, 10), nil
+		}
+		return "", nil
+	}
+
+	chosen := 2 // lt
+	switch {
+	case lhs == rhs:
+		chosen = 3
+	case lhs > rhs:
+		chosen = 4
+		if len(args) < 5 {
+			chosen = 3
+		}
+	}
+	if chosen >= len(args) {
+		return "", nil
+	}
+	return vs.expand(args[chosen], true, visiting)
+}
+
+// math implements $(math op a b...): integer arithmetic without spawning
+// `expr`. op is one of + - * / %, applied from left to right, so
+// `$(math + $(words $(SRCS)) 1)` counts one more than the sources.
+func (vs *VariableStore) math(args []string) (string, error) {
+	fields := strings.Fields(args[0])
+	if len(fields) < 3 {
+		return "", fmt.Errorf(ErrorFunctionArgCount, max(len(fields)-1, 0), "math", 2)
+	}
+	op := fields[0]
+	result, err := parseInteger("math", "first", fields[1])
+	if err != nil {
+		return "", err
+	}
+	for i, field := range fields[2:] {
+		operand, err := parseInteger("math", ordinal(i+2), field)
+		if err != nil {
+			return "", err
+		}
+		switch op {
+		case "+":
+			result += operand
+		case "-":
+			result -= operand
+		case "*":
+			result *= operand
+		case "/", "%":
+			if operand == 0 {
+				return "", fmt.Errorf(ErrorMathDivisionByZero, strings.Join(fields, " "))
+			}
+			if op == "/" {
+				result /= operand
+			} else {
+				result %= operand
+			}
+		default:
+			return "", fmt.Errorf(ErrorMathOperator, op)
+		}
+	}
+	return strconv.FormatInt(result, 10), nil
+}
+
+// parseInteger parses a function's integer argument, ignoring surrounding blanks.
+func parseInteger(function, position, arg string) (int64, error) {
+	arg = strings.TrimSpace(arg)
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(ErrorFunctionNonNumeric, position, function, arg)
+	}
+	return n, nil
+}
+
+// ordinal names an argument position in error messages.
+func ordinal(n int) string {
+	switch n {
+	case 1:
+		return "first"
+	case 2:
+		return "second"
+	case 3:
+		return "third"
+	default:
+		return strconv.Itoa(n) + "th"
+	}
+}
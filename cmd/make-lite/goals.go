@@ -0,0 +1,82 @@
+// cmd/make-lite/goals.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// goalResult records how building one command-line goal went.
+type goalResult struct {
+	name     string
+	status   string
+	duration time.Duration
+}
+
+// BuildGoals builds each goal in order, stopping at the first failure. When
+// more than one goal is given, it ends with a status line per goal. The build
+// is logged under StateDir, for `make-lite log last`. With --analyze, it then
+// reports what took the time.
+func (e *Engine) BuildGoals(goals []string) (err error) {
+	if e.opts.Analyze != "" {
+		e.analysis = newAnalysis()
+		defer func() {
+			if reportErr := e.analysis.report(e.opts.Analyze); err == nil {
+				err = reportErr
+			}
+			e.analysis = nil
+		}()
+	}
+	buildID, _ := e.vars.Get(BuildIDVar)
+	log, logErr := openBuildLog(buildID, goals)
+	if logErr != nil {
+		fmt.Fprintf(os.Stderr, WarningBuildLog, logErr)
+	} else {
+		e.buildLog = log
+		defer func() {
+			log.close(err)
+			e.buildLog = nil
+		}()
+	}
+	if len(goals) == 1 {
+		return e.Build(goals[0])
+	}
+
+	results := make([]goalResult, 0, len(goals))
+	var buildErr error
+	for _, goal := range goals {
+		if buildErr != nil {
+			results = append(results, goalResult{name: goal, status: GoalSkipped})
+			continue
+		}
+		recipesBefore := e.recipesRun
+		start := time.Now()
+		buildErr = e.Build(goal)
+		result := goalResult{name: goal, status: GoalBuilt, duration: time.Since(start)}
+		if buildErr != nil {
+			result.status = GoalFailed
+		} else if e.recipesRun == recipesBefore {
+			result.status = GoalUpToDate
+		}
+		results = append(results, result)
+	}
+
+	printGoalSummary(results, buildID)
+	return buildErr
+}
+
+func printGoalSummary(results []goalResult, buildID string) {
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.name))
+	}
+	fmt.Printf(StatusGoalSummary, buildID)
+	for _, r := range results {
+		duration := ""
+		if r.status != GoalSkipped {
+			duration = r.duration.Round(time.Millisecond).String()
+		}
+		fmt.Printf(GoalStatusFormat, width, r.name, r.status, duration)
+	}
+}
@@ -0,0 +1,125 @@
+// cmd/make-lite/godeps.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goModule is the Go module a package directory belongs to.
+type goModule struct {
+	root string // Directory holding go.mod
+	path string // Module path from its `module` line
+}
+
+// findGoModule looks for the go.mod that governs dir, in dir and its parents.
+func findGoModule(dir string) (goModule, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return goModule{}, false
+	}
+	for {
+		if f, err := os.Open(filepath.Join(abs, "go.mod")); err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 2 && fields[0] == "module" {
+					path, err := strconv.Unquote(fields[1])
+					if err != nil {
+						path = fields[1]
+					}
+					return goModule{root: abs, path: path}, true
+				}
+			}
+			return goModule{}, false
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return goModule{}, false
+		}
+		abs = parent
+	}
+}
+
+// goSourceFiles returns the .go files of a package directory, without tests.
+func goSourceFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files
+}
+
+// goPackageFiles returns the files a Go package is built from when a rule
+// names its directory as a prerequisite, as in `bin/app: ./cmd/app`: its .go
+// files, those of every package of the same module it imports, directly or
+// not, and the module's go.mod and go.sum. Imports are read from the sources
+// without running the go tool; build constraints are not evaluated, so files
+// for other platforms count too. It reports false for a directory that is not
+// a Go package in a module.
+func (e *Engine) goPackageFiles(dir string) ([]string, bool) {
+	if files, ok := e.goPackages[dir]; ok {
+		return files, files != nil
+	}
+	e.goPackages[dir] = nil
+	if len(goSourceFiles(dir)) == 0 {
+		return nil, false
+	}
+	module, ok := findGoModule(dir)
+	if !ok {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	files := []string{filepath.Join(module.root, "go.mod")}
+	if _, err := os.Stat(filepath.Join(module.root, "go.sum")); err == nil {
+		files = append(files, filepath.Join(module.root, "go.sum"))
+	}
+	queue := []string{dir}
+	fset := token.NewFileSet()
+	for len(queue) > 0 {
+		pkgDir := queue[0]
+		queue = queue[1:]
+		abs, err := filepath.Abs(pkgDir)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		for _, file := range goSourceFiles(pkgDir) {
+			files = append(files, file)
+			parsed, err := parser.ParseFile(fset, file, nil, parser.ImportsOnly)
+			if err != nil {
+				// A file that does not parse yet still counts; its imports are unknown.
+				continue
+			}
+			for _, spec := range parsed.Imports {
+				path, err := strconv.Unquote(spec.Path.Value)
+				if err != nil {
+					continue
+				}
+				if rel, ok := strings.CutPrefix(path, module.path); ok && (rel == "" || rel[0] == '/') {
+					queue = append(queue, filepath.Join(module.root, filepath.FromSlash(rel)))
+				}
+			}
+		}
+	}
+	if e.isDebug {
+		fmt.Printf(DebugGoPackageFiles, dir, len(files))
+	}
+	e.goPackages[dir] = files
+	return files, true
+}
@@ -0,0 +1,64 @@
+// cmd/make-lite/help.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeTargetList prints every target with its description, in definition
+// order, for `make-lite --list` and the generated `help` target. Targets filed
+// under a `## @group` follow the ungrouped ones, in sections sorted by group name.
+func writeTargetList(w io.Writer, makefile *Makefile) error {
+	if len(makefile.Rules) == 0 {
+		_, err := io.WriteString(w, StatusNoTargets)
+		return err
+	}
+
+	aliases := make(map[string][]string)
+	for alias, target := range makefile.Aliases {
+		aliases[target] = append(aliases[target], alias)
+	}
+	type entry struct{ name, description, group string }
+	var entries []entry
+	width := 0
+	for _, rule := range makefile.Rules {
+		name := strings.Join(rule.Targets, " ")
+		var ruleAliases []string
+		for _, t := range rule.Targets {
+			ruleAliases = append(ruleAliases, aliases[t]...)
+		}
+		if len(ruleAliases) > 0 {
+			sort.Strings(ruleAliases)
+			name += " (" + strings.Join(ruleAliases, ", ") + ")"
+		}
+		description := rule.Description
+		if rule.IsService {
+			description = strings.TrimSpace("[service] " + description)
+		}
+		entries = append(entries, entry{name, description, rule.Group})
+		width = max(width, len(name))
+	}
+
+	// A stable sort keeps definition order within each group.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].group < entries[j].group
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, StatusTargetListHeader, makefile.Rules[0].Targets[0])
+	for i, e := range entries {
+		if e.group != "" && (i == 0 || entries[i-1].group != e.group) {
+			heading := e.group
+			if title := makefile.GroupTitles[e.group]; title != "" {
+				heading = title
+			}
+			fmt.Fprintf(&b, TargetGroupHeader, heading)
+		}
+		fmt.Fprintf(&b, TargetListFormat, width, e.name, e.description)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
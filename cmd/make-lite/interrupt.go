@@ -0,0 +1,157 @@
+// cmd/make-lite/interrupt.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// interruptError fails the recipes that were running when make-lite was
+// interrupted, and the build as a whole.
+type interruptError struct {
+	sig os.Signal
+}
+
+func (e *interruptError) Error() string {
+	return fmt.Sprintf(ErrorInterrupted, e.sig)
+}
+
+// interruption returns the error for an interrupted build, or nil.
+func (e *Engine) interruption() error {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	if e.interruptedBy == nil {
+		return nil
+	}
+	return &interruptError{sig: e.interruptedBy}
+}
+
+// runCommand runs a recipe command in its own process group, unless the
+// caller chose other process attributes, and keeps track of it while it runs,
+// so an interruption can stop it with all its children. Once make-lite is
+// interrupted, no new command starts.
+func (e *Engine) runCommand(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = recipeProcAttr()
+	}
+	e.procMu.Lock()
+	if e.interruptedBy != nil {
+		e.procMu.Unlock()
+		return &interruptError{sig: e.interruptedBy}
+	}
+	if err := cmd.Start(); err != nil {
+		e.procMu.Unlock()
+		return err
+	}
+	e.running[cmd] = true
+	e.procMu.Unlock()
+
+	err := cmd.Wait()
+	e.procMu.Lock()
+	delete(e.running, cmd)
+	e.procMu.Unlock()
+	if err != nil {
+		if interrupted := e.interruption(); interrupted != nil {
+			return interrupted
+		}
+	}
+	return err
+}
+
+// watchSignals handles SIGINT and SIGTERM while a goal is built, until the
+// returned function is called.
+func (e *Engine) watchSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case sig := <-signals:
+			e.interrupt(sig, signals, done)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		<-finished
+	}
+}
+
+// interrupt stops the build: no new recipe starts, the signal is forwarded to
+// the process group of every running command, and those still running after
+// InterruptGrace, or when a second signal arrives, are killed.
+func (e *Engine) interrupt(sig os.Signal, signals <-chan os.Signal, done <-chan struct{}) {
+	e.procMu.Lock()
+	if e.interruptedBy == nil {
+		close(e.interrupted)
+	}
+	e.interruptedBy = sig
+	pids := e.runningPIDs()
+	e.procMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, StatusInterrupted, sig, len(pids))
+	for _, pid := range pids {
+		_ = forwardSignal(pid, sig)
+	}
+	select {
+	case <-done:
+		return
+	case <-signals:
+	case <-time.After(InterruptGrace):
+	}
+	e.procMu.Lock()
+	pids = e.runningPIDs()
+	e.procMu.Unlock()
+	for _, pid := range pids {
+		_ = signalProcessGroup(pid, true)
+	}
+}
+
+// runningPIDs lists the commands that are running; procMu must be held.
+func (e *Engine) runningPIDs() []int {
+	pids := make([]int, 0, len(e.running))
+	for cmd := range e.running {
+		pids = append(pids, cmd.Process.Pid)
+	}
+	return pids
+}
+
+// targetModTimes records when each file target of a rule was last modified,
+// before its recipe runs; a missing target has the zero time.
+func targetModTimes(rule *Rule) map[string]time.Time {
+	times := make(map[string]time.Time, len(rule.Targets))
+	for _, target := range rule.Targets {
+		if info, err := os.Stat(target); err == nil {
+			times[target] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// deleteInterruptedTargets removes the files an interrupted recipe created or
+// modified, as they may be half written and would otherwise look up to date
+// on the next run. Targets listed in `.PRECIOUS:` are kept.
+func (e *Engine) deleteInterruptedTargets(rule *Rule, before map[string]time.Time, err error) {
+	var interrupted *interruptError
+	if !errors.As(err, &interrupted) || e.makefile.Precious.Covers(rule) {
+		return
+	}
+	for _, target := range rule.Targets {
+		info, statErr := os.Stat(target)
+		if statErr != nil || !info.Mode().IsRegular() || info.ModTime().Equal(before[target]) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, StatusDeletingTarget, target)
+		if removeErr := os.Remove(target); removeErr != nil {
+			fmt.Fprintf(os.Stderr, ErrorCommandFailed, removeErr)
+		}
+	}
+}
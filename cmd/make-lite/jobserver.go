@@ -0,0 +1,211 @@
+// cmd/make-lite/jobserver.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// jobserverToken is the byte make-lite puts in a jobserver it creates. GNU
+// make hands back whatever byte it took, so any byte is accepted.
+const jobserverToken = '+'
+
+// jobserver is a GNU make jobserver: a pipe holding one byte for every job
+// that may run beyond the one each make runs without asking. A make taking
+// part in it reads a byte before starting another job and writes it back when
+// that job ends, so however deeply makes and make-lites nest, no more jobs run
+// at once than the top one's -j. Recipes reach it through MAKEFLAGS.
+type jobserver struct {
+	r, w *os.File
+	fifo string // Path of a named pipe, which children open themselves; "" for an inherited pipe
+	jobs int    // -j of the top make, for the MAKEFLAGS of children; 0 if unknown
+
+	mu      sync.Mutex
+	held    []byte    // Tokens taken for the jobs running beyond the first
+	wanted  bool      // A read is under way and its token is still needed
+	arrived chan byte // Tokens that arrived while wanted
+}
+
+// jobserverAuth returns the jobserver that a parent make described in
+// MAKEFLAGS: `--jobserver-auth=R,W` naming inherited pipe descriptors,
+// `--jobserver-auth=fifo:PATH` as GNU make 4.4 writes, or the older
+// `--jobserver-fds=R,W`. The last one wins, as in GNU make.
+func jobserverAuth(makeflags string) string {
+	auth := ""
+	for _, word := range strings.Fields(makeflags) {
+		if value, ok := strings.CutPrefix(word, "--jobserver-auth="); ok {
+			auth = value
+		} else if value, ok := strings.CutPrefix(word, "--jobserver-fds="); ok {
+			auth = value
+		}
+	}
+	return auth
+}
+
+// joinJobserver opens the jobserver of a parent make. A parent passes its
+// pipe only to recipes it knows run a make, so the descriptors may be closed
+// or reused by something else; they are checked to be pipes.
+func joinJobserver(auth string) (*jobserver, error) {
+	if path, ok := strings.CutPrefix(auth, "fifo:"); ok {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &jobserver{r: f, w: f, fifo: path, arrived: make(chan byte, 1)}, nil
+	}
+	rs, ws, ok := strings.Cut(auth, ",")
+	rfd, rerr := strconv.Atoi(rs)
+	wfd, werr := strconv.Atoi(ws)
+	if !ok || rerr != nil || werr != nil || rfd < 0 || wfd < 0 {
+		return nil, fmt.Errorf("unsupported jobserver '%s'", auth)
+	}
+	r, w := os.NewFile(uintptr(rfd), "jobserver-r"), os.NewFile(uintptr(wfd), "jobserver-w")
+	for _, f := range []*os.File{r, w} {
+		if info, err := f.Stat(); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("descriptor %d is not an open pipe; mark the parent make's recipe line with '+'", f.Fd())
+		}
+	}
+	return &jobserver{r: r, w: w, arrived: make(chan byte, 1)}, nil
+}
+
+// newJobserver creates a jobserver for -j jobs.
+func newJobserver(jobs int) (*jobserver, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bytes.Repeat([]byte{jobserverToken}, jobs-1)); err != nil {
+		_ = r.Close()
+		_ = w.Close()
+		return nil, err
+	}
+	return &jobserver{r: r, w: w, jobs: jobs, arrived: make(chan byte, 1)}, nil
+}
+
+// setupJobserver joins the jobserver in MAKEFLAGS, or creates one when make-lite
+// runs several jobs at once and is not under another make. It returns nil when
+// there is none; a jobserver that cannot be joined is a warning, as in GNU make.
+func setupJobserver(jobs int, isDebug bool) *jobserver {
+	if !jobserverSupported {
+		return nil
+	}
+	if auth := jobserverAuth(os.Getenv("MAKEFLAGS")); auth != "" {
+		js, err := joinJobserver(auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, WarningJobserverUnavailable, err)
+			return nil
+		}
+		if isDebug {
+			fmt.Printf(DebugJobserverJoined, auth)
+		}
+		return js
+	}
+	if jobs <= 1 {
+		return nil
+	}
+	js, err := newJobserver(jobs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, WarningJobserverUnavailable, err)
+		return nil
+	}
+	return js
+}
+
+// request starts reading a token unless a read is under way. The token is
+// delivered on arrived, or written back if it is no longer wanted by then.
+func (js *jobserver) request() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.wanted {
+		return
+	}
+	js.wanted = true
+	go func() {
+		buf := make([]byte, 1)
+		if n, err := js.r.Read(buf); n != 1 || err != nil {
+			// A broken jobserver grants nothing more; running jobs still finish.
+			return
+		}
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		if js.wanted {
+			js.wanted = false
+			js.arrived <- buf[0]
+			return
+		}
+		_, _ = js.w.Write(buf)
+	}()
+}
+
+// claim reports whether another job may start beside the running ones: the
+// first runs without a token, and each other one holds one.
+func (js *jobserver) claim(running int) bool {
+	if len(js.held) >= running {
+		return true
+	}
+	select {
+	case token := <-js.arrived:
+		js.held = append(js.held, token)
+	default:
+	}
+	return len(js.held) >= running
+}
+
+// trim writes back the tokens held beyond what the running jobs need: one
+// each, except the first, which runs without a token.
+func (js *jobserver) trim(running int) {
+	for len(js.held) > max(running-1, 0) {
+		token := js.held[len(js.held)-1]
+		js.held = js.held[:len(js.held)-1]
+		_, _ = js.w.Write([]byte{token})
+	}
+}
+
+// cancel gives up a token being read, once no more jobs will start.
+func (js *jobserver) cancel() {
+	js.mu.Lock()
+	js.wanted = false
+	js.mu.Unlock()
+	select {
+	case token := <-js.arrived:
+		_, _ = js.w.Write([]byte{token})
+	default:
+	}
+}
+
+// attach lets a recipe command take part in the jobserver: a pipe becomes its
+// descriptors 3 and 4, and MAKEFLAGS names them, or the named pipe, along
+// with the flags already there.
+func (js *jobserver) attach(env []string) ([]string, []*os.File) {
+	auth := "fifo:" + js.fifo
+	var extraFiles []*os.File
+	if js.fifo == "" {
+		auth = "3,4"
+		extraFiles = []*os.File{js.r, js.w}
+	}
+	var flags []string
+	current := ""
+	result := make([]string, 0, len(env)+1)
+	for _, pair := range env {
+		if value, ok := strings.CutPrefix(pair, "MAKEFLAGS="); ok {
+			current = value
+			continue
+		}
+		result = append(result, pair)
+	}
+	for _, word := range strings.Fields(current) {
+		if !strings.HasPrefix(word, "--jobserver-auth=") && !strings.HasPrefix(word, "--jobserver-fds=") &&
+			!(js.jobs > 0 && strings.HasPrefix(word, "-j")) {
+			flags = append(flags, word)
+		}
+	}
+	if js.jobs > 0 {
+		flags = append(flags, "-j"+strconv.Itoa(js.jobs))
+	}
+	flags = append(flags, "--jobserver-auth="+auth)
+	return append(result, "MAKEFLAGS="+strings.Join(flags, " ")), extraFiles
+}
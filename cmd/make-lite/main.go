@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -22,9 +23,63 @@ func main() {
 		os.Exit(1)
 	}
 
+	var srcDir string
+	if cfg.OutputDir != "" || cfg.Profile != "" {
+		var err error
+		if srcDir, err = os.Getwd(); err != nil {
+			fmt.Fprintf(os.Stderr, ErrorOutputDir, err)
+			os.Exit(1)
+		}
+		// Recipes reach the source tree through $(SRCDIR), which behaves like an
+		// environment variable so a makefile can default it with `SRCDIR ?= .`.
+		if err := os.Setenv(SourceDirVar, srcDir); err != nil {
+			fmt.Fprintf(os.Stderr, ErrorOutputDir, err)
+			os.Exit(1)
+		}
+	}
+
+	// BUILD_ID correlates the logs and artifacts of one invocation. One already in
+	// the environment, from CI or a parent make-lite, is kept so nested runs share it.
+	if os.Getenv(BuildIDVar) == "" {
+		if err := os.Setenv(BuildIDVar, newBuildID()); err != nil {
+			fmt.Fprintf(os.Stderr, ErrorInitEngine, err)
+			os.Exit(1)
+		}
+	}
+
 	isDebug := os.Getenv("MAKE_LITE_LOG_LEVEL") == "DEBUG"
+	if isDebug {
+		fmt.Fprintf(os.Stderr, DebugBuildID, os.Getenv(BuildIDVar))
+	}
 	vars := NewVariableStore(isDebug)
-	parser := NewParser(vars)
+	vars.SetStrict(cfg.Strict)
+	vars.SetLegacyDollar(cfg.LegacyDollar)
+	vars.TraceVars(cfg.TraceVars)
+	// MAKECMDGOALS is empty when the default goal is built and for subcommands
+	// with arguments; a bare subcommand word may still name a rule.
+	cmdGoals := cfg.Targets
+	if cfg.Command != "" && len(cfg.Args) > 0 {
+		cmdGoals = nil
+	}
+	vars.SetBuiltin(MakeCmdGoalsVar, strings.Join(cmdGoals, " "))
+	if err := vars.SetShellFallback(cfg.ShellFallback); err != nil {
+		fmt.Fprintf(os.Stderr, ErrorCommandFailed, err)
+		os.Exit(1)
+	}
+	if cfg.Jobs < 0 {
+		fmt.Fprintf(os.Stderr, ErrorCommandFailed, fmt.Errorf(ErrorInvalidJobs, cfg.Jobs))
+		os.Exit(1)
+	}
+	if cfg.Retry < 0 {
+		fmt.Fprintf(os.Stderr, ErrorCommandFailed, fmt.Errorf(ErrorInvalidRetry, cfg.Retry))
+		os.Exit(1)
+	}
+	minFreeMemory, ok := parseResourceAmount(cfg.MinFreeMemory)
+	if !ok {
+		fmt.Fprintf(os.Stderr, ErrorCommandFailed, fmt.Errorf(ErrorInvalidMinFreeMemory, cfg.MinFreeMemory))
+		os.Exit(1)
+	}
+	parser := NewParser(vars, cfg.IncludeDirs, cfg.Profile, cfg.Offline, cfg.Builtins)
 
 	makefile, err := parser.ParseFile(cfg.Makefile)
 	if err != nil {
@@ -32,24 +87,76 @@ func main() {
 		os.Exit(1)
 	}
 
-	target := cfg.Target
-	if target == "" {
-		if len(makefile.Rules) == 0 {
-			fmt.Fprintln(os.Stderr, ErrorNoRulesNoTarget)
+	// A bare subcommand word still builds a rule of the same name, so existing
+	// makefiles with e.g. an `up` target keep working.
+	if cfg.Command != "" && len(cfg.Args) == 0 && makefile.HasRule(cfg.Command) {
+		cfg.Command = ""
+	}
+
+	// A profile can give each variant its own output root with `O=dir`.
+	if cfg.OutputDir == "" && cfg.Profile != "" {
+		cfg.OutputDir = makefile.Profiles[cfg.Profile].Vars[ProfileOutputVar]
+	}
+
+	// The makefile is parsed in the source tree; targets are built in the output root.
+	if cfg.OutputDir != "" {
+		if err := enterOutputDir(cfg.OutputDir); err != nil {
+			fmt.Fprintf(os.Stderr, ErrorOutputDir, err)
 			os.Exit(1)
 		}
-		target = makefile.Rules[0].Targets[0]
-		// This message is helpful and only appears when the user doesn't specify a target.
-		fmt.Printf(StatusUsingDefaultTarget, target)
 	}
 
-	engine, err := NewEngine(makefile, vars, isDebug)
+	engine, err := NewEngine(makefile, vars, isDebug, EngineOptions{
+		VerifyIO:     cfg.VerifyIO,
+		SourceDir:    srcDir,
+		Silent:       cfg.Silent,
+		IgnoreErrors: cfg.IgnoreErrors,
+		Jobs:         cfg.Jobs,
+		Retry:        cfg.Retry,
+		CacheDir:     cfg.CacheDir,
+
+		MinFreeMemory: minFreeMemory,
+
+		CacheRemote:     cfg.CacheRemote,
+		CacheRemoteMode: cfg.CacheMode,
+		Offline:         cfg.Offline,
+
+		Sandbox:      cfg.Sandbox,
+		OutputPrefix: cfg.OutputPrefix,
+		PTY:          string(cfg.PTY),
+		Analyze:      cfg.Analyze,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, ErrorInitEngine, err)
 		os.Exit(1)
 	}
 
-	err = engine.Build(target)
+	if cfg.List {
+		cfg.Command, cfg.Args = "help", nil
+	}
+	if cfg.Command != "" {
+		if err := runCommand(cfg.Command, cfg.Args, makefile, engine); err != nil {
+			fmt.Fprintf(os.Stderr, ErrorCommandFailed, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	goals := cfg.Targets
+	if len(goals) == 0 {
+		if len(makefile.Rules) == 0 {
+			fmt.Fprintln(os.Stderr, ErrorNoRulesNoTarget)
+			os.Exit(1)
+		}
+		goals = []string{makefile.Rules[0].Targets[0]}
+		// This message is helpful and only appears when the user doesn't specify a target.
+		fmt.Printf(StatusUsingDefaultTarget, goals[0])
+	}
+
+	err = engine.BuildGoals(goals)
+	if shutdownErr := engine.Shutdown(); err == nil {
+		err = shutdownErr
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, ErrorBuildFailed, err)
 		os.Exit(1)
@@ -59,3 +166,61 @@ func main() {
 		fmt.Println(StatusBuildSuccess)
 	}
 }
+
+// runCommand dispatches the make-lite subcommands.
+func runCommand(command string, args []string, makefile *Makefile, engine *Engine) error {
+	if command == "help" {
+		if len(args) != 0 {
+			return fmt.Errorf(ErrorCommandNoArgs, command)
+		}
+		return writeTargetList(os.Stdout, makefile)
+	}
+	if command == "docs" {
+		if len(args) != 0 {
+			return fmt.Errorf(ErrorCommandNoArgs, command)
+		}
+		return writeDocs(os.Stdout, makefile, DefaultMakefile)
+	}
+	if command == "vars" {
+		return runVarsCommand(os.Stdout, args, makefile, engine.vars)
+	}
+	if command == "owners" {
+		if len(args) != 1 {
+			return fmt.Errorf(ErrorOwnersArgs)
+		}
+		return writeOwners(os.Stdout, makefile, args[0])
+	}
+	if command == "log" {
+		return runLogCommand(os.Stdout, args)
+	}
+	if command == "xref" {
+		if len(args) != 1 {
+			return fmt.Errorf(ErrorXrefArgs)
+		}
+		return writeXref(os.Stdout, makefile, args[0])
+	}
+	for i, name := range args {
+		args[i] = makefile.Resolve(name)
+		if rules := makefile.RuleMap[args[i]]; len(rules) == 0 || !rules[0].IsService {
+			return fmt.Errorf(ErrorNotAService, name)
+		}
+	}
+	if command == "up" {
+		return engine.Up(args)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf(ErrorServiceNameRequired, command)
+	}
+	if command == "stop" {
+		return stopService(args[0])
+	}
+	return printServiceLogs(args[0])
+}
+
+// enterOutputDir creates the output root if needed and makes it the working directory.
+func enterOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Chdir(dir)
+}
@@ -0,0 +1,42 @@
+// cmd/make-lite/memory.go
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memAvailable returns how many bytes of memory the kernel estimates can be
+// used without swapping, from MemAvailable in /proc/meminfo. It reports false
+// where that is not known, as on systems other than Linux.
+func memAvailable() (float64, bool) {
+	data, err := os.ReadFile(MemInfoPath)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// MemAvailable:    8000000 kB
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
+
+// memoryLow reports whether a node should wait to start because less memory
+// is available than --min-free-memory, and how much there is. The first node
+// always starts, so a build on a busy machine still makes progress.
+func (e *Engine) memoryLow(running int) (float64, bool) {
+	if running == 0 || e.opts.MinFreeMemory == 0 {
+		return 0, false
+	}
+	available, ok := memAvailable()
+	return available, ok && available < e.opts.MinFreeMemory
+}
@@ -0,0 +1,61 @@
+// cmd/make-lite/mutex.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mutexFile returns the lock file backing a named mutex.
+func mutexFile(name string) string {
+	base := strings.ReplaceAll(name, string(filepath.Separator), "_")
+	return filepath.Join(StateDir, "locks", base+".lock")
+}
+
+// acquireMutexes takes the named inter-process locks, waiting for other
+// make-lite processes that hold them. Locks are taken in sorted order so two
+// rules that share several mutexes cannot deadlock. The returned function
+// releases them.
+func acquireMutexes(names []string) (func(), error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	var held []*os.File
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			_ = held[i].Close()
+		}
+	}
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		path := mutexFile(name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			release()
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		held = append(held, f)
+		locked, err := tryLockFile(f)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf(ErrorMutexLock, name, err)
+		}
+		if !locked {
+			fmt.Printf(StatusMutexWaiting, name)
+			if err := lockFile(f); err != nil {
+				release()
+				return nil, fmt.Errorf(ErrorMutexLock, name, err)
+			}
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,163 @@
+// cmd/make-lite/output.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefixWriter starts every line written through it with a prefix. Lines are
+// passed on whole, each in a single write, so lines of jobs running at once
+// never mix within a line; a line is held back until its end arrives.
+type prefixWriter struct {
+	out    io.Writer
+	prefix string
+	mu     sync.Mutex
+	line   []byte // Start of a line whose end has not been written yet
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	written := len(p)
+	for {
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			w.line = append(w.line, p...)
+			return written, nil
+		}
+		line := append(append([]byte(w.prefix), w.line...), p[:end+1]...)
+		w.line = w.line[:0]
+		if _, err := w.out.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[end+1:]
+	}
+}
+
+// Flush passes on a last line that has no end, ending it.
+func (w *prefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.line) > 0 {
+		_, _ = w.out.Write(append(append([]byte(w.prefix), w.line...), '\n'))
+		w.line = w.line[:0]
+	}
+}
+
+// outputPrefix is what --output-prefix puts before the output of a rule's
+// recipe, or "" without it.
+func (e *Engine) outputPrefix(rule *Rule) string {
+	if !e.opts.OutputPrefix || len(rule.Targets) == 0 {
+		return ""
+	}
+	return "[" + rule.Targets[0] + "] "
+}
+
+// recipeOutput returns the stdout and stderr for the commands of a rule's
+// recipe, and a function to call once they have finished. Under
+// --output-prefix, every line they write starts with `[target] `, so the
+// output of jobs running at once can be told apart in CI logs. The build log
+// keeps a copy, without prefixes.
+func (e *Engine) recipeOutput(rule *Rule) (stdout, stderr io.Writer, done func()) {
+	stdout, stderr, done = os.Stdout, os.Stderr, func() {}
+	if prefix := e.outputPrefix(rule); prefix != "" {
+		out := &prefixWriter{out: os.Stdout, prefix: prefix}
+		errOut := &prefixWriter{out: os.Stderr, prefix: prefix}
+		stdout, stderr, done = out, errOut, func() {
+			out.Flush()
+			errOut.Flush()
+		}
+	}
+	if capture := e.buildLog.capture(rule); capture != nil {
+		stdout, stderr = io.MultiWriter(stdout, capture), io.MultiWriter(stderr, capture)
+	}
+	return stdout, stderr, done
+}
+
+// runRecipeCommand runs a command of a recipe, under a pseudo-terminal with
+// --pty.
+func (e *Engine) runRecipeCommand(cmd *exec.Cmd) error {
+	if e.ptyEnabled() {
+		master, slave, err := openPTY()
+		if err == nil {
+			return e.runInPTY(cmd, master, slave)
+		}
+		e.ptyFailed(err)
+	}
+	relayed, err := relayOutput(cmd)
+	if err != nil {
+		return err
+	}
+	err = e.runCommand(cmd)
+	awaitRelays(relayed()...)
+	return err
+}
+
+// relayOutput gives a command pipes of make-lite's own for the stdout and
+// stderr writers that are not files, rather than leaving them to os/exec,
+// which waits for a pipe to be closed by every process holding it: a recipe
+// that starts a server in the background would never finish. The returned
+// function closes make-lite's ends of the pipes once the command has exited,
+// and returns channels closed when the writers have everything.
+func relayOutput(cmd *exec.Cmd) (func() []chan struct{}, error) {
+	var pipes []*os.File
+	var relays []chan struct{}
+	for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if _, isFile := (*w).(*os.File); isFile || *w == nil {
+			continue
+		}
+		r, pw, err := os.Pipe()
+		if err != nil {
+			for _, p := range pipes {
+				_ = p.Close()
+			}
+			return nil, err
+		}
+		relayed := make(chan struct{})
+		go func(out io.Writer) {
+			_, _ = io.Copy(out, r)
+			_ = r.Close()
+			close(relayed)
+		}(*w)
+		*w = pw
+		pipes = append(pipes, pw)
+		relays = append(relays, relayed)
+	}
+	return func() []chan struct{} {
+		for _, p := range pipes {
+			_ = p.Close()
+		}
+		return relays
+	}, nil
+}
+
+// awaitRelays waits for the output of a command that has exited to be passed
+// on, for at most RecipeOutputDrain. What a process it left running writes
+// later is still passed on as it comes.
+func awaitRelays(relays ...chan struct{}) {
+	timeout := time.After(RecipeOutputDrain)
+	for _, relayed := range relays {
+		select {
+		case <-relayed:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// echo prints a recipe command before it runs, prefixed like its output.
+func (e *Engine) echo(rule *Rule, command string) {
+	prefix := e.outputPrefix(rule)
+	if prefix == "" {
+		fmt.Println(command)
+		return
+	}
+	fmt.Print(prefix + strings.ReplaceAll(command, "\n", "\n"+prefix) + "\n")
+}
@@ -0,0 +1,97 @@
+// cmd/make-lite/owners.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeOwners lists the rules that read a file as a source, followed by every
+// other target that would rebuild as a consequence of changing it.
+func writeOwners(w io.Writer, makefile *Makefile, path string) error {
+	file, err := normalizeRulePath(path)
+	if err != nil {
+		return err
+	}
+
+	var direct []*Rule
+	for _, rule := range makefile.Rules {
+		for _, source := range rule.Sources {
+			if filepath.Clean(source) == file {
+				direct = append(direct, rule)
+				break
+			}
+		}
+	}
+	if len(direct) == 0 {
+		_, err := fmt.Fprintf(w, StatusNoOwners, path)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, StatusOwnersHeader, path)
+	seen := map[string]bool{file: true}
+	var queue []string
+	for _, rule := range direct {
+		fmt.Fprintf(&b, "  %s  (%s)\n", strings.Join(rule.Targets, " "), rule.Origin)
+		for _, t := range rule.Targets {
+			seen[t] = true
+			queue = append(queue, t)
+		}
+	}
+
+	// Walk the reverse dependency graph breadth-first, nearest dependents first.
+	var indirect []string
+	for len(queue) > 0 {
+		target := queue[0]
+		queue = queue[1:]
+		for _, rule := range makefile.Rules {
+			if !containsString(rule.Sources, target) {
+				continue
+			}
+			for _, t := range rule.Targets {
+				if !seen[t] {
+					seen[t] = true
+					indirect = append(indirect, t)
+					queue = append(queue, t)
+				}
+			}
+		}
+	}
+	if len(indirect) > 0 {
+		b.WriteString(StatusOwnersIndirect)
+		for _, t := range indirect {
+			fmt.Fprintf(&b, "  %s\n", t)
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// normalizeRulePath turns a command-line path into the form used by rule
+// sources: cleaned and, when possible, relative to the working directory.
+func normalizeRulePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel, nil
+	}
+	return filepath.Clean(path), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
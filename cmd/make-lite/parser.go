@@ -3,38 +3,127 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // processedLine holds a line of content along with its original location.
 type processedLine struct {
 	content    string
+	comment    string // Text of a comment that starts in the first column, without the leading '#'
+	helpText   string // Text of a trailing `## description` comment after content
 	originFile string
 	originLine int
+	parts      []string // The physical lines joined into content by backslash continuations, if any
+}
+
+// recipeText returns a line as a recipe passes it to the shell: backslash
+// continuations are kept, as the shell understands them, and the recipe prefix
+// (a tab by default) is removed from the start of each continued line.
+func (l processedLine) recipeText(prefix string) string {
+	if len(l.parts) == 0 {
+		return l.content
+	}
+	if prefix == "" {
+		prefix = "\t"
+	}
+	var text strings.Builder
+	for i, part := range l.parts {
+		if i > 0 {
+			text.WriteString("\n")
+			part = strings.TrimPrefix(part, prefix)
+		}
+		if i < len(l.parts)-1 {
+			part = strings.TrimRight(part, " \t")
+		}
+		text.WriteString(part)
+	}
+	return text.String()
 }
 
 // rawRule holds an unexpanded rule definition, collected during the first pass.
 type rawRule struct {
 	definitionLine string
 	recipeLines    []string
-	originFile     string
-	originLine     int
+	line           processedLine // The rule line, for its origin and error snippets
+	kind           string        // "", or the keyword of a `service` or `worker` declaration
+	group          string        // Set by a preceding `## @group` annotation
+	namespace      string        // Set inside a fragment included with `include ... as name`
+	isDoubleColon  bool
+	description    string
+}
+
+// rawTargetVar holds a target-specific assignment (`targets: NAME = value`).
+// The value is expanded eagerly in pass 1; the target list waits for pass 2.
+type rawTargetVar struct {
+	targets   string
+	name      string
+	value     string
+	op        string
+	private   bool
+	namespace string
+	line      processedLine
+}
+
+// rawAlias holds an `alias name = target` directive, expanded in pass 1.
+type rawAlias struct {
+	name   string
+	target string
+	line   processedLine
 }
 
 // Parser is responsible for reading and parsing makefiles.
 type Parser struct {
-	variableStore *VariableStore
-	includeStack  map[string]bool // For detecting circular includes
+	variableStore  *VariableStore
+	includeStack   map[string]bool // For detecting circular includes
+	includeDirs    []string        // Searched for relative includes not found next to the including file
+	variables      []*VariableDef  // Makefile assignments and env file keys, in definition order
+	targetVars     []rawTargetVar  // Target-specific assignments, whose targets are expanded in pass 2
+	references     []string        // Variable names referenced anywhere in the makefile, in first-use order
+	referenceSites []VariableRef   // Every reference, in file order
+	referenced     map[string]bool
+	profile        string              // Profile selected on the command line, if any
+	profiles       map[string]*Profile // Declared profiles, by name
+	vpaths         []VPath             // `vpath` directives, in definition order
+	aliases        []rawAlias          // `alias` directives, checked against the rules in pass 2
+	makefileList   []string            // Makefiles parsed so far, in include order, for MAKEFILE_LIST
+	offline        bool                // Remote includes must come from the cache
+	builtins       bool                // Every default variable set is preloaded, from --builtins
+	defaults       map[string]bool     // Variables preloaded from default variable sets
+	sources        map[string][]string // Physical lines of each makefile read, for error snippets
+	groupTitles    map[string]string   // Headings given in `## @group name Title` annotations
+	current        processedLine       // The line pass 1 is processing, where $(eval ...) text comes from
+	evalRules      []rawRule           // Rules defined by $(eval ...) on the current line, not yet collected
+	evalCount      int                 // Number of $(eval ...) calls so far, to name their text in errors
+	namespace      string              // Set while a fragment included with `include ... as name` is read
 }
 
-// NewParser creates a new parser instance.
-func NewParser(vs *VariableStore) *Parser {
+// NewParser creates a new parser instance that searches includeDirs for included
+// makefiles and applies the variables of the named profile, if any. When offline
+// is set, remote includes are only read from the cache, and builtins preloads
+// every default variable set.
+func NewParser(vs *VariableStore, includeDirs []string, profile string, offline, builtins bool) *Parser {
 	return &Parser{
 		variableStore: vs,
 		includeStack:  make(map[string]bool),
+		includeDirs:   includeDirs,
+		referenced:    make(map[string]bool),
+		profile:       profile,
+		profiles:      make(map[string]*Profile),
+		offline:       offline,
+		builtins:      builtins,
+		defaults:      make(map[string]bool),
+		sources:       make(map[string][]string),
+		groupTitles:   make(map[string]string),
 	}
 }
 
@@ -45,25 +134,131 @@ func (p *Parser) ParseFile(filename string) (*Makefile, error) {
 		return nil, fmt.Errorf("could not determine absolute path for %s: %w", filename, err)
 	}
 
-	// This now returns lines with their origin info preserved.
-	processedLines, err := p.processFile(absPath)
+	p.variableStore.SetParsing(true)
+	defer p.variableStore.SetParsing(false)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the working directory: %w", err)
+	}
+	p.variableStore.SetBuiltin(CurDirVar, wd)
+	// Platform variables are only defaults, so the environment or the makefile
+	// can override them, e.g. to pick another platform's fragments.
+	for name, value := range platformVariables() {
+		p.variableStore.Set(name, value, sourceMakefileConditional, "built-in", 0)
+	}
+	if p.builtins {
+		if err := p.loadDefaults(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Pass 1: Populate VariableStore and collect raw, unexpanded rules ---
+	p.variableStore.SetEvaluator(p.evaluate)
+	rawRules, err := p.collectFile(absPath)
+	p.variableStore.SetEvaluator(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// joinContinuations now also preserves origin info.
-	finalLines := p.joinContinuations(processedLines)
-	return p.parseContent(finalLines)
+	if p.profile != "" && p.profiles[p.profile] == nil {
+		names := make([]string, 0, len(p.profiles))
+		for name := range p.profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf(ErrorUnknownProfile, p.profile, strings.Join(names, ", "))
+	}
+
+	// --- Pass 2: Parse the collected raw rules using the now-complete VariableStore ---
+	return p.parseRules(rawRules)
 }
 
-// processFile handles comment removal and file inclusion, returning lines with origin info.
-func (p *Parser) processFile(absPath string) (lines []processedLine, err error) {
+// loadDefaults preloads the named default variable sets, or all of them if
+// names is empty. A variable that is already defined keeps its value.
+func (p *Parser) loadDefaults(names []string) error {
+	if len(names) == 0 {
+		for name := range defaultVariableSets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		set, ok := defaultVariableSets[name]
+		if !ok {
+			available := make([]string, 0, len(defaultVariableSets))
+			for name := range defaultVariableSets {
+				available = append(available, name)
+			}
+			sort.Strings(available)
+			return fmt.Errorf(ErrorUnknownDefaults, name, strings.Join(available, ", "))
+		}
+		for variable, value := range set {
+			p.variableStore.Set(variable, value, sourceMakefileConditional, "built-in", 0)
+			p.defaults[variable] = true
+		}
+	}
+	return nil
+}
+
+// collectFile runs the first pass over a single makefile. Includes are
+// resolved as they are reached, so their paths can use the variables defined so far.
+func (p *Parser) collectFile(absPath string) ([]rawRule, error) {
 	if p.includeStack[absPath] {
 		return nil, fmt.Errorf("circular include detected: %s", absPath)
 	}
 	p.includeStack[absPath] = true
 	defer func() { delete(p.includeStack, absPath) }()
 
+	// Like GNU Make, a file is added to MAKEFILE_LIST as it starts being read,
+	// so its last word is always the makefile currently being parsed.
+	listed, err := normalizeRulePath(absPath)
+	if err != nil {
+		return nil, err
+	}
+	p.makefileList = append(p.makefileList, listed)
+	p.variableStore.SetBuiltin(MakefileListVar, strings.Join(p.makefileList, " "))
+
+	// This returns lines with their origin info preserved.
+	processedLines, err := p.processFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// joinContinuations also preserves origin info.
+	return p.collectVarsAndRawRules(p.joinContinuations(processedLines))
+}
+
+// evaluate reads the text of an $(eval ...) call on the current line as
+// makefile lines. Its variables are set right away, and its rules are
+// collected after those that precede the call. In errors, the text is named
+// after the line of the call.
+func (p *Parser) evaluate(text string) error {
+	caller := p.current
+	defer func() { p.current = caller }()
+	p.evalCount++
+	name := fmt.Sprintf("%s:%d (eval %d)", caller.originFile, caller.originLine, p.evalCount)
+	lines, err := p.processLines(name, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	rules, err := p.collectVarsAndRawRules(p.joinContinuations(lines))
+	if err != nil {
+		return err
+	}
+	p.evalRules = append(p.evalRules, rules...)
+	return nil
+}
+
+// takeEvalRules returns the rules $(eval ...) calls defined since it was last called.
+func (p *Parser) takeEvalRules() []rawRule {
+	rules := p.evalRules
+	p.evalRules = nil
+	return rules
+}
+
+// processFile handles comment removal, returning lines with origin info.
+func (p *Parser) processFile(absPath string) (lines []processedLine, err error) {
 	file, err := os.Open(absPath)
 	if err != nil {
 		if os.IsNotExist(err) && strings.HasSuffix(absPath, ".env") {
@@ -76,13 +271,33 @@ func (p *Parser) processFile(absPath string) (lines []processedLine, err error)
 			err = closeErr
 		}
 	}()
+	return p.processLines(absPath, file)
+}
 
+// processLines does the work of processFile for makefile text read from r,
+// whose lines are attributed to the file name.
+func (p *Parser) processLines(absPath string, r io.Reader) ([]processedLine, error) {
 	var outputLines []processedLine
-	scanner := bufio.NewScanner(file)
+	var pending []heredocDelimiter // Here-documents opened by the last line, still awaiting their terminators
+	scanner := bufio.NewScanner(r)
 	lineNumber := 0
 	for scanner.Scan() {
 		lineNumber++
 		lineContent := scanner.Text()
+		p.sources[absPath] = append(p.sources[absPath], lineContent)
+
+		if len(pending) > 0 {
+			// A here-document body is folded verbatim into the line that opened it, so it
+			// reaches the shell intact: no comment stripping and no line continuation.
+			// One recipe tab is removed, as from every recipe line.
+			body := strings.TrimPrefix(lineContent, "\t")
+			opener := &outputLines[len(outputLines)-1]
+			opener.content += "\n" + body
+			if pending[0].terminates(body) {
+				pending = pending[1:]
+			}
+			continue
+		}
 
 		var contentPart strings.Builder
 		var commentPart strings.Builder
@@ -118,38 +333,191 @@ func (p *Parser) processFile(absPath string) (lines []processedLine, err error)
 		}
 
 		if strings.HasSuffix(strings.TrimSpace(commentPart.String()), `\`) {
-			return nil, fmt.Errorf("ambiguous line continuation in comment at %s:%d", absPath, lineNumber)
+			raw := processedLine{content: lineContent, originFile: absPath, originLine: lineNumber}
+			return nil, p.errorAt(raw, strings.LastIndex(lineContent, `\`), "ambiguous line continuation in comment")
 		}
 		lineContent = contentPart.String()
 
-		trimmedLine := strings.TrimSpace(lineContent)
-		if strings.HasPrefix(trimmedLine, "include ") {
-			includePathStr := strings.TrimSpace(trimmedLine[len("include"):])
-			includePathStr = trimQuotes(includePathStr)
-			if includePathStr == "" {
-				return nil, fmt.Errorf("empty include path at %s:%d", absPath, lineNumber)
-			}
-			includePath := filepath.Join(filepath.Dir(absPath), includePathStr)
-			includedLines, err := p.processFile(includePath)
-			if err != nil {
-				return nil, fmt.Errorf("error in included file %s (from %s:%d): %w", includePathStr, absPath, lineNumber, err)
-			}
-			outputLines = append(outputLines, includedLines...)
-		} else {
-			outputLines = append(outputLines, processedLine{
-				content:    lineContent,
-				originFile: absPath,
-				originLine: lineNumber,
-			})
+		var comment, helpText string
+		if lineContent == "" {
+			comment = strings.TrimSpace(strings.TrimLeft(commentPart.String(), "#"))
+		} else if text, ok := strings.CutPrefix(commentPart.String(), "##"); ok {
+			helpText = strings.TrimSpace(strings.TrimLeft(text, "#"))
+		}
+		outputLines = append(outputLines, processedLine{
+			content:    lineContent,
+			comment:    comment,
+			helpText:   helpText,
+			originFile: absPath,
+			originLine: lineNumber,
+		})
+		if strings.HasPrefix(lineContent, "\t") || strings.HasPrefix(lineContent, " ") {
+			// Only recipe lines hold shell commands that can open here-documents.
+			pending = heredocDelimiters(lineContent)
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading makefile %s: %w", absPath, err)
 	}
+	if len(pending) > 0 {
+		opener := outputLines[len(outputLines)-1]
+		return nil, p.errorAt(opener, strings.Index(opener.content, "<<"), ErrorUnterminatedHeredoc, pending[0].word)
+	}
 
 	return outputLines, nil
 }
 
+// defineDirective reports whether a line opens a `define NAME` block, which
+// may also be written `define NAME =`, and returns the variable name.
+func defineDirective(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "define ")
+	if !ok {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(rest), "="))
+	if len(fields) != 1 {
+		return "", false
+	}
+	return fields[0], true
+}
+
+// collectDefine stores the body of the define block opened on line i and
+// returns the index of its `endef` line. The body is taken verbatim from the
+// file, comments included, and is only expanded where the variable is used.
+func (p *Parser) collectDefine(name string, lines []processedLine, i int) (int, error) {
+	pLine := lines[i]
+	name = p.qualify(name)
+	if err := p.checkAssignable(name, pLine); err != nil {
+		return 0, err
+	}
+	for j := i + 1; j < len(lines); j++ {
+		if strings.TrimSpace(lines[j].content) != "endef" {
+			continue
+		}
+		first := pLine.originLine + max(len(pLine.parts), 1)
+		body := strings.Join(p.sources[pLine.originFile][first-1:lines[j].originLine-1], "\n")
+		p.recordReferences(body, pLine)
+		p.variables = append(p.variables, &VariableDef{
+			Name:        name,
+			RawValue:    body,
+			Op:          "=",
+			Origin:      fmt.Sprintf("%s:%d", pLine.originFile, pLine.originLine),
+			Description: precedingComment(lines, i),
+		})
+		p.variableStore.SetMacro(name, body, pLine.originFile, pLine.originLine)
+		return j, nil
+	}
+	return 0, p.errorAt(pLine, -1, ErrorUnterminatedDefine, name)
+}
+
+// includeDirective reports whether a line is an include directive and returns
+// the directive keyword: `include`, or the optional forms `include?`, `-include`
+// and `sinclude`.
+func includeDirective(line string) (string, bool) {
+	for _, directive := range []string{"include", "include?", "-include", "sinclude"} {
+		if strings.HasPrefix(line, directive+" ") {
+			return directive, true
+		}
+	}
+	return "", false
+}
+
+// heredocDelimiter is the terminating word of a `<<WORD` here-document.
+type heredocDelimiter struct {
+	word      string
+	stripTabs bool // `<<-WORD`: the terminator may be indented with tabs
+}
+
+// terminates reports whether a here-document body line is the delimiter line.
+func (d heredocDelimiter) terminates(line string) bool {
+	if d.stripTabs {
+		line = strings.TrimLeft(line, "\t")
+	}
+	return line == d.word
+}
+
+// heredocDelimiters returns the here-documents a recipe line opens, in order.
+// The word may be quoted (`<<'EOF'`). Here-strings (`<<<`), shifts inside
+// `$((...))` and `<<` inside quotes are not matched.
+func heredocDelimiters(line string) []heredocDelimiter {
+	var delims []heredocDelimiter
+	var quote byte // The quote character of the string being scanned
+	arith := 0     // Open parentheses of the `$((...))` being scanned
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+			continue
+		case c == '\\':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+			continue
+		case arith > 0:
+			if c == '(' {
+				arith++
+			} else if c == ')' {
+				arith--
+			}
+			continue
+		case c == '$' && strings.HasPrefix(line[i+1:], "(("):
+			arith = 2
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(line[i:], "<<<") {
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(line[i:], "<<") {
+			continue
+		}
+		if d, n, ok := heredocWord(line[i+2:]); ok {
+			delims = append(delims, d)
+			i += 1 + n
+		}
+	}
+	return delims
+}
+
+// heredocWord reads the `[-]WORD` or `[-]'WORD'` token after a `<<` and
+// returns its delimiter and length. The token must end where a shell word
+// ends, so `<<BITS))` is not taken for a here-document.
+func heredocWord(s string) (heredocDelimiter, int, bool) {
+	var d heredocDelimiter
+	rest, stripTabs := strings.CutPrefix(s, "-")
+	d.stripTabs = stripTabs
+	rest = strings.TrimLeft(rest, " \t")
+	var quote byte
+	if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+		quote, rest = rest[0], rest[1:]
+	}
+	n := 0
+	for n < len(rest) && (rest[n] == '_' || unicode.IsLetter(rune(rest[n])) || n > 0 && unicode.IsDigit(rune(rest[n]))) {
+		n++
+	}
+	if n == 0 {
+		return d, 0, false
+	}
+	d.word, rest = rest[:n], rest[n:]
+	if quote != 0 {
+		if rest == "" || rest[0] != quote {
+			return d, 0, false
+		}
+		rest = rest[1:]
+	}
+	if rest != "" && !strings.ContainsRune(" \t;|&)<>", rune(rest[0])) {
+		return d, 0, false
+	}
+	return d, len(s) - len(rest), true
+}
+
 // splitOnUnescaped splits a string by a separator, honoring backslash escapes.
 func splitOnUnescaped(s string, sep rune) (string, string, bool) {
 	isEscaped := false
@@ -170,7 +538,8 @@ func splitOnUnescaped(s string, sep rune) (string, string, bool) {
 }
 
 // joinContinuations processes lines, joining those ending in an unescaped backslash.
-// It preserves the origin of the first line in a continuation sequence.
+// It preserves the origin of the first line in a continuation sequence, and the
+// physical lines themselves for recipes, which leave continuations to the shell.
 func (p *Parser) joinContinuations(lines []processedLine) []processedLine {
 	if len(lines) == 0 {
 		return nil
@@ -187,6 +556,13 @@ func (p *Parser) joinContinuations(lines []processedLine) []processedLine {
 			builder.WriteString(trimmedContent[:len(trimmedContent)-1])
 			builder.WriteString(lines[i].content)
 			current.content = builder.String()
+			if current.parts == nil {
+				current.parts = []string{lines[i-1].content}
+			}
+			current.parts = append(current.parts, lines[i].content)
+			if current.helpText == "" {
+				current.helpText = lines[i].helpText
+			}
 		} else {
 			result = append(result, current)
 			current = lines[i]
@@ -198,138 +574,1163 @@ func (p *Parser) joinContinuations(lines []processedLine) []processedLine {
 	return result
 }
 
-// parseContent performs the two-pass parse.
-func (p *Parser) parseContent(lines []processedLine) (*Makefile, error) {
-	// --- Pass 1: Populate VariableStore and collect raw, unexpanded rules ---
-	rawRules, err := p.collectVarsAndRawRules(lines)
-	if err != nil {
-		return nil, err
-	}
-
-	// --- Pass 2: Parse the collected raw rules using the now-complete VariableStore ---
+// parseRules is the second pass: it expands the collected raw rules using the now-complete VariableStore.
+func (p *Parser) parseRules(rawRules []rawRule) (*Makefile, error) {
 	makefile := NewMakefile()
+	p.unqualifyReferences()
+	makefile.Strict = p.variableStore.strict
+	makefile.Variables = p.variables
+	makefile.References = p.references
+	makefile.ReferenceSites = p.referenceSites
+	makefile.Profiles = p.profiles
+	makefile.VPaths = p.vpaths
+	makefile.GroupTitles = p.groupTitles
+	makefile.Defaults = p.defaults
+	secondExpansion := false // Set by `.SECONDEXPANSION:` for the rules after it
+	suffixes := append([]string(nil), defaultSuffixes...)
+	defer p.variableStore.SetNamespace("")
 	for _, raw := range rawRules {
+		p.variableStore.SetNamespace(raw.namespace)
 		left, right, _ := splitOnUnescaped(raw.definitionLine, ':')
+		if raw.isDoubleColon {
+			right = right[1:]
+		}
+		right, attributes, err := cutRuleAttributes(right)
+		if err != nil {
+			return nil, p.errorAt(raw.line, -1, "%w", err)
+		}
+		depfile, err := p.variableStore.Expand(attributes["depfile"], true)
+		if err != nil {
+			return nil, p.errorAt(raw.line, -1, "error expanding depfile: %w", err)
+		}
 
-		expandedLeft, err := p.variableStore.Expand(left, true)
+		expandedLeft, err := p.variableStore.Expand(escapeBlanks(left), true)
 		if err != nil {
-			return nil, fmt.Errorf("at %s:%d: error expanding targets: %w", raw.originFile, raw.originLine, err)
+			return nil, p.errorAt(raw.line, -1, "error expanding targets: %w", err)
 		}
-		expandedRight, err := p.variableStore.Expand(right, true)
+		expandedRight, err := p.variableStore.Expand(escapeBlanks(right), true)
 		if err != nil {
-			return nil, fmt.Errorf("at %s:%d: error expanding sources: %w", raw.originFile, raw.originLine, err)
+			return nil, p.errorAt(raw.line, -1, "error expanding sources: %w", err)
 		}
 
-		targets := strings.Fields(expandedLeft)
-		sources := strings.Fields(expandedRight)
+		targets := splitWords(expandedLeft)
+		sources := splitWords(expandedRight)
 		if len(targets) == 0 {
-			return nil, fmt.Errorf("at %s:%d: rule with no target: \"%s\"", raw.originFile, raw.originLine, raw.definitionLine)
+			return nil, p.errorAt(raw.line, -1, "rule with no target: \"%s\"", raw.definitionLine)
+		}
+		if raw.kind == "" && len(targets) == 1 {
+			// Like GNU Make, special targets' recipes are ignored.
+			switch targets[0] {
+			case ".ONESHELL":
+				makefile.OneShell = true
+				continue
+			case ".SILENT":
+				makefile.Silent.add(sources)
+				continue
+			case ".IGNORE":
+				makefile.Ignore.add(sources)
+				continue
+			case ".SUFFIXES":
+				// As in GNU Make, `.SUFFIXES:` alone clears the list and prerequisites extend it.
+				if len(sources) == 0 {
+					suffixes = nil
+				}
+				suffixes = append(suffixes, sources...)
+				continue
+			case ".SECONDEXPANSION":
+				secondExpansion = true
+				continue
+			case ".NOTPARALLEL":
+				makefile.NotParallel.add(sources)
+				continue
+			case ".ATOMIC":
+				makefile.Atomic.add(sources)
+				continue
+			case ".RESTAT":
+				makefile.Restat.add(sources)
+				continue
+			case ".DIRDEPS":
+				for i, dir := range sources {
+					sources[i] = filepath.Clean(dir)
+				}
+				makefile.DirDeps.add(sources)
+				continue
+			case ".CACHE":
+				makefile.Cache.add(sources)
+				continue
+			case ".PRECIOUS":
+				makefile.Precious.add(sources)
+				continue
+			case ".RETRY":
+				if err := makefile.parseRetry(sources); err != nil {
+					return nil, p.errorAt(raw.line, -1, "%w: \"%s\"", err, raw.definitionLine)
+				}
+				continue
+			case ".RESOURCE":
+				if err := makefile.parseResource(sources); err != nil {
+					return nil, p.errorAt(raw.line, -1, "%w: \"%s\"", err, raw.definitionLine)
+				}
+				continue
+			case ".CONTAINER":
+				if err := makefile.parseContainer(sources); err != nil {
+					return nil, p.errorAt(raw.line, -1, "%w: \"%s\"", err, raw.definitionLine)
+				}
+				continue
+			}
+		}
+		if raw.kind == "" && !raw.isDoubleColon && len(targets) == 1 && len(sources) == 0 {
+			if targetPattern, sourcePattern, ok := suffixRulePatterns(targets[0], suffixes); ok {
+				makefile.AddPatternRule(&PatternRule{
+					TargetPattern: targetPattern,
+					SourcePattern: sourcePattern,
+					Recipe:        raw.recipeLines,
+					Origin:        fmt.Sprintf("%s:%d", raw.line.originFile, raw.line.originLine),
+					Namespace:     raw.namespace,
+					Depfile:       depfile,
+				})
+				continue
+			}
+		}
+		var secondary string
+		if secondExpansion && strings.Contains(expandedRight, "$") {
+			// `$$@` became `$@` above; the engine expands it once the target is known.
+			secondary, sources = strings.TrimSpace(expandedRight), nil
+		}
+		sources, waits := splitWaits(sources)
+		var ports []int
+		switch raw.kind {
+		case "service":
+			targets, ports, err = parseServiceHeader(targets)
+			if err != nil {
+				return nil, p.errorAt(raw.line, -1, "%w: \"%s\"", err, raw.definitionLine)
+			}
+		case "worker":
+			if len(targets) != 1 {
+				return nil, p.errorAt(raw.line, -1, "a worker must declare exactly one name: \"%s\"", raw.definitionLine)
+			}
+		}
+		if raw.kind != "" && raw.isDoubleColon {
+			return nil, p.errorAt(raw.line, -1, "a %s cannot be a double-colon rule: \"%s\"", raw.kind, raw.definitionLine)
 		}
 
 		rule := &Rule{
-			Targets: targets,
-			Sources: sources,
-			Recipe:  raw.recipeLines,
-			Origin:  fmt.Sprintf("%s:%d", raw.originFile, raw.originLine),
+			Targets:     targets,
+			Sources:     sources,
+			Recipe:      raw.recipeLines,
+			Origin:      fmt.Sprintf("%s:%d", raw.line.originFile, raw.line.originLine),
+			IsService:   raw.kind == "service",
+			DoubleColon: raw.isDoubleColon,
+			Ports:       ports,
+			Description: raw.description,
+			Group:       raw.group,
+			Namespace:   raw.namespace,
+			Waits:       waits,
+			Depfile:     depfile,
+
+			SecondarySources: secondary,
+		}
+		if raw.kind == "worker" {
+			// Workers are not build targets; they are started on demand by the rules that use them.
+			makefile.Workers[targets[0]] = rule
+			continue
+		}
+		if err := makefile.AddRule(rule); err != nil {
+			return nil, p.errorAt(raw.line, -1, "%w", err)
+		}
+	}
+
+	for _, raw := range p.aliases {
+		if makefile.HasRule(raw.name) {
+			return nil, p.errorAt(raw.line, -1, "alias '%s' has the same name as a target", raw.name)
+		}
+		if len(makefile.RuleMap[raw.target]) == 0 {
+			return nil, p.errorAt(raw.line, -1, "alias '%s' refers to '%s', which no rule builds", raw.name, raw.target)
+		}
+		makefile.Aliases[raw.name] = raw.target
+	}
+
+	for _, raw := range p.targetVars {
+		p.variableStore.SetNamespace(raw.namespace)
+		expandedTargets, err := p.variableStore.Expand(escapeBlanks(raw.targets), true)
+		if err != nil {
+			return nil, p.errorAt(raw.line, -1, "error expanding targets: %w", err)
+		}
+		for _, target := range splitWords(expandedTargets) {
+			tv := TargetVar{
+				Name:    raw.name,
+				Value:   raw.value,
+				Op:      raw.op,
+				Origin:  fmt.Sprintf("%s:%d", raw.line.originFile, raw.line.originLine),
+				Private: raw.private,
+			}
+			if strings.Contains(target, "%") {
+				makefile.PatternVars = append(makefile.PatternVars, PatternVar{Pattern: target, TargetVar: tv})
+			} else {
+				makefile.TargetVars[target] = append(makefile.TargetVars[target], tv)
+			}
 		}
-		makefile.AddRule(rule)
 	}
 
 	return makefile, nil
 }
 
+// splitWaits removes the `.WAIT` separators from a prerequisite list and returns
+// the index in the remaining list at which each one stood.
+func splitWaits(sources []string) ([]string, []int) {
+	var kept []string
+	var waits []int
+	for _, source := range sources {
+		if source == ".WAIT" {
+			waits = append(waits, len(kept))
+			continue
+		}
+		kept = append(kept, source)
+	}
+	return kept, waits
+}
+
+// ruleAttributes matches a trailing `[name=value ...]` list on a rule line.
+var ruleAttributes = regexp.MustCompile(`\[\s*([a-z]+=[^\s\[\]]*(?:\s+[a-z]+=[^\s\[\]]*)*)\s*\]\s*$`)
+
+// cutRuleAttributes removes the attribute list from the prerequisites of a
+// rule line, as in `main.o: main.c [depfile=main.d]`, and returns the
+// attributes by name. The values are not expanded yet.
+func cutRuleAttributes(right string) (string, map[string]string, error) {
+	match := ruleAttributes.FindStringSubmatchIndex(right)
+	if match == nil {
+		return right, nil, nil
+	}
+	attributes := make(map[string]string)
+	for _, field := range strings.Fields(right[match[2]:match[3]]) {
+		name, value, _ := strings.Cut(field, "=")
+		if name != "depfile" {
+			return "", nil, fmt.Errorf(ErrorUnknownRuleAttribute, name)
+		}
+		attributes[name] = value
+	}
+	return right[:match[0]], attributes, nil
+}
+
+// escapeBlanks doubles the backslash of each escaped blank in a rule line, as
+// in `my\ file.c`, so that `\ ` survives expansion for splitWords.
+func escapeBlanks(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == ' ' || s[i+1] == '\t' {
+				b.WriteByte('\\')
+			}
+			b.WriteString(s[i : i+2])
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitWords splits the expanded targets or prerequisites of a rule into file
+// names. Blanks separate names unless escaped with a backslash (`my\ file.c`),
+// and a name that starts with a quote runs to the matching quote, which is
+// removed (`"my file.c"`). Other backslashes are kept, so Windows paths survive.
+func splitWords(s string) []string {
+	var words []string
+	for i := 0; i < len(s); {
+		if c := s[i]; c == ' ' || c == '\t' || c == '\n' {
+			i++
+			continue
+		}
+		if c := s[i]; c == '"' || c == '\'' {
+			if end := strings.IndexByte(s[i+1:], c); end >= 0 {
+				if end > 0 {
+					words = append(words, s[i+1:i+1+end])
+				}
+				i += end + 2
+				continue
+			}
+		}
+		var word strings.Builder
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' && s[i] != '\n' {
+			if s[i] == '\\' && i+1 < len(s) && (s[i+1] == ' ' || s[i+1] == '\t') {
+				i++
+			}
+			word.WriteByte(s[i])
+			i++
+		}
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseServiceHeader splits the expanded left side of a service rule into its
+// single name and an optional port list: `service web ports 8080 9229: deps`.
+func parseServiceHeader(fields []string) ([]string, []int, error) {
+	if len(fields) == 1 {
+		return fields, nil, nil
+	}
+	if fields[1] != "ports" || len(fields) == 2 {
+		return nil, nil, fmt.Errorf("a service must declare exactly one target, optionally followed by 'ports <port>...'")
+	}
+	var ports []int
+	for _, field := range fields[2:] {
+		port, err := strconv.Atoi(field)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, nil, fmt.Errorf("invalid port '%s' in service declaration", field)
+		}
+		ports = append(ports, port)
+	}
+	return fields[:1], ports, nil
+}
+
 // collectVarsAndRawRules is the first pass, now using processedLine.
 func (p *Parser) collectVarsAndRawRules(lines []processedLine) ([]rawRule, error) {
 	var collectedRules []rawRule
+	group := "" // Set by `## @group name`; it lasts until the next annotation or the end of the file
 	for i := 0; i < len(lines); i++ {
+		collectedRules = append(collectedRules, p.takeEvalRules()...)
 		pLine := lines[i]
+		p.current = pLine
 		trimmedLine := strings.TrimSpace(pLine.content)
 
 		if trimmedLine == "" {
+			if name, title, ok := groupAnnotation(pLine.comment); ok {
+				group = name
+				if title != "" {
+					p.groupTitles[name] = title
+				}
+			}
+			continue
+		}
+
+		// `export VAR...` marks variables for the recipe environment; `export VAR = value`
+		// is also an assignment, handled below with the prefix removed.
+		isExport := false
+		if rest, ok := exportDirective(trimmedLine); ok {
+			if _, _, isAssign := splitOnUnescaped(rest, '='); !isAssign {
+				p.recordReferences(rest, pLine)
+				p.exportNames(rest)
+				continue
+			}
+			trimmedLine = rest
+			isExport = true
+		}
+		if rest, ok := unexportDirective(trimmedLine); ok {
+			p.recordReferences(rest, pLine)
+			for _, name := range strings.Fields(rest) {
+				p.variableStore.Unexport(p.variableStore.resolve(name))
+			}
+			continue
+		}
+		if rest, ok := constDirective(trimmedLine); ok {
+			if err := p.collectConstant(rest, lines, i); err != nil {
+				return nil, err
+			}
+			if isExport {
+				p.variableStore.Export(p.variables[len(p.variables)-1].Name)
+			}
 			continue
 		}
 
-		if left, right, ok := splitOnUnescaped(trimmedLine, ':'); ok && !strings.Contains(left, "=") {
-			if _, _, hasMulti := splitOnUnescaped(right, ':'); hasMulti {
-				return nil, fmt.Errorf("at %s:%d: invalid rule with multiple colons: \"%s\"", pLine.originFile, pLine.originLine, trimmedLine)
+		if isExpansionLine(trimmedLine) {
+			// A line such as `$(eval $(call template,api))` or `$(info ...)` is expanded
+			// for its effect; whatever it defines was collected during the expansion.
+			value, err := p.variableStore.Expand(trimmedLine, true)
+			var evalErr *ParseError
+			if errors.As(err, &evalErr) {
+				return nil, err // Already located in the evaluated text, named after this line
+			} else if err != nil {
+				return nil, p.errorAt(pLine, -1, "%w", err)
+			}
+			if strings.TrimSpace(value) != "" {
+				return nil, p.errorAt(pLine, -1, ErrorExpansionLineOutput, value)
+			}
+		} else if name, ok := defineDirective(trimmedLine); ok {
+			end, err := p.collectDefine(name, lines, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		} else if directive, ok := includeDirective(trimmedLine); ok {
+			includedRules, err := p.includeFile(directive, trimmedLine, pLine)
+			if err != nil {
+				return nil, err
+			}
+			collectedRules = append(collectedRules, includedRules...)
+		} else if strings.HasPrefix(trimmedLine, "load_config ") {
+			// Checked before assignments, since the prefix=NAME option contains `=`.
+			if err := p.collectLoadConfig(strings.TrimSpace(trimmedLine[len("load_config"):]), pLine); err != nil {
+				return nil, err
+			}
+		} else if rest, ok := aliasDirective(trimmedLine); ok {
+			if err := p.collectAlias(rest, pLine); err != nil {
+				return nil, err
+			}
+		} else if rest, ok := vpathDirective(trimmedLine); ok {
+			// Checked before rules, since directory lists may be separated by colons.
+			if err := p.collectVPath(rest, pLine); err != nil {
+				return nil, err
+			}
+		} else if name, assignments, ok := profileDeclaration(trimmedLine); ok {
+			if err := p.collectProfile(name, assignments, pLine); err != nil {
+				return nil, err
+			}
+		} else if left, right, ok := splitOnUnescaped(trimmedLine, ':'); ok && !strings.Contains(left, "=") && isAssignment(right) && strings.TrimSpace(left) != ".RESOURCE" {
+			// `.RESOURCE: link memory=8G` is a special target whose amounts contain `=`.
+			if err := p.collectTargetVar(left, right, pLine); err != nil {
+				return nil, err
+			}
+		} else if left, right, ok := splitOnUnescaped(trimmedLine, ':'); ok && !strings.Contains(left, "=") {
+			isDoubleColon := strings.HasPrefix(right, ":")
+			if isDoubleColon {
+				right = right[1:]
+			}
+			// Only `.CONTAINER:` may have more, as image references such as golang:1.22 do.
+			if _, after, hasMulti := splitOnUnescaped(right, ':'); hasMulti && strings.TrimSpace(left) != ".CONTAINER" {
+				// The caret goes under the second colon; right is a suffix of the line.
+				secondColon := len(strings.TrimRight(pLine.content, " \t")) - len(after) - 1
+				return nil, p.errorAt(pLine, secondColon, "invalid rule with multiple colons: \"%s\"", trimmedLine)
+			}
+			if strings.TrimSpace(left) == ".STRICT" {
+				// Handled in pass 1 so that the assignments and rules after it are checked.
+				p.variableStore.SetStrict(true)
+				continue
+			}
+			if strings.TrimSpace(left) == ".EXPORT_ALL_VARIABLES" {
+				// Handled in pass 1 so that later $(shell ...) calls see the exported variables.
+				p.variableStore.ExportAll()
+				continue
+			}
+			if strings.TrimSpace(left) == ".DEFAULTS" {
+				// Handled in pass 1, so the assignments after it can override the defaults.
+				names, err := p.variableStore.Expand(right, true)
+				if err != nil {
+					return nil, p.errorAt(pLine, -1, "error expanding .DEFAULTS: %w", err)
+				}
+				if err := p.loadDefaults(strings.Fields(names)); err != nil {
+					return nil, p.errorAt(pLine, pLine.offsetOf(strings.TrimSpace(right)), "%w", err)
+				}
+				continue
+			}
+			if strings.TrimSpace(left) == ".READONLY" {
+				// Handled in pass 1, so that only the assignments after it are errors.
+				p.recordReferences(right, pLine)
+				names, err := p.variableStore.Expand(right, true)
+				if err != nil {
+					return nil, p.errorAt(pLine, -1, "error expanding .READONLY: %w", err)
+				}
+				for _, name := range strings.Fields(names) {
+					if !p.variableStore.Freeze(p.variableStore.resolve(name), pLine.originFile, pLine.originLine) {
+						return nil, p.errorAt(pLine, pLine.offsetOf(name), ErrorReadOnlyUndefined, name)
+					}
+				}
+				continue
+			}
+			if special := strings.TrimSpace(left); special == ".ENV_ALLOW" || special == ".ENV_DENY" {
+				// Also handled in pass 1, so later $(shell ...) calls get the filtered environment.
+				p.recordReferences(right, pLine)
+				patterns, err := p.variableStore.Expand(right, true)
+				if err != nil {
+					return nil, p.errorAt(pLine, -1, "error expanding %s: %w", special, err)
+				}
+				if special == ".ENV_ALLOW" {
+					p.variableStore.AllowEnv(strings.Fields(patterns))
+				} else {
+					p.variableStore.DenyEnv(strings.Fields(patterns))
+				}
+				continue
 			}
 			raw := rawRule{
 				definitionLine: trimmedLine,
 				recipeLines:    []string{},
-				originFile:     pLine.originFile,
-				originLine:     pLine.originLine,
+				line:           pLine,
+				group:          group,
+				namespace:      p.namespace,
+				isDoubleColon:  isDoubleColon,
+				description:    ruleDescription(lines, i),
 			}
+			p.recordReferences(trimmedLine, pLine)
+			// `service name: deps` declares a long-running process instead of a build
+			// step; `worker name:` declares a persistent worker that rules send work to.
+			if fields := strings.Fields(left); len(fields) > 1 && (fields[0] == "service" || fields[0] == "worker") {
+				raw.kind = fields[0]
+				raw.definitionLine = strings.TrimSpace(strings.TrimPrefix(trimmedLine, fields[0]))
+			}
+			prefix := p.recipePrefix()
 			j := i + 1
 			for ; j < len(lines); j++ {
-				recipeLine := lines[j].content
+				recipeLine := lines[j].recipeText(prefix)
+				if _, _, ok := groupAnnotation(lines[j].comment); ok && recipeLine == "" {
+					break // Handled by the main loop
+				}
 				if strings.TrimSpace(recipeLine) == "" {
 					raw.recipeLines = append(raw.recipeLines, recipeLine)
 					continue
 				}
-				if !(len(recipeLine) > 0 && (recipeLine[0] == ' ' || recipeLine[0] == '\t')) {
+				if prefix != "" {
+					body, ok := strings.CutPrefix(recipeLine, prefix)
+					if !ok {
+						break
+					}
+					recipeLine = body
+				} else if recipeLine[0] != ' ' && recipeLine[0] != '\t' {
 					break
 				}
 				raw.recipeLines = append(raw.recipeLines, recipeLine)
+				p.recordReferences(recipeLine, lines[j])
 			}
 			i = j - 1
 			collectedRules = append(collectedRules, raw)
 		} else if left, right, ok := splitOnUnescaped(trimmedLine, '='); ok {
-			op := "="
-			if strings.HasSuffix(strings.TrimSpace(left), "?") {
-				op = "?="
-				left = strings.TrimSpace(left[:len(left)-1])
+			left, isPrivate := cutPrivate(left)
+			varName, op, ok := parseAssignmentLeft(left)
+			if !ok {
+				return nil, p.errorAt(pLine, -1, "invalid assignment with no variable name: \"%s\"", trimmedLine)
 			}
-			keyPart := strings.TrimSpace(left)
-			keyTokens := strings.Fields(keyPart)
-			if len(keyTokens) == 0 {
-				return nil, fmt.Errorf("at %s:%d: invalid assignment with no variable name: \"%s\"", pLine.originFile, pLine.originLine, trimmedLine)
+			varName = p.qualify(varName)
+			if err := p.checkAssignable(varName, pLine); err != nil {
+				return nil, err
 			}
-			varName := keyTokens[len(keyTokens)-1]
+			p.recordReferences(right, pLine)
+			p.variables = append(p.variables, &VariableDef{
+				Name:        varName,
+				RawValue:    strings.TrimSpace(right),
+				Op:          op,
+				Origin:      fmt.Sprintf("%s:%d", pLine.originFile, pLine.originLine),
+				Description: precedingComment(lines, i),
+			})
 			value, err := p.variableStore.Expand(strings.TrimSpace(right), true)
 			if err != nil {
-				return nil, fmt.Errorf("at %s:%d: error expanding variable value: %w", pLine.originFile, pLine.originLine, err)
+				return nil, p.errorAt(pLine, -1, "error expanding variable value: %w", err)
+			}
+			switch op {
+			case "+=":
+				p.variableStore.Append(varName, value, pLine.originFile, pLine.originLine)
+			case "?=":
+				p.variableStore.Set(varName, value, sourceMakefileConditional, pLine.originFile, pLine.originLine)
+			default:
+				p.variableStore.Set(varName, value, sourceMakefileUnconditional, pLine.originFile, pLine.originLine)
 			}
-			source := sourceMakefileUnconditional
-			if op == "?=" {
-				source = sourceMakefileConditional
+			if isExport {
+				p.variableStore.Export(varName)
+			} else if isPrivate {
+				p.variableStore.Unexport(varName)
 			}
-			p.variableStore.Set(varName, value, source, pLine.originFile, pLine.originLine)
 		} else if strings.HasPrefix(trimmedLine, "load_env ") {
 			envPath := strings.TrimSpace(trimmedLine[len("load_env"):])
-			envPath = trimQuotes(envPath)
-			if err := p.loadEnvFile(envPath); err != nil {
-				return nil, fmt.Errorf("at %s:%d: %w", pLine.originFile, pLine.originLine, err)
+			// `load_env --required file` fails when the file is missing instead of skipping it.
+			envPath, required := strings.CutPrefix(envPath, "--required ")
+			envPath, err := p.variableStore.Expand(trimQuotes(strings.TrimSpace(envPath)), true)
+			if err != nil {
+				return nil, p.errorAt(pLine, -1, "error expanding load_env path: %w", err)
+			}
+			if err := p.loadEnvFile(envPath, required); err != nil {
+				return nil, p.errorAt(pLine, -1, "%w", err)
 			}
 		} else {
 			if len(pLine.content) > 0 && (pLine.content[0] == ' ' || pLine.content[0] == '\t') {
-				return nil, fmt.Errorf("at %s:%d: unexpected indented line, must follow a rule definition: \"%s\"", pLine.originFile, pLine.originLine, trimmedLine)
+				return nil, p.errorAt(pLine, -1, "unexpected indented line, must follow a rule definition: \"%s\"", trimmedLine)
 			}
-			return nil, fmt.Errorf("at %s:%d: not a rule, assignment, or directive: \"%s\"", pLine.originFile, pLine.originLine, trimmedLine)
+			return nil, p.errorAt(pLine, -1, "not a rule, assignment, or directive: \"%s\"", trimmedLine)
 		}
 	}
-	return collectedRules, nil
+	return append(collectedRules, p.takeEvalRules()...), nil
 }
 
-// loadEnvFile reads a .env file and populates the variable store.
-func (p *Parser) loadEnvFile(filename string) (err error) {
-	file, err := os.Open(filename)
+// isExpansionLine reports whether a line consists only of `$(...)` expansions,
+// which are expanded for their effect rather than parsed as a rule or assignment.
+func isExpansionLine(line string) bool {
+	depth := 0
+	for i := 0; i < len(line); i++ {
+		switch {
+		case depth == 0 && strings.HasPrefix(line[i:], "$("):
+			depth = 1
+			i++
+		case depth == 0 && (line[i] == ' ' || line[i] == '\t'):
+		case depth == 0:
+			return false
+		case line[i] == '(':
+			depth++
+		case line[i] == ')':
+			depth--
+		}
+	}
+	return line != "" && depth == 0
+}
+
+// recipePrefix returns the character set with `.RECIPEPREFIX` that starts each
+// recipe line, or "" when recipe lines are recognized by their indentation.
+func (p *Parser) recipePrefix() string {
+	value, _ := p.variableStore.Get(RecipePrefixVar)
+	if value == "" {
+		return ""
+	}
+	_, size := utf8.DecodeRuneInString(value)
+	return value[:size]
+}
+
+// isAssignment reports whether the text after a rule's colon is a
+// target-specific assignment rather than a list of sources, which may end in
+// `[name=value]` attributes.
+func isAssignment(right string) bool {
+	if match := ruleAttributes.FindStringIndex(right); match != nil {
+		right = right[:match[0]]
+	}
+	_, _, ok := splitOnUnescaped(right, '=')
+	return ok
+}
+
+// parseAssignmentLeft extracts the variable name and operator (`=`, `?=` or
+// `+=`) from the left side of an assignment. Per spec, anything before the last
+// token (such as `export`) is ignored.
+func parseAssignmentLeft(left string) (string, string, bool) {
+	op := "="
+	left = strings.TrimSpace(left)
+	if trimmed, ok := strings.CutSuffix(left, "?"); ok {
+		op, left = "?=", trimmed
+	} else if trimmed, ok := strings.CutSuffix(left, "+"); ok {
+		op, left = "+=", trimmed
+	}
+	keyTokens := strings.Fields(left)
+	if len(keyTokens) == 0 {
+		return "", "", false
+	}
+	return keyTokens[len(keyTokens)-1], op, true
+}
+
+// exportDirective recognizes `export` and returns the rest of the line.
+func exportDirective(line string) (string, bool) {
+	if line == "export" {
+		return "", true
+	}
+	if rest, ok := strings.CutPrefix(line, "export"); ok && (rest[0] == ' ' || rest[0] == '\t') {
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+// exportNames handles `export VAR...`; a bare `export` exports every variable.
+func (p *Parser) exportNames(names string) {
+	fields := strings.Fields(names)
+	if len(fields) == 0 {
+		p.variableStore.ExportAll()
+		return
+	}
+	for _, name := range fields {
+		p.variableStore.Export(p.variableStore.resolve(name))
+	}
+}
+
+// unexportDirective recognizes `unexport VAR...` and returns the names.
+func unexportDirective(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "unexport")
+	if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// constDirective recognizes `const VAR = value` and returns the assignment.
+func constDirective(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "const")
+	if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	if _, _, isAssign := splitOnUnescaped(rest, '='); !isAssign {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// collectConstant handles `const VAR = value` on line i. GNU Make's `:=` is
+// accepted too, since make-lite expands every assignment when it is read.
+func (p *Parser) collectConstant(assignment string, lines []processedLine, i int) error {
+	pLine := lines[i]
+	left, right, _ := splitOnUnescaped(assignment, '=')
+	varName, op, ok := parseAssignmentLeft(strings.TrimSuffix(strings.TrimSpace(left), ":"))
+	if !ok {
+		return p.errorAt(pLine, -1, "invalid assignment with no variable name: \"%s\"", strings.TrimSpace(pLine.content))
+	}
+	if op != "=" {
+		return p.errorAt(pLine, pLine.offsetOf(op), ErrorConstantOperator, op)
+	}
+	varName = p.qualify(varName)
+	if err := p.checkAssignable(varName, pLine); err != nil {
+		return err
+	}
+	p.recordReferences(right, pLine)
+	p.variables = append(p.variables, &VariableDef{
+		Name:        varName,
+		RawValue:    strings.TrimSpace(right),
+		Op:          "=",
+		Origin:      fmt.Sprintf("%s:%d", pLine.originFile, pLine.originLine),
+		Description: precedingComment(lines, i),
+	})
+	value, err := p.variableStore.Expand(strings.TrimSpace(right), true)
 	if err != nil {
+		return p.errorAt(pLine, -1, "error expanding variable value: %w", err)
+	}
+	p.variableStore.SetConstant(varName, value, pLine.originFile, pLine.originLine)
+	return nil
+}
+
+// checkAssignable fails for an assignment to a built-in variable or to one
+// declared constant, naming where the constant was declared.
+func (p *Parser) checkAssignable(name string, pLine processedLine) error {
+	written := strings.TrimPrefix(name, p.namespace+".")
+	if p.variableStore.IsReadOnly(name) {
+		return p.errorAt(pLine, pLine.offsetOf(written), ErrorReadOnlyVariable, name)
+	}
+	if origin, ok := p.variableStore.ConstantOrigin(name); ok {
+		return p.errorAt(pLine, pLine.offsetOf(written), ErrorConstantVariable, name, origin)
+	}
+	return nil
+}
+
+// qualify returns the name an assignment inside a namespaced fragment defines,
+// such as `docker.IMAGE` for `IMAGE = ...` in `include docker.mk-lite as docker`.
+func (p *Parser) qualify(name string) string {
+	if p.namespace == "" {
+		return name
+	}
+	return p.namespace + "." + name
+}
+
+// cutPrivate removes the `private` modifier from the left side of an
+// assignment such as `private TOKEN = ...` or `deploy: private TOKEN = ...`.
+func cutPrivate(left string) (string, bool) {
+	fields := strings.Fields(left)
+	if len(fields) < 2 || fields[0] != "private" {
+		return left, false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(left), "private")), true
+}
+
+// aliasDirective recognizes `alias name = target` and returns the rest of the line.
+func aliasDirective(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "alias")
+	if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// collectAlias records an `alias name = target` directive, which lets a short
+// name stand for a target on the command line and in prerequisite lists.
+func (p *Parser) collectAlias(rest string, pLine processedLine) error {
+	p.recordReferences(rest, pLine)
+	expanded, err := p.variableStore.Expand(rest, true)
+	if err != nil {
+		return p.errorAt(pLine, -1, "error expanding alias: %w", err)
+	}
+	left, right, _ := strings.Cut(expanded, "=")
+	name, target := strings.Fields(left), strings.Fields(right)
+	if len(name) != 1 || len(target) != 1 {
+		return p.errorAt(pLine, -1, "invalid alias; expected 'alias name = target': \"%s\"", strings.TrimSpace(pLine.content))
+	}
+	for _, a := range p.aliases {
+		if a.name == name[0] {
+			return p.errorAt(pLine, -1, "alias '%s' is already declared at %s:%d", name[0], a.line.originFile, a.line.originLine)
+		}
+	}
+	p.aliases = append(p.aliases, rawAlias{name: name[0], target: target[0], line: pLine})
+	return nil
+}
+
+// vpathDirective recognizes `vpath` and returns the rest of the line.
+func vpathDirective(line string) (string, bool) {
+	if line == "vpath" {
+		return "", true
+	}
+	if rest, ok := strings.CutPrefix(line, "vpath"); ok && (rest[0] == ' ' || rest[0] == '\t') {
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+// collectVPath handles `vpath pattern dirs`. As in GNU Make, `vpath pattern`
+// clears the directives for that pattern and a bare `vpath` clears them all.
+func (p *Parser) collectVPath(rest string, pLine processedLine) error {
+	p.recordReferences(rest, pLine)
+	expanded, err := p.variableStore.Expand(rest, true)
+	if err != nil {
+		return p.errorAt(pLine, -1, "error expanding vpath: %w", err)
+	}
+	pattern, dirList, _ := strings.Cut(strings.TrimSpace(expanded), " ")
+	if pattern == "" {
+		p.vpaths = nil
+		return nil
+	}
+	dirs := splitSearchPath(dirList)
+	if len(dirs) == 0 {
+		kept := p.vpaths[:0]
+		for _, v := range p.vpaths {
+			if v.Pattern != pattern {
+				kept = append(kept, v)
+			}
+		}
+		p.vpaths = kept
+		return nil
+	}
+	p.vpaths = append(p.vpaths, VPath{Pattern: pattern, Dirs: dirs})
+	return nil
+}
+
+// splitSearchPath splits a VPATH-style directory list, separated by colons or blanks.
+func splitSearchPath(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ':' || r == ' ' || r == '\t'
+	})
+}
+
+// profileDeclaration recognizes `profile name: VAR=value ...`.
+func profileDeclaration(line string) (string, string, bool) {
+	left, right, ok := splitOnUnescaped(line, ':')
+	if !ok {
+		return "", "", false
+	}
+	fields := strings.Fields(left)
+	if len(fields) != 2 || fields[0] != "profile" {
+		return "", "", false
+	}
+	return fields[1], right, true
+}
+
+// collectProfile records a profile declaration. The selected profile's variables
+// are stored at once, so assignments after the declaration see them.
+func (p *Parser) collectProfile(name, assignments string, pLine processedLine) error {
+	origin := fmt.Sprintf("%s:%d", pLine.originFile, pLine.originLine)
+	if previous, exists := p.profiles[name]; exists {
+		return p.errorAt(pLine, pLine.offsetOf(name), "profile '%s' is already declared at %s", name, previous.Origin)
+	}
+	p.recordReferences(assignments, pLine)
+	profile := &Profile{Name: name, Vars: make(map[string]string), Origin: origin}
+	for _, word := range splitArgs(assignments) {
+		key, value, found := strings.Cut(word, "=")
+		if !found || !isVariableName(key) {
+			return p.errorAt(pLine, pLine.offsetOf(word), "invalid profile assignment '%s'; expected VAR=value", word)
+		}
+		if err := p.checkAssignable(key, pLine); err != nil {
+			return err
+		}
+		expanded, err := p.variableStore.Expand(value, true)
+		if err != nil {
+			return p.errorAt(pLine, pLine.offsetOf(value), "error expanding variable value: %w", err)
+		}
+		profile.Vars[key] = expanded
+		if name == p.profile {
+			p.variableStore.Set(key, expanded, sourceProfile, pLine.originFile, pLine.originLine)
+		}
+	}
+	p.profiles[name] = profile
+	return nil
+}
+
+// collectTargetVar records a target-specific assignment such as
+// `dist/app.js: .WORKER = tsc`. Like any assignment, its value is expanded now.
+func (p *Parser) collectTargetVar(targets, assignment string, pLine processedLine) error {
+	left, right, _ := splitOnUnescaped(assignment, '=')
+	left, isPrivate := cutPrivate(left)
+	name, op, ok := parseAssignmentLeft(left)
+	if !ok {
+		return p.errorAt(pLine, -1, "invalid target-specific assignment with no variable name: \"%s\"", strings.TrimSpace(pLine.content))
+	}
+	if err := p.checkAssignable(name, pLine); err != nil {
+		return err
+	}
+	p.recordReferences(targets+right, pLine)
+	value, err := p.variableStore.Expand(strings.TrimSpace(right), true)
+	if err != nil {
+		return p.errorAt(pLine, -1, "error expanding variable value: %w", err)
+	}
+	p.targetVars = append(p.targetVars, rawTargetVar{
+		targets:   targets,
+		name:      name,
+		value:     value,
+		op:        op,
+		private:   isPrivate,
+		namespace: p.namespace,
+		line:      pLine,
+	})
+	return nil
+}
+
+// includeFile resolves an include directive, expanding variables defined so
+// far in its path, and collects the included file relative to the including one.
+func (p *Parser) includeFile(directive, line string, pLine processedLine) ([]rawRule, error) {
+	includePathStr, namespace := cutNamespace(strings.TrimSpace(line[len(directive):]))
+	includePathStr = trimQuotes(includePathStr)
+	if includePathStr == "" {
+		return nil, p.errorAt(pLine, -1, "empty include path")
+	}
+	includePathStr, err := p.variableStore.Expand(includePathStr, true)
+	if err != nil {
+		return nil, p.errorAt(pLine, -1, "error expanding include path: %w", err)
+	}
+	var includePath string
+	if isRemoteInclude(includePathStr) {
+		includePath, err = fetchRemoteInclude(includePathStr, p.offline, p.variableStore.isDebug)
+		if err != nil {
+			return nil, p.errorAt(pLine, -1, "%w", err)
+		}
+	} else {
+		includePath, err = p.resolveInclude(includePathStr, filepath.Dir(pLine.originFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if directive != "include" {
+		// `include?`, `-include` and `sinclude` silently skip files that don't exist (yet).
+		if _, statErr := os.Stat(includePath); os.IsNotExist(statErr) {
+			return nil, nil
+		}
+	}
+	if namespace != "" {
+		if !isVariableName(namespace) || strings.Contains(namespace, ".") {
+			return nil, p.errorAt(pLine, pLine.offsetOf(namespace), ErrorInvalidNamespace, namespace)
+		}
+		outer := p.namespace
+		if outer != "" {
+			namespace = outer + "." + namespace
+		}
+		p.namespace = namespace
+		p.variableStore.SetNamespace(namespace)
+		defer func() {
+			p.namespace = outer
+			p.variableStore.SetNamespace(outer)
+		}()
+	}
+	includedRules, err := p.collectFile(includePath)
+	if err != nil {
+		return nil, fmt.Errorf("error in included file %s (from %s:%d): %w", includePathStr, pLine.originFile, pLine.originLine, err)
+	}
+	return includedRules, nil
+}
+
+// cutNamespace splits `path as name` into the path and the namespace of an
+// include directive; without `as`, the namespace is empty.
+func cutNamespace(rest string) (string, string) {
+	fields := strings.Fields(rest)
+	if len(fields) < 3 || fields[len(fields)-2] != "as" {
+		return rest, ""
+	}
+	name := fields[len(fields)-1]
+	path := strings.TrimSpace(strings.TrimSuffix(rest, name))
+	return strings.TrimSpace(strings.TrimSuffix(path, "as")), name
+}
+
+// resolveInclude finds a relative include next to the including file first,
+// then along the include search path. If it is found nowhere, the path next to
+// the including file is returned so that the error names the expected location.
+func (p *Parser) resolveInclude(path, includingDir string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	local := filepath.Join(includingDir, path)
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	for _, dir := range p.includeDirs {
+		candidate, err := filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			return "", fmt.Errorf("could not determine absolute path for %s: %w", candidate, err)
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return local, nil
+}
+
+// loadEnvFile reads a .env file and populates the variable store. A missing
+// file is skipped unless it is required.
+func (p *Parser) loadEnvFile(filename string, required bool) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			if p.variableStore.isDebug {
+				fmt.Fprintf(os.Stderr, DebugEnvFileMissing, filename)
+			}
+			return nil
+		}
 		if os.IsNotExist(err) {
-			return nil // Silently ignore missing .env files
+			return fmt.Errorf(ErrorEnvFileRequired, filename)
 		}
 		return fmt.Errorf("could not load env file %s: %w", filename, err)
 	}
-	defer func() {
-		if closeErr := file.Close(); err == nil {
-			err = closeErr
+	entries, err := parseEnvFile(string(content))
+	if err != nil {
+		return fmt.Errorf("in env file %s: %w", filename, err)
+	}
+	for _, entry := range entries {
+		p.variableStore.Set(entry.key, entry.value, sourceEnvFile, filename, entry.line)
+		p.variables = append(p.variables, &VariableDef{
+			Name:   entry.key,
+			Op:     opLoadEnv,
+			Origin: fmt.Sprintf("%s:%d", filename, entry.line),
+		})
+	}
+	return nil
+}
+
+// collectLoadConfig handles `load_config [--required] file [prefix=NAME]`.
+func (p *Parser) collectLoadConfig(rest string, pLine processedLine) error {
+	p.recordReferences(rest, pLine)
+	expanded, err := p.variableStore.Expand(rest, true)
+	if err != nil {
+		return p.errorAt(pLine, -1, "error expanding load_config arguments: %w", err)
+	}
+	var path, prefix string
+	required := false
+	for _, arg := range splitArgs(expanded) {
+		switch {
+		case arg == "--required":
+			required = true
+		case strings.HasPrefix(arg, "prefix="):
+			prefix = strings.TrimPrefix(arg, "prefix=")
+			if !isVariableName(prefix) {
+				return p.errorAt(pLine, pLine.offsetOf(arg), ErrorConfigOption, arg)
+			}
+		case path == "":
+			path = arg
+		default:
+			return p.errorAt(pLine, pLine.offsetOf(arg), ErrorConfigOption, arg)
 		}
-	}()
-	scanner := bufio.NewScanner(file)
-	for lineNum := 1; scanner.Scan(); lineNum++ {
-		key, val, ok := cleanEnvLine(scanner.Text())
-		if ok {
-			p.variableStore.Set(key, val, sourceEnvFile, filename, lineNum)
+	}
+	if path == "" {
+		return p.errorAt(pLine, -1, "load_config needs a file name")
+	}
+	if err := p.loadConfigFile(path, p.qualify(prefix), required); err != nil {
+		return p.errorAt(pLine, -1, "%w", err)
+	}
+	return nil
+}
+
+// ruleDescription returns the description of the rule defined on line i: a
+// trailing `## text` comment on the rule line, or else the comment block above it.
+func ruleDescription(lines []processedLine, i int) string {
+	if lines[i].helpText != "" {
+		return lines[i].helpText
+	}
+	return precedingComment(lines, i)
+}
+
+// groupAnnotation recognizes a `## @group name Optional title` comment, which
+// files the rules after it under a heading in `--list`. A bare `## @group`
+// ends the current group.
+func groupAnnotation(comment string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(comment, "@group")
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return "", "", false
+	}
+	name, title, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	return name, trimQuotes(strings.TrimSpace(title)), true
+}
+
+// precedingComment returns the block of full-line comments directly above
+// line i, joined into a single description. A blank line ends the block, and
+// decorative section markers such as `# --- Targets ---` are left out.
+func precedingComment(lines []processedLine, i int) string {
+	var block []string
+	for k := i - 1; k >= 0 && lines[k].content == "" && lines[k].comment != ""; k-- {
+		comment := lines[k].comment
+		if strings.HasPrefix(comment, "---") || strings.HasPrefix(comment, "===") {
+			continue
+		}
+		if _, _, ok := groupAnnotation(comment); ok {
+			continue
+		}
+		block = append([]string{comment}, block...)
+	}
+	return strings.Join(block, " ")
+}
+
+// recordReferences notes every variable referenced as $(NAME) or $NAME in
+// text, which is part of the given line, along with where it was referenced.
+func (p *Parser) recordReferences(text string, pLine processedLine) {
+	for _, name := range variableReferences(text) {
+		if p.namespace != "" && !strings.Contains(name, ".") {
+			name = p.qualify(name) // Resolved once every variable is known; see unqualifyReferences
+		}
+		p.referenceSites = append(p.referenceSites, VariableRef{
+			Name:   name,
+			Origin: fmt.Sprintf("%s:%d", pLine.originFile, pLine.originLine),
+			Text:   strings.TrimSpace(pLine.content),
+		})
+		if !p.referenced[name] {
+			p.referenced[name] = true
+			p.references = append(p.references, name)
 		}
 	}
-	return scanner.Err()
+}
+
+// unqualifyReferences turns the references made inside namespaced fragments to
+// names the fragment does not define back into references to global variables.
+func (p *Parser) unqualifyReferences() {
+	defined := make(map[string]bool)
+	for _, def := range p.variables {
+		defined[def.Name] = true
+	}
+	unqualify := func(name string) string {
+		if defined[name] || !p.variableStore.inNamespace(name) {
+			return name
+		}
+		return name[strings.LastIndexByte(name, '.')+1:]
+	}
+	for i := range p.referenceSites {
+		p.referenceSites[i].Name = unqualify(p.referenceSites[i].Name)
+	}
+	references := p.references
+	p.references, p.referenced = nil, make(map[string]bool)
+	for _, name := range references {
+		if name = unqualify(name); !p.referenced[name] {
+			p.referenced[name] = true
+			p.references = append(p.references, name)
+		}
+	}
+}
+
+// variableReferences scans text for $(NAME) and $NAME references, skipping
+// escaped `$$`. Function calls such as $(shell ...) are not references, but
+// references nested inside them are.
+func variableReferences(text string) []string {
+	var names []string
+	for i := 0; i < len(text)-1; i++ {
+		if text[i] != '$' {
+			continue
+		}
+		if text[i+1] == '$' {
+			i++
+			continue
+		}
+		rest := text[i+1:]
+		if rest[0] == '(' {
+			end := strings.IndexAny(rest, " )")
+			if end > 1 && rest[end] == ')' && isVariableName(rest[1:end]) {
+				names = append(names, rest[1:end])
+			}
+			continue
+		}
+		n := 0
+		for n < len(rest) && isVariableChar(rest[n]) {
+			n++
+		}
+		if n > 0 {
+			names = append(names, rest[:n])
+		}
+	}
+	return names
+}
+
+// isVariableName reports whether s is a plain variable name.
+func isVariableName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isVariableChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
 }
@@ -0,0 +1,56 @@
+// cmd/make-lite/plugins.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// findFunctionPlugin locates the executable behind a custom function: first
+// .make-lite/functions/make-lite-fn-<name> next to the makefile, so a project
+// can ship its own, then make-lite-fn-<name> on PATH.
+func (vs *VariableStore) findFunctionPlugin(name string) (string, error) {
+	executable := FunctionPluginPrefix + name
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf(ErrorFunctionPluginName, name)
+	}
+	local := vs.absolutePath(filepath.Join(StateDir, FunctionPluginDir, executable))
+	if info, err := os.Stat(local); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+		return local, nil
+	}
+	if path, err := exec.LookPath(executable); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf(ErrorFunctionPluginNotFound, name, executable, filepath.Join(StateDir, FunctionPluginDir))
+}
+
+// runFunctionPlugin implements $(x name args...): the custom function's
+// executable runs with the arguments, split like a shell command line, and the
+// makefile's exported variables in its environment. Its output, without
+// trailing newlines, is substituted; a failure stops the expansion.
+func (vs *VariableStore) runFunctionPlugin(args []string) (string, error) {
+	words := splitArgs(args[0])
+	if len(words) == 0 {
+		return "", fmt.Errorf(ErrorFunctionArgCount, 0, "x", 1)
+	}
+	path, err := vs.findFunctionPlugin(words[0])
+	if err != nil {
+		return "", err
+	}
+	if vs.isDebug {
+		fmt.Fprintf(os.Stderr, DebugFunctionPlugin, words[0], path, words[1:])
+	}
+	cmd := exec.Command(path, words[1:]...)
+	cmd.Env = vs.getEnvironment()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf(ErrorFunctionPluginFailed, words[0], err, stderr.String())
+	}
+	return strings.TrimRight(stdout.String(), "\n\r"), nil
+}
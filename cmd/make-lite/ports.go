@@ -0,0 +1,119 @@
+// cmd/make-lite/ports.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkServicePorts verifies that every port a service declares is free, and
+// names the holder of any port that is not.
+func (e *Engine) checkServicePorts(rule *Rule) error {
+	for _, port := range rule.Ports {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			if closeErr := listener.Close(); closeErr != nil {
+				return closeErr
+			}
+			continue
+		}
+		return fmt.Errorf(ErrorPortInUse, port, rule.Targets[0], e.describePortHolder(port))
+	}
+	return nil
+}
+
+// describePortHolder explains who is listening on a port: another running
+// make-lite service declaring it, or, where /proc is available, a foreign process.
+func (e *Engine) describePortHolder(port int) string {
+	for _, rule := range e.makefile.Rules {
+		if !rule.IsService {
+			continue
+		}
+		for _, p := range rule.Ports {
+			if p != port {
+				continue
+			}
+			if pid, ok := runningServicePID(rule.Targets[0]); ok {
+				return fmt.Sprintf("make-lite service '%s' (pid %d)", rule.Targets[0], pid)
+			}
+		}
+	}
+	if pid, ok := findListeningPID(port); ok {
+		comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+		if err == nil {
+			return fmt.Sprintf("process '%s' (pid %d)", strings.TrimSpace(string(comm)), pid)
+		}
+		return fmt.Sprintf("process %d", pid)
+	}
+	return "another process"
+}
+
+// findListeningPID looks up the process with a TCP socket listening on port
+// by matching socket inodes from /proc/net/tcp{,6} against /proc/*/fd.
+// It returns false on systems without /proc or when the owner is not visible.
+func findListeningPID(port int) (int, bool) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, inode := range listeningInodes(table, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, false
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, false
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				return pid, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// listeningInodes returns the socket inodes in a /proc/net/tcp-style table
+// that are in the LISTEN state on the given local port.
+func listeningInodes(table string, port int) []string {
+	file, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = file.Close() }()
+
+	const listenState = "0A"
+	portHex := fmt.Sprintf(":%04X", port)
+	var inodes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Columns: sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != listenState || !strings.HasSuffix(fields[1], portHex) {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
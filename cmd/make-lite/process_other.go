@@ -0,0 +1,60 @@
+//go:build !unix
+
+// cmd/make-lite/process_other.go
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// jobserverSupported is false: GNU make's jobserver is a semaphore on Windows.
+const jobserverSupported = false
+
+// detachedProcAttr has no portable equivalent outside unix; the process is started normally.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// recipeProcAttr starts recipe commands normally; process groups are not available here.
+func recipeProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// forwardSignal sends sig to the process, or kills it where sig cannot be delivered.
+func forwardSignal(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if p.Signal(sig) != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+// signalProcessGroup kills the process; process groups are not available here.
+func signalProcessGroup(pid int, force bool) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// tryLockFile always succeeds: advisory file locks are only implemented on unix,
+// so mutexes do not exclude other processes here.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// lockFile is a no-op; see tryLockFile.
+func lockFile(f *os.File) error {
+	return nil
+}
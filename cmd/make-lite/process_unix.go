@@ -0,0 +1,65 @@
+//go:build unix
+
+// cmd/make-lite/process_unix.go
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// jobserverSupported reports whether recipes can share a GNU make jobserver,
+// which they inherit as pipe descriptors or open as a named pipe.
+const jobserverSupported = true
+
+// detachedProcAttr starts a process in its own session so that it outlives
+// make-lite and can be signalled as a group.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// recipeProcAttr starts a recipe command in its own process group, so that an
+// interruption reaches every process it started.
+func recipeProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// forwardSignal sends sig to the process group led by pid.
+func forwardSignal(pid int, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		return syscall.Kill(-pid, s)
+	}
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// signalProcessGroup sends SIGTERM (or SIGKILL when force is set) to the
+// process group led by pid.
+func signalProcessGroup(pid int, force bool) error {
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	return syscall.Kill(-pid, sig)
+}
+
+// tryLockFile takes an exclusive advisory lock on f without blocking and
+// reports false if another process holds it. Closing f releases the lock.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile blocks until it holds an exclusive advisory lock on f.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,75 @@
+// cmd/make-lite/pty.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// When --pty runs recipes under a pseudo-terminal.
+const (
+	PTYAuto = "auto" // When make-lite's own output goes to a terminal
+	PTYOn   = "on"   // Always
+	PTYOff  = "off"  // Never
+)
+
+// usePTY decides from the --pty mode whether recipes run under a
+// pseudo-terminal: always with on, never with off, and with auto when
+// make-lite's own output goes to a terminal rather than being captured.
+func usePTY(mode string) (bool, error) {
+	switch mode {
+	case "", PTYAuto:
+		return ptySupported && isTerminal(os.Stdout), nil
+	case PTYOn:
+		if !ptySupported {
+			return false, errors.New(ErrorPTYUnsupported)
+		}
+		return true, nil
+	case PTYOff:
+		return false, nil
+	}
+	return false, fmt.Errorf(ErrorInvalidPTY, mode)
+}
+
+// runInPTY runs a recipe command with a pseudo-terminal as its stdout and
+// stderr, so tools that check for a terminal keep their color and progress
+// output, which is relayed to the command's own stdout. Its stderr is the same
+// terminal, so both arrive there in order.
+func (e *Engine) runInPTY(cmd *exec.Cmd, master, slave *os.File) error {
+	setupPTY(master, slave)
+	out := cmd.Stdout
+	cmd.Stdout, cmd.Stderr = slave, slave
+	cmd.SysProcAttr = ptyProcAttr()
+	relayed := make(chan struct{})
+	go func() {
+		// Reading the master fails once the recipe and everything it started
+		// have closed the terminal.
+		_, _ = io.Copy(out, master)
+		_ = master.Close()
+		close(relayed)
+	}()
+	err := e.runCommand(cmd)
+	_ = slave.Close()
+	awaitRelays(relayed)
+	return err
+}
+
+// ptyEnabled reports whether recipes run under a pseudo-terminal.
+func (e *Engine) ptyEnabled() bool {
+	e.ptyMu.Lock()
+	defer e.ptyMu.Unlock()
+	return e.pty
+}
+
+// ptyFailed warns, once, that recipes run without a pseudo-terminal.
+func (e *Engine) ptyFailed(err error) {
+	e.ptyMu.Lock()
+	defer e.ptyMu.Unlock()
+	if e.pty {
+		e.pty = false
+		fmt.Fprintf(os.Stderr, WarningPTYUnavailable, err)
+	}
+}
@@ -0,0 +1,41 @@
+// cmd/make-lite/pty_darwin.go
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+// openPTY opens a new pseudo-terminal through /dev/ptmx, returning its master
+// and slave ends.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := make([]byte, 128)
+	if err = ioctl(master.Fd(), syscall.TIOCPTYGRANT, nil); err == nil {
+		err = ioctl(master.Fd(), syscall.TIOCPTYUNLK, nil)
+	}
+	if err == nil {
+		err = ioctl(master.Fd(), syscall.TIOCPTYGNAME, unsafe.Pointer(&name[0]))
+	}
+	if err == nil {
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		slave, err = os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+// cmd/make-lite/pty_other.go
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ptySupported is false: make-lite opens pseudo-terminals on Linux and macOS only.
+const ptySupported = false
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func setupPTY(master, slave *os.File) {}
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are not supported on this system")
+}
+
+func ptyProcAttr() *syscall.SysProcAttr {
+	return recipeProcAttr()
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+// cmd/make-lite/pty_unix.go
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ptySupported reports whether recipes can run under a pseudo-terminal.
+const ptySupported = true
+
+// winsize is the size of a terminal, as TIOCGWINSZ and TIOCSWINSZ pass it.
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f.Fd(), ioctlGetTermios, unsafe.Pointer(&termios)) == nil
+}
+
+// setupPTY gives a pseudo-terminal the size of make-lite's own terminal, so
+// progress bars fit it, or 80 columns by 24 rows when there is none. Line
+// ends are passed on as written rather than turned into CR LF, as the
+// terminal or log the output is relayed to has its own conventions.
+func setupPTY(master, slave *os.File) {
+	size := winsize{rows: 24, cols: 80}
+	var own winsize
+	if ioctl(os.Stdout.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&own)) == nil && own.rows > 0 && own.cols > 0 {
+		size = own
+	}
+	_ = ioctl(master.Fd(), syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+	var termios syscall.Termios
+	if ioctl(slave.Fd(), ioctlGetTermios, unsafe.Pointer(&termios)) == nil {
+		termios.Oflag &^= syscall.ONLCR
+		_ = ioctl(slave.Fd(), ioctlSetTermios, unsafe.Pointer(&termios))
+	}
+}
+
+// ptyProcAttr starts a recipe command in its own session, with the
+// pseudo-terminal on its stdout as its controlling terminal. The session is
+// also a process group, so interruptions are forwarded as usual.
+func ptyProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+}
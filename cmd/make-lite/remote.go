@@ -0,0 +1,109 @@
+// cmd/make-lite/remote.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isRemoteInclude reports whether an include path names a fragment to download.
+func isRemoteInclude(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// splitRemoteInclude separates `https://host/file.mk-lite@sha256:<digest>`
+// into its URL and lowercase hex digest. The digest is mandatory, so a
+// fragment changing on the server can never silently change the build.
+func splitRemoteInclude(ref string) (string, string, error) {
+	if strings.HasPrefix(ref, "http://") {
+		return "", "", fmt.Errorf(ErrorRemoteInsecure, ref)
+	}
+	i := strings.LastIndex(ref, RemoteDigestSeparator)
+	if i < 0 {
+		return "", "", fmt.Errorf(ErrorRemoteNoDigest, ref)
+	}
+	url, digest := ref[:i], strings.ToLower(ref[i+len(RemoteDigestSeparator):])
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", "", fmt.Errorf(ErrorRemoteBadDigest, ref)
+	}
+	return url, digest, nil
+}
+
+// remoteCacheDir is where downloaded fragments are kept, named by their digest:
+// ~/.cache/make-lite/includes on Linux.
+func remoteCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, RemoteCacheSubdir), nil
+}
+
+// fetchRemoteInclude returns the path of a local copy of a pinned remote
+// fragment. A cached copy is used whenever its content still matches the
+// digest; otherwise the fragment is downloaded, unless offline is set.
+func fetchRemoteInclude(ref string, offline, isDebug bool) (string, error) {
+	url, digest, err := splitRemoteInclude(ref)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := remoteCacheDir()
+	if err != nil {
+		return "", fmt.Errorf(ErrorRemoteCacheDir, err)
+	}
+	cached := filepath.Join(cacheDir, digest+".mk-lite")
+
+	if content, err := os.ReadFile(cached); err == nil {
+		if sha256Hex(content) == digest {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, DebugRemoteIncludeCached, url, cached)
+			}
+			return cached, nil
+		}
+		// A damaged cache entry is simply downloaded again.
+		_ = os.Remove(cached)
+	}
+	if offline {
+		return "", fmt.Errorf(ErrorRemoteOffline, url)
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, DebugRemoteIncludeFetch, url)
+	}
+	client := &http.Client{Timeout: RemoteIncludeTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf(ErrorRemoteFetch, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(ErrorRemoteFetch, url, resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf(ErrorRemoteFetch, url, err)
+	}
+	if got := sha256Hex(content); got != digest {
+		return "", fmt.Errorf(ErrorRemoteDigestMismatch, url, digest, got)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf(ErrorRemoteCacheDir, err)
+	}
+	if err := writeFileAtomic(cached, content, 0644); err != nil {
+		return "", fmt.Errorf(ErrorRemoteCacheDir, err)
+	}
+	return cached, nil
+}
+
+// sha256Hex returns the lowercase hex SHA-256 digest of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
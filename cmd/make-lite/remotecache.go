@@ -0,0 +1,402 @@
+// cmd/make-lite/remotecache.go
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directions in which the remote output cache is used, from --cache-remote-mode.
+const (
+	RemoteCacheRead      = "read"      // Restore from it, never upload
+	RemoteCacheWrite     = "write"     // Upload to it, never restore
+	RemoteCacheReadWrite = "readwrite" // Both
+)
+
+// errRemoteMiss reports an action the remote cache has no entry for.
+var errRemoteMiss = errors.New("not in the remote cache")
+
+// remoteCache is a shared store of output cache entries, each an archive
+// named by its action key.
+type remoteCache interface {
+	get(key string) ([]byte, error) // errRemoteMiss when there is no entry
+	put(key string, data []byte) error
+}
+
+// newRemoteCache returns the backend for a --cache-remote URL: an HTTP(S)
+// server taking GET and PUT requests under the URL, such as bazel-remote or
+// nginx with WebDAV, or an S3-compatible bucket given as `s3://bucket/prefix`.
+func newRemoteCache(rawURL string) (remoteCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf(ErrorInvalidCacheRemote, rawURL)
+	}
+	client := &http.Client{Timeout: RemoteCacheTimeout}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpCache{base: strings.TrimSuffix(rawURL, "/"), token: os.Getenv(CacheTokenEnvVar), client: client}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf(ErrorInvalidCacheRemote, rawURL)
+		}
+		return newS3Cache(u.Host, strings.Trim(u.Path, "/"), client)
+	default:
+		return nil, fmt.Errorf(ErrorInvalidCacheRemote, rawURL)
+	}
+}
+
+// remoteStatus turns the response to a remote cache request into an error.
+func remoteStatus(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errRemoteMiss
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// httpCache keeps entries at `<base>/<key>.tar` on an HTTP server.
+type httpCache struct {
+	base   string
+	token  string // Sent as a bearer token, from MAKE_LITE_CACHE_TOKEN
+	client *http.Client
+}
+
+func (c *httpCache) do(method, key string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.base+"/"+key+".tar", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := remoteStatus(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *httpCache) get(key string) ([]byte, error) {
+	return c.do(http.MethodGet, key, nil)
+}
+
+func (c *httpCache) put(key string, data []byte) error {
+	_, err := c.do(http.MethodPut, key, data)
+	return err
+}
+
+// s3Cache keeps entries at `<prefix>/<key>.tar` in an S3 bucket, addressed
+// path-style so that S3-compatible stores such as MinIO work too. Requests
+// are signed with AWS Signature Version 4 from the usual AWS_* variables.
+type s3Cache struct {
+	endpoint     string // AWS_ENDPOINT_URL, or the regional AWS endpoint
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Cache(bucket, prefix string, client *http.Client) (*s3Cache, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	c := &s3Cache{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       client,
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New(ErrorS3Credentials)
+	}
+	return c, nil
+}
+
+func (c *s3Cache) do(method, key string, body []byte) ([]byte, error) {
+	object := key + ".tar"
+	if c.prefix != "" {
+		object = c.prefix + "/" + object
+	}
+	req, err := http.NewRequest(method, c.endpoint+"/"+c.bucket+"/"+object, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, body, time.Now().UTC())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := remoteStatus(resp); err != nil {
+		if resp.StatusCode == http.StatusForbidden && method == http.MethodGet {
+			// Without ListBucket permission, S3 answers 403 for a missing object.
+			return nil, errRemoteMiss
+		}
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *s3Cache) get(key string) ([]byte, error) {
+	return c.do(http.MethodGet, key, nil)
+}
+
+func (c *s3Cache) put(key string, data []byte) error {
+	_, err := c.do(http.MethodPut, key, data)
+	return err
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a request,
+// covering every header already set and the SHA-256 of the body, which S3
+// checks, so a damaged upload is refused.
+func (c *s3Cache) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// cacheManifest is the archive member listing the SHA-256 of every other one.
+const cacheManifest = "MANIFEST"
+
+// packEntry archives a local cache entry for upload.
+func packEntry(entry string) ([]byte, error) {
+	files, err := os.ReadDir(entry)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	var manifest strings.Builder
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(entry, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: file.Name(), Mode: int64(info.Mode().Perm()), Size: int64(len(data))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+		manifest.WriteString(file.Name() + " " + sha256Hex(data) + "\n")
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: cacheManifest, Mode: 0644, Size: int64(manifest.Len())}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write([]byte(manifest.String())); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unpackEntry checks a downloaded archive against its manifest and writes it
+// as a local cache entry. Nothing is written unless every member is intact.
+func unpackEntry(data []byte, entry string) error {
+	members := make(map[string][]byte)
+	modes := make(map[string]os.FileMode)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name != filepath.Base(hdr.Name) || hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected archive member '%s'", hdr.Name)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		members[hdr.Name] = content
+		modes[hdr.Name] = os.FileMode(hdr.Mode).Perm()
+	}
+	manifest, ok := members[cacheManifest]
+	if !ok {
+		return errors.New("archive has no manifest")
+	}
+	delete(members, cacheManifest)
+	listed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		name, digest, _ := strings.Cut(scanner.Text(), " ")
+		content, ok := members[name]
+		if !ok || sha256Hex(content) != digest {
+			return fmt.Errorf("member '%s' does not match the manifest", name)
+		}
+		listed++
+	}
+	if listed != len(members) {
+		return errors.New("archive has members missing from the manifest")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(entry), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for name, content := range members {
+		if err := os.WriteFile(filepath.Join(tmp, name), content, modes[name]); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, entry); err != nil {
+		if _, statErr := os.Stat(entry); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// remoteUsable reports whether the remote cache is used in a direction: it
+// is configured for it, and has not failed during this build.
+func (e *Engine) remoteUsable(direction string) bool {
+	if e.remote == nil || (e.opts.CacheRemoteMode != RemoteCacheReadWrite && e.opts.CacheRemoteMode != direction) {
+		return false
+	}
+	e.remoteMu.Lock()
+	defer e.remoteMu.Unlock()
+	return !e.remoteDown
+}
+
+// remoteFailed stops using the remote cache for the rest of the build after
+// an error, such as the network being down, so the build carries on with
+// only the local cache instead of waiting for every request to time out.
+func (e *Engine) remoteFailed(err error) {
+	e.remoteMu.Lock()
+	defer e.remoteMu.Unlock()
+	if !e.remoteDown {
+		e.remoteDown = true
+		fmt.Fprintf(os.Stderr, WarningRemoteCacheDown, err)
+	}
+}
+
+// fetchRemote downloads the entry of an action into the local cache,
+// reporting whether it did.
+func (e *Engine) fetchRemote(key, entry string) bool {
+	if !e.remoteUsable(RemoteCacheRead) {
+		return false
+	}
+	data, err := e.remote.get(key)
+	if errors.Is(err, errRemoteMiss) {
+		return false
+	}
+	if err != nil {
+		e.remoteFailed(err)
+		return false
+	}
+	if err := unpackEntry(data, entry); err != nil {
+		fmt.Fprintf(os.Stderr, WarningRemoteCacheCorrupt, key, err)
+		return false
+	}
+	if e.isDebug {
+		fmt.Printf(DebugRemoteCacheFetched, key)
+	}
+	return true
+}
+
+// pushRemote uploads the local cache entry of an action.
+func (e *Engine) pushRemote(key, entry string) {
+	if !e.remoteUsable(RemoteCacheWrite) {
+		return
+	}
+	data, err := packEntry(entry)
+	if err == nil {
+		err = e.remote.put(key, data)
+	}
+	if err != nil {
+		e.remoteFailed(err)
+		return
+	}
+	if e.isDebug {
+		fmt.Printf(DebugRemoteCacheStored, key)
+	}
+}
@@ -0,0 +1,149 @@
+// cmd/make-lite/resources.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resourceUnits are the suffixes an amount may have, as in `memory=8G`.
+var resourceUnits = map[string]float64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// parseResourceAmount reads an amount such as `4`, `1.5G` or `512M`.
+func parseResourceAmount(s string) (float64, bool) {
+	scale := 1.0
+	if n := len(s); n > 0 {
+		if unit, ok := resourceUnits[strings.ToUpper(s[n-1:])]; ok {
+			scale, s = unit, s[:n-1]
+		}
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return 0, false
+	}
+	return amount * scale, true
+}
+
+// parseResources reads `name=amount` words, where a bare name stands for one
+// unit, into amounts by name.
+func parseResources(words []string) (map[string]float64, bool) {
+	amounts := make(map[string]float64)
+	for _, word := range words {
+		name, value, hasAmount := strings.Cut(word, "=")
+		amount := 1.0
+		if hasAmount {
+			var ok bool
+			if amount, ok = parseResourceAmount(value); !ok {
+				return nil, false
+			}
+		}
+		if name == "" {
+			return nil, false
+		}
+		amounts[name] += amount
+	}
+	return amounts, true
+}
+
+// parseResource reads the prerequisites of `.RESOURCE: target resource...`
+// and adds the resources to those the target's recipe uses.
+func (m *Makefile) parseResource(words []string) error {
+	if len(words) < 2 {
+		return errors.New(ErrorResourceSyntax)
+	}
+	amounts, ok := parseResources(words[1:])
+	if !ok {
+		return errors.New(ErrorResourceSyntax)
+	}
+	if m.Resources == nil {
+		m.Resources = make(map[string]map[string]float64)
+	}
+	if m.Resources[words[0]] == nil {
+		m.Resources[words[0]] = make(map[string]float64)
+	}
+	for name, amount := range amounts {
+		m.Resources[words[0]][name] += amount
+	}
+	return nil
+}
+
+// resourcePool tracks how much of each resource the running jobs hold.
+type resourcePool struct {
+	limits map[string]float64 // From `.RESOURCE_LIMITS`; a resource not listed has 1
+	inUse  map[string]float64
+}
+
+// newResourcePool reads the capacities in `.RESOURCE_LIMITS`.
+func (e *Engine) newResourcePool() (*resourcePool, error) {
+	e.mu.Lock()
+	value, _ := e.vars.Get(ResourceLimitsVar)
+	e.mu.Unlock()
+	limits, ok := parseResources(splitWords(value))
+	if !ok {
+		return nil, fmt.Errorf(ErrorResourceLimits, value)
+	}
+	return &resourcePool{limits: limits, inUse: make(map[string]float64)}, nil
+}
+
+// nodeResources adds up the resources `.RESOURCE:` gives the targets of a node.
+func (e *Engine) nodeResources(node *buildNode) map[string]float64 {
+	if len(e.makefile.Resources) == 0 {
+		return nil
+	}
+	seen := map[string]bool{node.name: true}
+	needs := make(map[string]float64)
+	for name, amount := range e.makefile.Resources[node.name] {
+		needs[name] += amount
+	}
+	for _, rule := range node.rules {
+		for _, target := range rule.Targets {
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			for name, amount := range e.makefile.Resources[target] {
+				needs[name] += amount
+			}
+		}
+	}
+	return needs
+}
+
+// blocker returns the first resource, by name, that running jobs hold too
+// much of for a job needing these amounts to start, or "" if it may start. A
+// job that needs more than the whole capacity runs once nothing else holds
+// that resource, so it is slowed down rather than never run.
+func (p *resourcePool) blocker(needs map[string]float64) string {
+	names := make([]string, 0, len(needs))
+	for name := range needs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit, ok := p.limits[name]
+		if !ok {
+			limit = 1
+		}
+		if used := p.inUse[name]; used > 0 && used+needs[name] > limit {
+			return name
+		}
+	}
+	return ""
+}
+
+// acquire and release account for a job starting and finishing.
+func (p *resourcePool) acquire(needs map[string]float64) {
+	for name, amount := range needs {
+		p.inUse[name] += amount
+	}
+}
+
+func (p *resourcePool) release(needs map[string]float64) {
+	for name, amount := range needs {
+		p.inUse[name] -= amount
+	}
+}
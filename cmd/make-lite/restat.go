@@ -0,0 +1,93 @@
+// cmd/make-lite/restat.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// restatRecord remembers a `.RESTAT` target that its recipe rewrote with the
+// content it already had.
+type restatRecord struct {
+	ModTime time.Time `json:"mtime"`   // Modification time the rewrite left the file with
+	Changed time.Time `json:"changed"` // Modification time of the last build that changed its content
+}
+
+// fileDigest returns a digest of a regular file's content.
+func fileDigest(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// restatDigests hashes the existing targets of a rule listed in `.RESTAT:`
+// before its recipe runs, so recordRestat can tell whether it changed them.
+func (e *Engine) restatDigests(rule *Rule) map[string]string {
+	if !e.makefile.Restat.Covers(rule) {
+		return nil
+	}
+	digests := make(map[string]string, len(rule.Targets))
+	for _, target := range rule.Targets {
+		if digest, ok := fileDigest(target); ok {
+			digests[target] = digest
+		}
+	}
+	return digests
+}
+
+// recordRestat notes the targets a recipe rewrote without changing their
+// content, such as the output of a code generator run again on the same input.
+// Rules depending on them then compare against the time the content last
+// changed instead of the new modification time, and are not rebuilt.
+func (e *Engine) recordRestat(rule *Rule, before map[string]time.Time, digests map[string]string) {
+	if digests == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state := e.loadState()
+	for _, target := range rule.Targets {
+		info, err := os.Stat(target)
+		digest, ok := fileDigest(target)
+		if err != nil || !ok || digest != digests[target] {
+			if _, known := state.Restat[target]; known {
+				delete(state.Restat, target)
+				e.stateChanged = true
+			}
+			continue
+		}
+		changed := e.contentModTime(target, before[target])
+		if info.ModTime().Equal(changed) {
+			continue
+		}
+		if e.isDebug {
+			fmt.Printf(DebugRestatUnchanged, target)
+		}
+		state.Restat[target] = restatRecord{ModTime: info.ModTime(), Changed: changed}
+		e.stateChanged = true
+	}
+}
+
+// contentModTime is the modification time a prerequisite is compared by: the
+// time of the last build that changed it, if it was since rewritten unchanged
+// and not touched otherwise, or else its modification time.
+func (e *Engine) contentModTime(path string, modTime time.Time) time.Time {
+	if record, ok := e.loadState().Restat[path]; ok && record.ModTime.Equal(modTime) {
+		return record.Changed
+	}
+	return modTime
+}
@@ -0,0 +1,75 @@
+// cmd/make-lite/retry.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy is how often a failed recipe is run again before the build
+// fails, from `.RETRY:` or --retry.
+type RetryPolicy struct {
+	Retries int           // Attempts after the first
+	Delay   time.Duration // Wait before the first retry; doubled before each later one
+}
+
+// parseRetry reads the prerequisites of `.RETRY: target... count [delay]`
+// and records the policy for each target.
+func (m *Makefile) parseRetry(words []string) error {
+	delay := DefaultRetryDelay
+	if len(words) >= 3 {
+		if d, err := time.ParseDuration(words[len(words)-1]); err == nil {
+			delay, words = d, words[:len(words)-1]
+		}
+	}
+	if len(words) < 2 {
+		return errors.New(ErrorRetrySyntax)
+	}
+	retries, err := strconv.Atoi(words[len(words)-1])
+	if err != nil || retries < 0 || delay < 0 {
+		return errors.New(ErrorRetrySyntax)
+	}
+	if m.Retries == nil {
+		m.Retries = make(map[string]RetryPolicy)
+	}
+	for _, target := range words[:len(words)-1] {
+		m.Retries[target] = RetryPolicy{Retries: retries, Delay: delay}
+	}
+	return nil
+}
+
+// retryPolicy returns the retries for a rule: those `.RETRY:` gives any of its
+// targets, or else the ones of --retry.
+func (e *Engine) retryPolicy(rule *Rule) RetryPolicy {
+	for _, target := range rule.Targets {
+		if policy, ok := e.makefile.Retries[target]; ok {
+			return policy
+		}
+	}
+	return RetryPolicy{Retries: e.opts.Retry, Delay: DefaultRetryDelay}
+}
+
+// executeWithRetries runs a rule's recipe, and runs it again from the first
+// line after a failure while its retry policy allows, waiting longer before
+// each attempt. The error of the last attempt is returned. An interrupted
+// build is not retried.
+func (e *Engine) executeWithRetries(rule *Rule) error {
+	policy := e.retryPolicy(rule)
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		err := e.executeRecipe(rule)
+		if err == nil || attempt > policy.Retries || e.interruption() != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, StatusRetrying, rule.Targets[0], err, delay, attempt+1, policy.Retries+1)
+		select {
+		case <-time.After(delay):
+		case <-e.interrupted:
+			return e.interruption()
+		}
+		delay *= 2
+	}
+}
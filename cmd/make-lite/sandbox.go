@@ -0,0 +1,193 @@
+// cmd/make-lite/sandbox.go
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// What --sandbox does when a recipe fails in its sandbox.
+const (
+	SandboxWarn  = "warn"  // Warn, then run the recipe again in the working directory
+	SandboxError = "error" // Fail the build
+)
+
+// sandboxInputs lists what a rule declares it reads: the prerequisites that
+// exist as files or directories, and the dependencies its depfile listed last
+// time.
+func (e *Engine) sandboxInputs(rule *Rule) []string {
+	var inputs []string
+	_ = e.inRuleScope(rule, func() error {
+		for _, source := range rule.Sources {
+			path := e.sourcePath(source)
+			if _, err := os.Stat(path); err == nil {
+				inputs = append(inputs, path)
+			}
+		}
+		if rule.Depfile != "" {
+			inputs = append(inputs, e.loadState().Depfiles[rule.Targets[0]]...)
+		}
+		return nil
+	})
+	return inputs
+}
+
+// insideWorkDir reports whether a relative path stays within the working
+// directory, so it has a place in a sandbox mirroring it.
+func insideWorkDir(path string) bool {
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// linkedAncestor reports whether a path lies in a directory already linked
+// into a sandbox, where creating it would write into the working directory.
+func linkedAncestor(path string, linked map[string]bool) bool {
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if linked[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareSandbox mirrors the working directory in dir with only a rule's
+// declared inputs, each a symbolic link to the real file or directory, and
+// copies of the targets that already exist, which recipes such as `ar r`
+// update in place. Absolute paths are left alone, as they reach the real
+// files from anywhere. It reports false if a target or input lies outside
+// the working directory, where the sandbox cannot hold it.
+func prepareSandbox(dir string, rule *Rule, inputs []string) (bool, error) {
+	for _, path := range append(append([]string(nil), rule.Targets...), inputs...) {
+		if !filepath.IsAbs(path) && !insideWorkDir(path) {
+			return false, nil
+		}
+	}
+	var paths []string
+	for _, input := range inputs {
+		if !filepath.IsAbs(input) {
+			paths = append(paths, filepath.Clean(input))
+		}
+	}
+	// A directory sorts before the paths inside it, which it then covers.
+	sort.Strings(paths)
+	linked := make(map[string]bool)
+	for _, path := range paths {
+		if linked[path] || linkedAncestor(path, linked) {
+			continue
+		}
+		real, err := filepath.Abs(path)
+		if err != nil {
+			return false, err
+		}
+		link := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return false, err
+		}
+		if err := os.Symlink(real, link); err != nil {
+			return false, err
+		}
+		linked[path] = true
+	}
+	for _, target := range rule.Targets {
+		path := filepath.Clean(target)
+		info, err := os.Stat(path)
+		if filepath.IsAbs(path) || linked[path] || linkedAncestor(path, linked) || err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		copied := filepath.Join(dir, path)
+		if err := copyFile(path, copied); err != nil {
+			return false, err
+		}
+		if err := os.Chtimes(copied, info.ModTime(), info.ModTime()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// collectSandbox copies every file a recipe wrote in its sandbox into the
+// working directory, keeping modification times. The links to inputs are
+// skipped; whatever the recipe wrote through them is already in place.
+func collectSandbox(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(rel, 0755)
+		case !entry.Type().IsRegular():
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := copyFile(path, rel); err != nil {
+			return err
+		}
+		return os.Chtimes(rel, info.ModTime(), info.ModTime())
+	})
+}
+
+// executeSandboxed runs a recipe under --sandbox in a temporary directory
+// holding only what the rule declares it reads, so one that reads an
+// unlisted file, such as a header missing from its prerequisites, fails
+// there instead of working until the next clean build. The files it writes
+// are copied back once it succeeds. The sandbox is not a security boundary:
+// absolute paths, such as $(CURDIR)/config.h, still reach the real tree.
+// Recipes run on a persistent worker are not sandboxed.
+func (e *Engine) executeSandboxed(rule *Rule) error {
+	var worker string
+	_ = e.inRuleScope(rule, func() error {
+		worker, _ = e.vars.Get(".WORKER")
+		return nil
+	})
+	if e.opts.Sandbox == "" || worker != "" || len(rule.Targets) == 0 {
+		return e.executeWithRetries(rule)
+	}
+	dir, err := os.MkdirTemp("", "make-lite-sandbox-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	inputs := e.sandboxInputs(rule)
+	ok, err := prepareSandbox(dir, rule, inputs)
+	if err != nil {
+		return fmt.Errorf(ErrorSandboxSetup, err)
+	}
+	if !ok {
+		if e.isDebug {
+			fmt.Printf(DebugSandboxSkipped, rule.Targets[0])
+		}
+		return e.executeWithRetries(rule)
+	}
+	if e.isDebug {
+		fmt.Printf(DebugSandboxRun, rule.Targets[0], dir, len(inputs))
+	}
+
+	sandboxed := *rule
+	sandboxed.Sandbox = dir
+	if err := e.executeWithRetries(&sandboxed); err != nil {
+		if e.interruption() != nil {
+			return err
+		}
+		if e.opts.Sandbox == SandboxError {
+			return fmt.Errorf(ErrorSandboxFailed, err)
+		}
+		fmt.Fprintf(os.Stderr, WarningSandboxFailed, rule.Targets[0], err)
+		return e.executeWithRetries(rule)
+	}
+	if err := collectSandbox(dir); err != nil {
+		return fmt.Errorf(ErrorSandboxSetup, err)
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+// cmd/make-lite/scheduler.go
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// buildNode is one target in the graph of a build: the rules that make it and
+// the nodes that must finish before they run.
+type buildNode struct {
+	name    string
+	rules   []*Rule      // After secondary expansion; nil for a file that no rule builds
+	deps    []*buildNode // Prerequisites, and the nodes a `.WAIT` orders it after
+	parents []*buildNode // Nodes that have this one in deps
+	order   int          // Position in a sequential build, which a single job reproduces
+	pending int          // Deps not finished yet, while the graph is executed
+	ran     bool         // A recipe ran, or its outputs came from the cache
+}
+
+// addDep records that n cannot start before dep has finished.
+func (n *buildNode) addDep(dep *buildNode) {
+	if dep == nil || dep == n {
+		return
+	}
+	for _, existing := range n.deps {
+		if existing == dep {
+			return
+		}
+	}
+	n.deps = append(n.deps, dep)
+}
+
+// dependsOn reports whether n has to wait for other, directly or through its deps.
+func (n *buildNode) dependsOn(other *buildNode, seen map[*buildNode]bool) bool {
+	if n == other {
+		return true
+	}
+	if seen[n] {
+		return false
+	}
+	seen[n] = true
+	for _, dep := range n.deps {
+		if dep.dependsOn(other, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGraph holds the targets a build still has to visit.
+type buildGraph struct {
+	nodes map[string]*buildNode // By target name; all targets of a multi-target rule share one node
+	order []*buildNode          // Every node after its deps, in the order a sequential build visits them
+}
+
+// buildGoal builds a target and everything it depends on: the graph is
+// planned first, then executed with up to --jobs recipes at a time.
+func (e *Engine) buildGoal(targetName string) error {
+	g := &buildGraph{nodes: make(map[string]*buildNode)}
+	if _, err := e.plan(g, targetName); err != nil {
+		return err
+	}
+	e.resetStats()
+	stop := e.watchSignals()
+	err := e.execute(g)
+	stop()
+	if stateErr := e.saveState(); err == nil {
+		err = stateErr
+	}
+	return err
+}
+
+// plan adds a target and its prerequisites to the graph, depth first, and
+// returns its node, or nil if it was built earlier in this run. Whether a file
+// that no rule builds exists is only checked when its node runs, since an
+// earlier recipe may create it.
+func (e *Engine) plan(g *buildGraph, targetName string) (*buildNode, error) {
+	targetName = e.makefile.Resolve(targetName)
+	if e.built[targetName] {
+		return nil, nil
+	}
+	if node, ok := g.nodes[targetName]; ok {
+		return node, nil
+	}
+	if e.visiting[targetName] {
+		return nil, fmt.Errorf("circular dependency detected: target '%s' is a dependency of itself", targetName)
+	}
+	e.visiting[targetName] = true
+	defer delete(e.visiting, targetName)
+
+	node := &buildNode{name: targetName}
+	rules, exists := e.makefile.RuleMap[targetName]
+	if !exists {
+		if inferred := e.inferRule(targetName); inferred != nil {
+			rules, exists = []*Rule{inferred}, true
+		}
+	}
+	if exists {
+		var err error
+		if node.rules, err = e.expandSecondary(rules, targetName); err != nil {
+			return nil, err
+		}
+		for _, rule := range node.rules {
+			deps := make([]*buildNode, len(rule.Sources))
+			for i, sourceName := range rule.Sources {
+				// sourceName is already expanded and split by the parser; it may contain blanks.
+				if deps[i], err = e.plan(g, sourceName); err != nil {
+					return nil, err
+				}
+				node.addDep(deps[i])
+			}
+			e.orderPrerequisites(rule, deps)
+			if err := e.planWorker(g, node, rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	node.order = len(g.order)
+	g.order = append(g.order, node)
+	g.nodes[targetName] = node
+	if len(node.rules) == 1 && !node.rules[0].DoubleColon {
+		// One recipe makes all the targets of a rule, so they must not run twice.
+		for _, t := range node.rules[0].Targets {
+			if _, ok := g.nodes[t]; !ok && !e.built[t] {
+				g.nodes[t] = node
+			}
+		}
+	}
+	return node, nil
+}
+
+// orderPrerequisites makes the prerequisites after each `.WAIT` in a rule wait
+// for those before it. For a target listed in `.NOTPARALLEL:`, every
+// prerequisite waits for the one before it. An ordering that contradicts the
+// dependencies themselves is dropped, as the dependencies already decide it.
+func (e *Engine) orderPrerequisites(rule *Rule, deps []*buildNode) {
+	boundaries := rule.Waits
+	if !e.makefile.NotParallel.All && e.makefile.NotParallel.Covers(rule) {
+		boundaries = make([]int, 0, len(deps))
+		for i := 1; i < len(deps); i++ {
+			boundaries = append(boundaries, i)
+		}
+	}
+	start := 0
+	for k, boundary := range boundaries {
+		end := len(deps)
+		if k+1 < len(boundaries) {
+			end = boundaries[k+1]
+		}
+		for _, after := range deps[boundary:end] {
+			for _, before := range deps[start:boundary] {
+				if after != nil && before != nil && !before.dependsOn(after, make(map[*buildNode]bool)) {
+					after.addDep(before)
+				}
+			}
+		}
+		start = boundary
+	}
+}
+
+// planWorker makes the sources of the persistent worker a rule's recipe is
+// sent to prerequisites of the rule, so the worker can start at once.
+func (e *Engine) planWorker(g *buildGraph, node *buildNode, rule *Rule) error {
+	var name string
+	_ = e.inRuleScope(rule, func() error {
+		name, _ = e.vars.Get(".WORKER")
+		return nil
+	})
+	worker, ok := e.makefile.Workers[name]
+	if !ok {
+		return nil
+	}
+	for _, source := range worker.Sources {
+		dep, err := e.plan(g, source)
+		if err != nil {
+			return err
+		}
+		node.addDep(dep)
+	}
+	return nil
+}
+
+// nodeQueue holds the nodes whose deps have all finished, lowest order first.
+type nodeQueue []*buildNode
+
+func (q nodeQueue) Len() int           { return len(q) }
+func (q nodeQueue) Less(i, j int) bool { return q[i].order < q[j].order }
+func (q nodeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)        { *q = append(*q, x.(*buildNode)) }
+func (q *nodeQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// nodeResult reports a finished node to the executor.
+type nodeResult struct {
+	node *buildNode
+	err  error
+}
+
+// jobs returns how many recipes may run at once.
+func (e *Engine) jobs() int {
+	switch {
+	case e.makefile.NotParallel.All && e.opts.Jobs != 1:
+		return 1
+	case e.opts.VerifyIO:
+		// The I/O contract compares the whole workspace before and after a recipe.
+		return 1
+	case e.opts.Jobs == 0:
+		return runtime.NumCPU()
+	case e.opts.Jobs == 1 && e.jobserver != nil && e.jobserver.jobs == 0 && !e.makefile.NotParallel.All:
+		// Under another make without -j of its own, its jobserver sets the limit.
+		return math.MaxInt
+	}
+	return max(e.opts.Jobs, 1)
+}
+
+// execute runs the planned nodes on a pool of jobs. A node is dispatched once
+// all its deps have finished; among the ready ones, the one a sequential build
+// would reach first goes first, so a single job runs them in the same order as
+// a sequential build. A ready node whose `.RESOURCE:` needs do not fit beside
+// the running ones waits, and the next ready node may go first. With a
+// jobserver, every node beyond the first running one waits for a token. While
+// less memory is available than --min-free-memory, no node starts beside the
+// running ones. After a failure no new node starts, the running ones are
+// waited for, and the first error is returned.
+func (e *Engine) execute(g *buildGraph) error {
+	pool, err := e.newResourcePool()
+	if err != nil {
+		return err
+	}
+	ready := &nodeQueue{}
+	for _, node := range g.order {
+		node.pending = len(node.deps)
+		for _, dep := range node.deps {
+			dep.parents = append(dep.parents, node)
+		}
+	}
+	for _, node := range g.order {
+		if node.pending == 0 {
+			heap.Push(ready, node)
+		}
+	}
+
+	jobs := e.jobs()
+	results := make(chan nodeResult)
+	running, finished := 0, 0
+	var firstErr error
+	complete := func(node *buildNode) {
+		finished++
+		e.built[node.name] = true
+		for _, rule := range node.rules {
+			for _, t := range rule.Targets {
+				e.built[t] = true
+			}
+		}
+		for _, parent := range node.parents {
+			if parent.pending--; parent.pending == 0 {
+				heap.Push(ready, parent)
+			}
+		}
+	}
+	for {
+		if firstErr == nil {
+			firstErr = e.interruption()
+		}
+		var waiting []*buildNode
+		var recheck <-chan time.Time
+		for firstErr == nil && running < jobs && ready.Len() > 0 {
+			node := heap.Pop(ready).(*buildNode)
+			if e.built[node.name] {
+				complete(node)
+				continue
+			}
+			needs := e.nodeResources(node)
+			if name := pool.blocker(needs); name != "" {
+				if e.isDebug {
+					fmt.Printf(DebugResourceWait, node.name, name)
+				}
+				waiting = append(waiting, node)
+				continue
+			}
+			if available, low := e.memoryLow(running); low {
+				if e.isDebug {
+					fmt.Printf(DebugMemoryWait, node.name, available/(1<<20))
+				}
+				waiting = append(waiting, node)
+				recheck = time.After(MemoryRecheckInterval)
+				break
+			}
+			if js := e.jobserver; js != nil && !js.claim(running) {
+				js.request()
+				waiting = append(waiting, node)
+				break
+			}
+			pool.acquire(needs)
+			running++
+			go func() {
+				start := time.Now()
+				err := e.runNode(node)
+				e.analysis.record(node, start)
+				results <- nodeResult{node: node, err: err}
+			}()
+		}
+		for _, node := range waiting {
+			heap.Push(ready, node)
+		}
+		var tokens <-chan byte
+		if e.jobserver != nil {
+			e.jobserver.trim(running)
+			tokens = e.jobserver.arrived
+		}
+		if running == 0 {
+			break
+		}
+		var result nodeResult
+		select {
+		case result = <-results:
+		case token := <-tokens:
+			e.jobserver.held = append(e.jobserver.held, token)
+			continue
+		case <-recheck:
+			continue
+		}
+		running--
+		pool.release(e.nodeResources(result.node))
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+				if running > 0 {
+					fmt.Fprintf(os.Stderr, StatusWaitingForJobs, running)
+				}
+			}
+			continue
+		}
+		complete(result.node)
+	}
+	if e.jobserver != nil {
+		e.jobserver.cancel()
+		e.jobserver.trim(0)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if finished < len(g.order) {
+		var stuck []string
+		for _, node := range g.order {
+			if !e.built[node.name] {
+				stuck = append(stuck, node.name)
+			}
+		}
+		return fmt.Errorf("circular dependency detected among targets '%s'", strings.Join(stuck, "', '"))
+	}
+	return nil
+}
+
+// runNode makes one target once its deps have finished: a file that no rule
+// builds must exist, a service is started, and the recipe of every stale rule
+// runs. It may run alongside other nodes.
+func (e *Engine) runNode(node *buildNode) error {
+	if node.rules == nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		path := e.sourcePath(node.name)
+		if info, err := e.stat(path); err == nil && (!info.IsDir() || e.isDirDep(node.name)) {
+			return nil
+		}
+		if _, ok := e.goPackageFiles(path); ok {
+			return nil
+		}
+		return fmt.Errorf("don't know how to make target '%s'", node.name)
+	}
+	rules := node.rules
+
+	if rules[0].IsService {
+		e.mu.Lock()
+		err := e.startService(rules[0])
+		e.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to start service '%s': %w", node.name, err)
+		}
+		return nil
+	}
+
+	// Ordinary targets have exactly one rule; double-colon targets may have
+	// several. Every rule's freshness is decided before any recipe runs, so a
+	// recipe that touches the target does not hide another rule's staleness.
+	needsRun := make([]bool, len(rules))
+	reasons := make([]string, len(rules))
+	e.mu.Lock()
+	for i, rule := range rules {
+		var err error
+		needsRun[i], reasons[i], err = e.checkFreshness(rule)
+		if err != nil {
+			e.mu.Unlock()
+			return err
+		}
+	}
+	e.mu.Unlock()
+	for i, rule := range rules {
+		if !needsRun[i] && e.recipeChanged(rule) {
+			needsRun[i], reasons[i] = true, "its recipe changed"
+		}
+	}
+
+	for i, rule := range rules {
+		if needsRun[i] {
+			node.ran = node.ran || len(rule.Recipe) > 0
+			if e.isDebug {
+				if reasons[i] == "" {
+					fmt.Printf(StatusBuildingTarget, node.name)
+				} else {
+					fmt.Printf(StatusBuildingTargetBecause, node.name, reasons[i])
+				}
+			}
+			before := targetModTimes(rule)
+			digests := e.restatDigests(rule)
+			key, cacheable := e.actionKey(rule)
+			restored := cacheable && e.restoreFromCache(rule, key)
+			var err error
+			if !restored {
+				err = e.runRecipe(rule)
+			}
+			e.invalidateStats(rule.Targets)
+			if err != nil {
+				e.deleteInterruptedTargets(rule, before, err)
+				return fmt.Errorf("recipe for target '%s' failed: %w", node.name, err)
+			}
+			if err := e.checkTargetsProduced(rule, before); err != nil {
+				return err
+			}
+			e.recordRestat(rule, before, digests)
+			e.recordRecipe(rule)
+			if err := e.recordDepfile(rule); err != nil {
+				return err
+			}
+			// The key is taken again, as the depfile may list new dependencies.
+			if key, ok := e.actionKey(rule); ok && !restored {
+				e.storeInCache(rule, key)
+			}
+		} else if e.isDebug {
+			targetList := strings.Join(rule.Targets, "', '")
+			fmt.Printf(StatusTargetsUpToDate, targetList)
+		}
+	}
+	return nil
+}
+
+// inRuleScope runs f with the variable store locked and the rule's namespace
+// and target-specific variables in scope. Under --jobs, recipes of several
+// rules run at once, so every use of the store while they run goes through here.
+func (e *Engine) inRuleScope(rule *Rule, f func() error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.vars.SetNamespace(rule.Namespace)
+	e.vars.SetScope(e.ruleScope(rule))
+	defer func() {
+		e.vars.SetScope(nil, nil)
+		e.vars.SetNamespace("")
+	}()
+	return f()
+}
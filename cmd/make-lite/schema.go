@@ -0,0 +1,105 @@
+// cmd/make-lite/schema.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// VariableInfo describes one variable for `make-lite vars`. make-lite
+// variables are untyped, so Type is always "string".
+type VariableInfo struct {
+	Name        string `json:"name"`
+	Default     string `json:"default"`
+	Value       string `json:"value,omitempty"`
+	Source      string `json:"source"`
+	Origin      string `json:"origin,omitempty"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Secret      bool   `json:"secret"`
+	Exported    bool   `json:"exported"`
+	Type        string `json:"type"`
+}
+
+// variableSchema lists every variable the makefile defines, followed by the
+// ones it requires from the environment. Values loaded from env files are
+// treated as secret and never included, nor are values of required variables,
+// which come straight from the caller's environment.
+func variableSchema(makefile *Makefile, vs *VariableStore) []VariableInfo {
+	order, last := makefile.FinalVariables()
+	infos := make([]VariableInfo, 0, len(order))
+	for _, name := range order {
+		def := last[name]
+		info := VariableInfo{
+			Name:        name,
+			Default:     def.RawValue,
+			Source:      def.Op,
+			Origin:      def.Origin,
+			Description: def.Description,
+			Secret:      def.Op == opLoadEnv,
+			Exported:    vs.IsExported(name),
+			Type:        "string",
+		}
+		if source, ok := vs.Source(name); ok {
+			info.Source = source.String()
+			// A `?=` default overridden from an env file is just as secret.
+			info.Secret = info.Secret || source == sourceEnvFile
+		}
+		if !info.Secret {
+			info.Value, _ = vs.Get(name)
+		}
+		infos = append(infos, info)
+	}
+	for _, name := range makefile.RequiredVariables() {
+		infos = append(infos, VariableInfo{
+			Name:     name,
+			Source:   sourceShellEnv.String(),
+			Required: true,
+			Type:     "string",
+		})
+	}
+	return infos
+}
+
+// runVarsCommand implements `make-lite vars [--output=text|json]`.
+func runVarsCommand(w io.Writer, args []string, makefile *Makefile, vs *VariableStore) error {
+	flags := flag.NewFlagSet("vars", flag.ContinueOnError)
+	output := flags.String("output", "text", "Output format: text or json.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf(ErrorCommandNoArgs, "vars")
+	}
+
+	infos := variableSchema(makefile, vs)
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	case "text":
+		var b strings.Builder
+		for _, info := range infos {
+			value := info.Value
+			switch {
+			case info.Required:
+				value = "(required from environment)"
+			case info.Secret:
+				value = "(secret)"
+			}
+			source := info.Source
+			if info.Exported {
+				source += ", exported"
+			}
+			fmt.Fprintf(&b, "%s = %s [%s]\n", info.Name, value, source)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	default:
+		return fmt.Errorf(ErrorUnknownOutputFormat, *output)
+	}
+}
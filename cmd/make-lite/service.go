@@ -0,0 +1,198 @@
+// cmd/make-lite/service.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceState is the state file that tracks a running service.
+type serviceState struct {
+	PID     int    `json:"pid"`
+	BuildID string `json:"buildId"` // BUILD_ID of the run that started the service
+	Started string `json:"started"` // RFC 3339 start time
+}
+
+// serviceFiles returns the state and log file paths used to track a service.
+func serviceFiles(name string) (stateFile, logFile string) {
+	base := serviceFileBase(name)
+	return base + ".json", base + ".log"
+}
+
+func serviceFileBase(name string) string {
+	return filepath.Join(StateDir, "services", strings.ReplaceAll(name, string(filepath.Separator), "_"))
+}
+
+// runningServicePID returns the PID of a live service. A state file pointing
+// at a dead process is stale and gets removed, as does a corrupt one.
+func runningServicePID(name string) (int, bool) {
+	stateFile, _ := serviceFiles(name)
+	var state serviceState
+	err := readStateFile(stateFile, &state)
+	if os.IsNotExist(err) {
+		state, err = migrateServicePIDFile(name)
+	}
+	if err != nil {
+		return 0, false
+	}
+	if !processAlive(state.PID) {
+		_ = os.Remove(stateFile)
+		return 0, false
+	}
+	return state.PID, true
+}
+
+// migrateServicePIDFile converts the plain `name.pid` file written by earlier
+// versions into a state file.
+func migrateServicePIDFile(name string) (serviceState, error) {
+	pidFile := serviceFileBase(name) + ".pid"
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return serviceState{}, err
+	}
+	_ = os.Remove(pidFile)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return serviceState{}, errStateInvalid
+	}
+	state := serviceState{PID: pid}
+	stateFile, _ := serviceFiles(name)
+	return state, writeStateFile(stateFile, state)
+}
+
+// startService launches a service rule's recipe as a detached background process.
+// The whole recipe runs as a single shell script whose output goes to the service log.
+func (e *Engine) startService(rule *Rule) error {
+	name := rule.Targets[0]
+	if pid, ok := runningServicePID(name); ok {
+		fmt.Printf(StatusServiceRunning, name, pid)
+		e.startedServices = append(e.startedServices, name)
+		return nil
+	}
+	if err := e.checkServicePorts(rule); err != nil {
+		return err
+	}
+
+	e.recipesRun++
+	e.vars.SetNamespace(rule.Namespace)
+	defer e.vars.SetNamespace("")
+	e.vars.SetScope(e.ruleScope(rule))
+	defer e.vars.SetScope(nil, nil)
+
+	var script []string
+	for _, cmdLine := range rule.Recipe {
+		if strings.TrimSpace(cmdLine) == "" {
+			continue
+		}
+		expandedCmd, mods, err := e.expandRecipeLine(rule, cmdLine)
+		if err != nil {
+			return err
+		}
+		if !mods.silent {
+			fmt.Println(expandedCmd)
+		}
+		script = append(script, expandedCmd)
+	}
+
+	stateFile, logFile := serviceFiles(name)
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(stateFile), err)
+	}
+	logOut, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open service log %s: %w", logFile, err)
+	}
+	defer func() { _ = logOut.Close() }()
+	buildID, _ := e.vars.Get(BuildIDVar)
+	if _, err := fmt.Fprintf(logOut, ServiceLogStartFormat, buildID, name, time.Now().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to write service log %s: %w", logFile, err)
+	}
+
+	if e.isDebug {
+		fmt.Fprintf(os.Stderr, DebugExecutingCommand, strings.Join(script, "; "))
+	}
+
+	cmd := exec.Command(e.shellPath, "-c", strings.Join(script, "\n"))
+	cmd.Env = e.vars.getEnvironment()
+	cmd.Stdout = logOut
+	cmd.Stderr = logOut
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	state := serviceState{PID: pid, BuildID: buildID, Started: time.Now().Format(time.RFC3339)}
+	if err := writeStateFile(stateFile, state); err != nil {
+		_ = signalProcessGroup(pid, true)
+		return fmt.Errorf("failed to write service state %s: %w", stateFile, err)
+	}
+
+	// A service that dies right away is almost always misconfigured, so report
+	// it here instead of leaving a stale state file behind.
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case <-exited:
+		_ = os.Remove(stateFile)
+		return fmt.Errorf(ErrorServiceExited, name, logFile)
+	case <-time.After(ServiceStartGrace):
+	}
+
+	fmt.Printf(StatusServiceStarted, name, pid, logFile)
+	e.startedServices = append(e.startedServices, name)
+	return nil
+}
+
+// stopService terminates a running service's process group, escalating to
+// SIGKILL if it does not exit within ServiceStopTimeout.
+func stopService(name string) error {
+	pid, ok := runningServicePID(name)
+	if !ok {
+		fmt.Printf(StatusServiceNotRunning, name)
+		return nil
+	}
+	if err := signalProcessGroup(pid, false); err != nil {
+		return fmt.Errorf("failed to signal service '%s' (pid %d): %w", name, pid, err)
+	}
+	deadline := time.Now().Add(ServiceStopTimeout)
+	for processAlive(pid) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		if err := signalProcessGroup(pid, true); err != nil {
+			return fmt.Errorf("failed to kill service '%s' (pid %d): %w", name, pid, err)
+		}
+	}
+
+	stateFile, _ := serviceFiles(name)
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf(StatusServiceStopped, name)
+	return nil
+}
+
+// printServiceLogs copies a service's log file to stdout.
+func printServiceLogs(name string) (err error) {
+	_, logFile := serviceFiles(name)
+	file, err := os.Open(logFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf(ErrorServiceNoLogs, name)
+		}
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(os.Stdout, file)
+	return err
+}
@@ -0,0 +1,51 @@
+// cmd/make-lite/statcache.go
+package main
+
+import (
+	"os"
+)
+
+// stat returns the file info of a path for freshness checks, with symbolic
+// links handled as `.SYMLINKS` says. Within a build, each existing file is
+// stat'ed once, however many rules depend on it, since in large graphs the
+// same headers and libraries are checked for thousands of targets. A recipe's
+// targets are forgotten once it ran; a file that a recipe changes without
+// declaring it as a target may be seen with its old time until the next build.
+// Missing files are not cached, so one that an earlier recipe created is found.
+func (e *Engine) stat(path string) (os.FileInfo, error) {
+	e.statMu.Lock()
+	info, ok := e.stats[path]
+	e.statMu.Unlock()
+	if ok {
+		return info, nil
+	}
+	info, err := e.statLink(path)
+	if err != nil {
+		return nil, err
+	}
+	e.statMu.Lock()
+	e.stats[path] = info
+	e.statMu.Unlock()
+	return info, nil
+}
+
+// invalidateStats forgets the cached file info of paths a recipe may have
+// written, and the newest files of `.DIRDEPS` directories, which it may have
+// written into.
+func (e *Engine) invalidateStats(paths []string) {
+	e.statMu.Lock()
+	defer e.statMu.Unlock()
+	for _, path := range paths {
+		delete(e.stats, path)
+	}
+	clear(e.trees)
+}
+
+// resetStats empties the cache at the start of a build, as files may have
+// changed since the previous one.
+func (e *Engine) resetStats() {
+	e.statMu.Lock()
+	defer e.statMu.Unlock()
+	e.stats = make(map[string]os.FileInfo)
+	e.trees = make(map[string]dirTree)
+}
@@ -0,0 +1,90 @@
+// cmd/make-lite/state.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateSchemaVersion is the version of the JSON state files under StateDir.
+// Bump it when a state file's fields change incompatibly; files written with
+// another version are discarded and regenerated rather than misread.
+const StateSchemaVersion = 1
+
+// errStateInvalid reports a state file that is corrupt or from another schema version.
+var errStateInvalid = errors.New("state file is corrupt or has an unknown schema version")
+
+// stateHeader is embedded in every state file.
+type stateHeader struct {
+	Version int `json:"version"`
+}
+
+// writeFileAtomic replaces path with data so that readers, and a run that was
+// interrupted part way, only ever see the old or the new content in full.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeStateFile atomically writes state as JSON, stamped with StateSchemaVersion.
+func writeStateFile(path string, state any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	fields, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(fields, &doc); err != nil {
+		return err
+	}
+	doc["version"] = StateSchemaVersion
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// readStateFile loads a state file written by writeStateFile. A missing file
+// is reported with an error satisfying os.IsNotExist; a corrupt file or one
+// from another schema version is removed and reported as errStateInvalid, so
+// the caller regenerates it instead of failing every future run.
+func readStateFile(path string, state any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var header stateHeader
+	if json.Unmarshal(data, &header) != nil || header.Version != StateSchemaVersion || json.Unmarshal(data, state) != nil {
+		_ = os.Remove(path)
+		return errStateInvalid
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// cmd/make-lite/suffix.go
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultSuffixes is GNU Make's built-in `.SUFFIXES` list. A rule such as
+// `.c.o:` is only a suffix rule when both suffixes are on the list.
+var defaultSuffixes = []string{
+	".out", ".a", ".ln", ".o", ".c", ".cc", ".C", ".cpp", ".p", ".f", ".F", ".m", ".r", ".y", ".l",
+	".ym", ".yl", ".s", ".S", ".mod", ".sym", ".def", ".h", ".info", ".dvi", ".tex", ".texinfo",
+	".texi", ".txinfo", ".w", ".ch", ".web", ".sh", ".elc", ".el",
+}
+
+// PatternRule is an implicit rule: any target matching TargetPattern can be
+// built from the file named by SourcePattern, where `%` stands for the same stem.
+// make-lite only creates them from old-style suffix rules.
+type PatternRule struct {
+	TargetPattern string
+	SourcePattern string
+	Recipe        []string
+	Origin        string
+	Namespace     string // Set for suffix rules of a fragment included with `include ... as name`
+	Depfile       string // As in Rule, from `[depfile=...]` after the suffixes
+}
+
+// Match returns the source a target would be built from, and the stem.
+func (r *PatternRule) Match(target string) (string, string, bool) {
+	prefix, suffix, _ := strings.Cut(r.TargetPattern, "%")
+	if len(target) < len(prefix)+len(suffix) || !strings.HasPrefix(target, prefix) || !strings.HasSuffix(target, suffix) {
+		return "", "", false
+	}
+	stem := target[len(prefix) : len(target)-len(suffix)]
+	if stem == "" {
+		return "", "", false
+	}
+	return strings.Replace(r.SourcePattern, "%", stem, 1), stem, true
+}
+
+// suffixRulePatterns translates the target of a suffix rule into patterns:
+// `.c.o` becomes `%.o` built from `%.c`, and the single-suffix `.c` becomes
+// `%` built from `%.c`. Both suffixes must be known.
+func suffixRulePatterns(target string, suffixes []string) (string, string, bool) {
+	for _, from := range suffixes {
+		to, ok := strings.CutPrefix(target, from)
+		if !ok {
+			continue
+		}
+		if to == "" {
+			return "%", "%" + from, true
+		}
+		if containsString(suffixes, to) {
+			return "%" + to, "%" + from, true
+		}
+	}
+	return "", "", false
+}
+
+// inferRule looks for a suffix rule that can build a target no explicit rule
+// builds. As in GNU Make, a rule applies only if its source exists or can be made.
+// The result is an ordinary rule whose recipe sees the automatic variables
+// `$@` (the target), `$<` (the source) and `$*` (the stem).
+func (e *Engine) inferRule(target string) *Rule {
+	for _, pattern := range e.makefile.PatternRules {
+		source, stem, ok := pattern.Match(target)
+		if !ok || source == target {
+			continue
+		}
+		if _, err := os.Stat(e.sourcePath(source)); err != nil && !e.makefile.HasRule(source) {
+			continue
+		}
+		return &Rule{
+			Targets:   []string{target},
+			Sources:   []string{source},
+			Recipe:    pattern.Recipe,
+			Origin:    pattern.Origin,
+			Namespace: pattern.Namespace,
+			Depfile:   pattern.Depfile,
+			Automatic: map[string]string{
+				"@": target,
+				"<": source,
+				"*": stem,
+			},
+		}
+	}
+	return nil
+}
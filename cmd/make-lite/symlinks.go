@@ -0,0 +1,64 @@
+// cmd/make-lite/symlinks.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Policies for the timestamps of symbolic links, chosen with `.SYMLINKS`.
+const (
+	SymlinksFollow   = "follow"   // The file a link points to; a dangling link is missing
+	SymlinksNoFollow = "nofollow" // The link itself, however its target changes
+	SymlinksNewest   = "newest"   // Whichever of the link and its target changed last
+)
+
+// symlinkPolicy reads `.SYMLINKS`, which defaults to following links.
+func symlinkPolicy(vs *VariableStore) (string, error) {
+	value, _ := vs.Get(SymlinksVar)
+	switch policy := strings.TrimSpace(value); policy {
+	case "":
+		return SymlinksFollow, nil
+	case SymlinksFollow, SymlinksNoFollow, SymlinksNewest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf(ErrorInvalidSymlinks, policy)
+	}
+}
+
+// linkInfo is the file info of a link's target with the modification time
+// chosen by the newest policy.
+type linkInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i linkInfo) ModTime() time.Time { return i.modTime }
+
+// statLink stats a target or prerequisite under the symlink policy. A link
+// that points to a directory is a directory under follow and newest, so a
+// symlinked vendored tree is still treated as one.
+func (e *Engine) statLink(path string) (os.FileInfo, error) {
+	switch e.symlinks {
+	case SymlinksNoFollow:
+		return os.Lstat(path)
+	case SymlinksNewest:
+		link, err := os.Lstat(path)
+		if err != nil || link.Mode()&os.ModeSymlink == 0 {
+			return link, err
+		}
+		target, err := os.Stat(path)
+		if err != nil {
+			// A dangling link still exists, with its own time.
+			return link, nil
+		}
+		if link.ModTime().After(target.ModTime()) {
+			return linkInfo{FileInfo: target, modTime: link.ModTime()}, nil
+		}
+		return target, nil
+	default:
+		return os.Stat(path)
+	}
+}
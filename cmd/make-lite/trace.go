@@ -0,0 +1,90 @@
+// cmd/make-lite/trace.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// TraceVars turns on tracing for the named variables: every assignment, with
+// the precedence decision it led to, and every reference that expands them is
+// logged to stderr. A value the variable already has from the environment is
+// logged at once, since it was read before any makefile line.
+func (vs *VariableStore) TraceVars(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	vs.traced = make(map[string]bool)
+	for _, name := range names {
+		vs.traced[name] = true
+		if entry, ok := vs.vars[name]; ok {
+			fmt.Fprintf(os.Stderr, TraceVarInitial, name, entry.source, entry.value)
+		}
+	}
+}
+
+// traceAssignment logs an assignment to a traced variable once Set or Append
+// has decided whether it takes effect. existing and exists describe the
+// variable before the assignment, and appended marks a `+=`.
+func (vs *VariableStore) traceAssignment(key, value string, source varSource, originFile string, originLine int, existing varEntry, exists, appended bool) {
+	previous := TraceVarUndefined
+	if exists {
+		previous = fmt.Sprintf(TraceVarPrevious, existing.value, existing.source)
+	}
+	entry := vs.vars[key]
+	applied := entry.source == source && entry.originFile == originFile && entry.originLine == originLine
+
+	var decision string
+	switch {
+	case applied && appended && exists:
+		decision = TraceDecisionAppended
+	case applied && !exists:
+		decision = TraceDecisionApplied
+	case applied:
+		decision = fmt.Sprintf(TraceDecisionOverride, existing.source)
+	case source == sourceMakefileConditional:
+		decision = TraceDecisionConditional
+	default:
+		decision = fmt.Sprintf(TraceDecisionPrecedence, existing.source)
+	}
+	fmt.Fprintf(os.Stderr, TraceVarAssign, key, source, traceLocation(originFile, originLine), value, previous, decision)
+}
+
+// traceScope logs the rule-specific values of traced variables as a rule's
+// recipe, or its secondary expansion, starts using them.
+func (vs *VariableStore) traceScope(scope map[string]string) {
+	for name, value := range scope {
+		if vs.traced[name] {
+			fmt.Fprintf(os.Stderr, TraceVarScope, name, value)
+		}
+	}
+}
+
+// traceExpansion logs a reference to a traced variable and where the value
+// it expanded to came from.
+func (vs *VariableStore) traceExpansion(name, value string, ok bool) {
+	if !ok {
+		fmt.Fprintf(os.Stderr, TraceVarExpandUndefined, name)
+		return
+	}
+	var origin string
+	if _, local := vs.locals[name]; local {
+		origin = TraceOriginLocal
+	} else if _, scoped := vs.scope[name]; scoped {
+		origin = TraceOriginRule
+	} else {
+		entry := vs.vars[vs.resolve(name)]
+		origin = fmt.Sprintf("%s at %s", entry.source, traceLocation(entry.originFile, entry.originLine))
+	}
+	fmt.Fprintf(os.Stderr, TraceVarExpand, name, value, origin)
+}
+
+// traceLocation formats where an assignment was made; origins without a line,
+// such as the shell environment, are shown by name only.
+func traceLocation(file string, line int) string {
+	if line <= 0 {
+		return file
+	}
+	return file + ":" + strconv.Itoa(line)
+}
@@ -3,15 +3,39 @@ package main
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 )
 
 // Rule represents a single rule in the makefile.
 // It consists of targets, sources, and a recipe.
 type Rule struct {
-	Targets []string
-	Sources []string
-	Recipe  []string
-	Origin  string // For error reporting: "line 10"
+	Targets     []string
+	Sources     []string
+	Recipe      []string
+	Origin      string // For error reporting: "line 10"
+	IsService   bool   // Declared with the `service` keyword; the recipe is a long-running process
+	DoubleColon bool   // Declared with `::`; each such rule for a target is checked and run independently
+	Ports       []int  // TCP ports a service listens on, checked for conflicts before it starts
+	Description string // Full-line comments directly above the rule definition
+	Waits       []int  // Source indexes where a `.WAIT` stood; sources before it finish before any after it start
+	Group       string // Heading the rule is listed under, from a `## @group` annotation
+	Namespace   string // Set for rules of a fragment included with `include ... as name`
+	Depfile     string // Dependency file the recipe writes, from `[depfile=...]`; `%` stands for the target without its suffix
+
+	// SecondarySources holds prerequisites that still contain `$` after the first
+	// expansion under `.SECONDEXPANSION:`. They are expanded again for each
+	// target when it is built, with `$@` set to the target, and replace Sources.
+	SecondarySources string
+
+	// Automatic holds the automatic variables (`@`, `<`, `*`) of a rule
+	// inferred from a suffix rule, which its recipe can reference, and the
+	// temporary `$@` of an `.ATOMIC` rule while its recipe runs.
+	Automatic map[string]string
+
+	// Sandbox is the directory a recipe runs in under --sandbox. It is set
+	// only on the copy of the rule that runs there, and is otherwise "".
+	Sandbox string
 }
 
 // String provides a simple string representation for a Rule, useful for debugging.
@@ -22,24 +46,220 @@ func (r *Rule) String() string {
 // Makefile represents the entire parsed makefile.
 // It holds all the rules and initial variable assignments.
 type Makefile struct {
-	Rules   []*Rule
-	RuleMap map[string]*Rule // Fast lookup of a rule by its target name
+	Rules          []*Rule
+	RuleMap        map[string][]*Rule     // Fast lookup of the rules for a target name
+	Variables      []*VariableDef         // Every assignment and env file key, in definition order
+	References     []string               // Variable names referenced in the makefile, in first-use order
+	ReferenceSites []VariableRef          // Every variable reference with its location, in file order
+	TargetVars     map[string][]TargetVar // Target-specific assignments, in definition order
+	PatternVars    []PatternVar           // Pattern-specific assignments such as `%.o: CFLAGS += -fPIC`, in definition order
+	Workers        map[string]*Rule       // Persistent workers declared with `worker name:`
+	OneShell       bool                   // Set by `.ONESHELL:`; each recipe runs in a single shell
+	Profiles       map[string]*Profile    // Configuration variants declared with `profile name: VAR=value ...`
+	Silent         TargetSet              // Targets listed by `.SILENT:`, whose commands are not echoed
+	Ignore         TargetSet              // Targets listed by `.IGNORE:`, whose command failures are ignored
+	Atomic         TargetSet              // `.ATOMIC:` targets, which the recipe writes through a temporary `$@` renamed on success
+	Restat         TargetSet              // `.RESTAT:` targets, whose dependents are not rebuilt when a recipe rewrites them unchanged
+	DirDeps        TargetSet              // `.DIRDEPS:` directory prerequisites, compared by the newest file in their tree
+	Cache          TargetSet              // `.CACHE:` targets, restored from the output cache when the same action ran before
+	Precious       TargetSet              // `.PRECIOUS:` targets, kept when an interrupted recipe leaves them half written
+	Retries        map[string]RetryPolicy // Retries of flaky recipes, by target, from `.RETRY:`
+	Containers     map[string]string      // Image each target's recipe runs in, from `.CONTAINER:`
+
+	// Resources holds the amounts of named resources, such as memory or a
+	// port, that each target's recipe uses, from `.RESOURCE:`.
+	Resources    map[string]map[string]float64
+	NotParallel  TargetSet         // `.NOTPARALLEL:`; with targets, their prerequisites are built one at a time
+	VPaths       []VPath           // `vpath pattern dirs` directives, in definition order
+	Aliases      map[string]string // Short names declared with `alias name = target`, mapped to their target
+	GroupTitles  map[string]string // Headings of target groups, by group name, when one was given
+	PatternRules []*PatternRule    // Implicit rules translated from suffix rules such as `.c.o:`
+	Strict       bool              // Set by `.STRICT:` or --strict: undefined variables, duplicate targets, missing prerequisites and missing outputs are errors
+	Defaults     map[string]bool   // Variables preloaded by `.DEFAULTS:` or --builtins
+}
+
+// VPath is a `vpath pattern dirs` directive: sources matching the pattern
+// that are not found in the working directory are searched for in Dirs.
+type VPath struct {
+	Pattern string // May contain one `%` wildcard
+	Dirs    []string
+}
+
+// Matches reports whether a source name matches the directive's pattern.
+func (v VPath) Matches(name string) bool {
+	prefix, suffix, ok := strings.Cut(v.Pattern, "%")
+	if !ok {
+		return v.Pattern == name
+	}
+	return len(name) >= len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+// Profile is a named configuration variant, such as debug or release, selected with --profile.
+type Profile struct {
+	Name   string
+	Vars   map[string]string // Expanded values, which override the makefile's when the profile is selected
+	Origin string
+}
+
+// TargetSet is the target list of a special target such as .SILENT. An empty
+// list applies to every target.
+type TargetSet struct {
+	All     bool
+	Targets map[string]bool
+}
+
+// add records a special target's sources.
+func (s *TargetSet) add(targets []string) {
+	if len(targets) == 0 {
+		s.All = true
+		return
+	}
+	if s.Targets == nil {
+		s.Targets = make(map[string]bool)
+	}
+	for _, t := range targets {
+		s.Targets[t] = true
+	}
+}
+
+// Covers reports whether the set applies to any of a rule's targets.
+func (s TargetSet) Covers(rule *Rule) bool {
+	if s.All {
+		return true
+	}
+	for _, t := range rule.Targets {
+		if s.Targets[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetVar is a variable assignment that only applies while a target's recipe runs.
+type TargetVar struct {
+	Name    string
+	Value   string
+	Op      string // "=", "?=" or "+="
+	Origin  string // "file:line"
+	Private bool   // Assigned with `private`: kept out of the recipe environment
+}
+
+// PatternVar is a target-specific assignment for every target matching a
+// pattern with one `%`.
+type PatternVar struct {
+	Pattern string
+	TargetVar
+}
+
+// opLoadEnv marks a VariableDef that came from a `load_env` file rather than an assignment.
+const opLoadEnv = "load_env"
+
+// opLoadConfig marks a VariableDef that came from a `load_config` file.
+const opLoadConfig = "load_config"
+
+// VariableDef records where and how a variable was defined, for documentation and introspection.
+type VariableDef struct {
+	Name        string
+	RawValue    string // Unexpanded right-hand side; empty for env file keys, whose values may be secret
+	Op          string // "=", "?=" or opLoadEnv
+	Origin      string // "file:line"
+	Description string // Full-line comments directly above the assignment
+}
+
+// VariableRef records one place where a variable is referenced.
+type VariableRef struct {
+	Name   string
+	Origin string // "file:line"
+	Text   string // The referencing line, trimmed
 }
 
 // NewMakefile creates an initialized Makefile.
 func NewMakefile() *Makefile {
 	return &Makefile{
-		Rules:   []*Rule{},
-		RuleMap: make(map[string]*Rule),
+		Rules:      []*Rule{},
+		RuleMap:    make(map[string][]*Rule),
+		TargetVars: make(map[string][]TargetVar),
+		Workers:    make(map[string]*Rule),
+		Profiles:   make(map[string]*Profile),
+		Aliases:    make(map[string]string),
 	}
 }
 
 // AddRule adds a rule to the Makefile and registers all its targets in the RuleMap.
-func (m *Makefile) AddRule(rule *Rule) {
+// A target may have several double-colon rules, but may not mix them with ordinary ones.
+func (m *Makefile) AddRule(rule *Rule) error {
+	for _, target := range rule.Targets {
+		if existing := m.RuleMap[target]; len(existing) > 0 && existing[0].DoubleColon != rule.DoubleColon {
+			return fmt.Errorf("target '%s' has both ':' and '::' rules (previous rule at %s)", target, existing[0].Origin)
+		} else if len(existing) > 0 && !rule.DoubleColon && m.Strict {
+			return fmt.Errorf(ErrorStrictDuplicateTarget, target, existing[0].Origin)
+		}
+	}
 	m.Rules = append(m.Rules, rule)
 	for _, target := range rule.Targets {
+		if rule.DoubleColon {
+			m.RuleMap[target] = append(m.RuleMap[target], rule)
+			continue
+		}
 		// Map every target to this rule. If a target is defined in multiple
 		// rules, the last one wins, which is standard Make behavior.
-		m.RuleMap[target] = rule
+		m.RuleMap[target] = []*Rule{rule}
+	}
+	return nil
+}
+
+// AddPatternRule adds an implicit rule. A later rule for the same patterns
+// replaces the earlier one.
+func (m *Makefile) AddPatternRule(rule *PatternRule) {
+	for i, existing := range m.PatternRules {
+		if existing.TargetPattern == rule.TargetPattern && existing.SourcePattern == rule.SourcePattern {
+			m.PatternRules[i] = rule
+			return
+		}
+	}
+	m.PatternRules = append(m.PatternRules, rule)
+}
+
+// HasRule reports whether any rule can build the given target or alias.
+func (m *Makefile) HasRule(target string) bool {
+	return len(m.RuleMap[m.Resolve(target)]) > 0
+}
+
+// Resolve returns the target an alias stands for, or the name itself.
+func (m *Makefile) Resolve(name string) string {
+	if target, ok := m.Aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// envVarName matches the conventional spelling of environment variables. It
+// keeps implicit shell calls like $(pwd) out of the required variables list.
+var envVarName = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// FinalVariables returns each defined variable name once, in order of first
+// definition, together with its last (winning) definition.
+func (m *Makefile) FinalVariables() ([]string, map[string]*VariableDef) {
+	var order []string
+	last := make(map[string]*VariableDef)
+	for _, def := range m.Variables {
+		if _, seen := last[def.Name]; !seen {
+			order = append(order, def.Name)
+		}
+		last[def.Name] = def
+	}
+	return order, last
+}
+
+// RequiredVariables returns the environment-style variables that the makefile
+// references but never defines, so they must come from the environment.
+func (m *Makefile) RequiredVariables() []string {
+	_, defined := m.FinalVariables()
+	var required []string
+	for _, name := range m.References {
+		if _, ok := defined[name]; !ok && envVarName.MatchString(name) && !builtinVariables[name] && !m.Defaults[name] {
+			required = append(required, name)
+		}
 	}
+	return required
 }
@@ -0,0 +1,143 @@
+// cmd/make-lite/up.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upPollInterval is how often `up` checks service logs and liveness.
+const upPollInterval = 200 * time.Millisecond
+
+// logFollower streams the lines appended to a service log since a given offset.
+type logFollower struct {
+	name    string
+	path    string
+	offset  int64
+	partial string
+}
+
+// poll prints any complete lines written since the last call, prefixed with the service name.
+func (f *logFollower) poll(width int) (err error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	f.offset += int64(len(data))
+
+	lines := strings.Split(f.partial+string(data), "\n")
+	f.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		fmt.Printf(ServiceLogLineFormat, width, f.name, line)
+	}
+	return nil
+}
+
+// Up starts the named services (every declared service when none are named)
+// together with their dependencies, streams their combined logs, and stops
+// them in reverse start order on Ctrl-C or SIGTERM.
+func (e *Engine) Up(names []string) error {
+	if len(names) == 0 {
+		for _, rule := range e.makefile.Rules {
+			if rule.IsService {
+				names = append(names, rule.Targets[0])
+			}
+		}
+	}
+	if len(names) == 0 {
+		return errors.New(ErrorNoServices)
+	}
+
+	// Only output produced from now on is streamed, not the whole log history.
+	offsets := make(map[string]int64)
+	for _, rule := range e.makefile.Rules {
+		if rule.IsService {
+			_, logFile := serviceFiles(rule.Targets[0])
+			if info, err := os.Stat(logFile); err == nil {
+				offsets[rule.Targets[0]] = info.Size()
+			}
+		}
+	}
+
+	// Building a service starts the services it depends on first, so
+	// startedServices ends up in dependency order.
+	for _, name := range names {
+		if err := e.buildGoal(name); err != nil {
+			if stopErr := e.stopStartedServices(); stopErr != nil {
+				fmt.Fprintf(os.Stderr, ErrorCommandFailed, stopErr)
+			}
+			return err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	width := 0
+	followers := make([]*logFollower, 0, len(e.startedServices))
+	for _, name := range e.startedServices {
+		_, logFile := serviceFiles(name)
+		followers = append(followers, &logFollower{name: name, path: logFile, offset: offsets[name]})
+		width = max(width, len(name))
+	}
+	fmt.Printf(StatusUpStreaming, strings.Join(e.startedServices, ", "))
+
+	ticker := time.NewTicker(upPollInterval)
+	defer ticker.Stop()
+	exited := make(map[string]bool)
+	for {
+		select {
+		case <-signals:
+			fmt.Print(StatusUpStopping)
+			return e.stopStartedServices()
+		case <-ticker.C:
+		}
+		for _, f := range followers {
+			if err := f.poll(width); err != nil {
+				return err
+			}
+		}
+		for _, name := range e.startedServices {
+			if _, running := runningServicePID(name); !running && !exited[name] {
+				exited[name] = true
+				fmt.Printf(StatusServiceExited, name)
+			}
+		}
+		if len(exited) == len(e.startedServices) {
+			return nil
+		}
+	}
+}
+
+// stopStartedServices stops every service started in this run, most recently started first.
+func (e *Engine) stopStartedServices() error {
+	var firstErr error
+	for i := len(e.startedServices) - 1; i >= 0; i-- {
+		if err := stopService(e.startedServices[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -2,6 +2,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
 	"strings"
 )
 
@@ -19,32 +24,177 @@ func trimQuotes(s string) string {
 	return s
 }
 
-// cleanEnvLine processes a line from a .env file.
-// It trims whitespace, ignores comments and blank lines, and splits into key/value.
-// It returns the key, value, and a boolean indicating if the line was valid.
-func cleanEnvLine(line string) (string, string, bool) {
-	line = strings.TrimSpace(line)
-	if line == "" || strings.HasPrefix(line, "#") {
-		return "", "", false
+// envEntry is one KEY=value pair read from a .env file.
+type envEntry struct {
+	key   string
+	value string
+	line  int // Line where the entry starts
+}
+
+// parseEnvFile parses a .env file in the common dotenv dialect. Blank lines
+// and lines starting with `#` are skipped, and anything before the last token
+// ahead of `=` (such as `export`) is ignored. Values may be:
+//   - unquoted: surrounding whitespace and a trailing ` # comment` are removed;
+//   - single-quoted: taken literally, and may span several lines;
+//   - double-quoted: may span several lines, and `\"`, `\\` and `\n` are unescaped.
+//
+// A `#` inside quotes is part of the value. Lines without `=` are ignored.
+func parseEnvFile(content string) ([]envEntry, error) {
+	lines := strings.Split(content, "\n")
+	var entries []envEntry
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		left, right, found := strings.Cut(line, "=")
+		keyTokens := strings.Fields(left)
+		if !found || len(keyTokens) == 0 {
+			continue // Invalid line format
+		}
+		entry := envEntry{key: keyTokens[len(keyTokens)-1], line: i + 1}
+
+		right = strings.TrimLeft(right, " \t")
+		if right == "" || (right[0] != '"' && right[0] != '\'') {
+			entry.value = stripEnvComment(right)
+			entries = append(entries, entry)
+			continue
+		}
+
+		// A quoted value continues over the following lines until its closing quote.
+		quote := right[0]
+		text := right[1:]
+		for {
+			if value, ok := closeEnvQuote(text, quote); ok {
+				entry.value = value
+				break
+			}
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("unterminated quoted value for '%s' starting at line %d", entry.key, entry.line)
+			}
+			text += "\n" + strings.TrimSuffix(lines[i], "\r")
+		}
+		entries = append(entries, entry)
 	}
+	return entries, nil
+}
 
-	parts := strings.SplitN(line, "=", 2)
-	if len(parts) != 2 {
-		return "", "", false // Invalid line format
+// closeEnvQuote looks for the closing quote of a quoted .env value and returns
+// the value between the quotes, unescaped. Text after the closing quote is ignored.
+func closeEnvQuote(text string, quote byte) (string, bool) {
+	var value strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == quote {
+			return value.String(), true
+		}
+		if c == '\\' && quote == '"' && i+1 < len(text) {
+			switch text[i+1] {
+			case '"', '\\':
+				value.WriteByte(text[i+1])
+				i++
+				continue
+			case 'n':
+				value.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+		value.WriteByte(c)
 	}
+	return "", false
+}
 
-	// Per spec, "Anything preceding last token before assignment operator... is ignored."
-	keyPart := strings.TrimSpace(parts[0])
-	keyTokens := strings.Fields(keyPart)
-	if len(keyTokens) == 0 {
-		return "", "", false // Empty key
+// stripEnvComment removes a ` # comment` from an unquoted .env value, then
+// surrounding whitespace.
+func stripEnvComment(value string) string {
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = value[:i]
+			break
+		}
 	}
-	key := keyTokens[len(keyTokens)-1]
+	return strings.TrimSpace(value)
+}
 
-	val := strings.TrimSpace(parts[1])
+// splitArgs splits a command line into arguments the way a POSIX shell would
+// for simple words: whitespace separates arguments, single and double quotes
+// group them, and a backslash outside single quotes escapes the next character.
+func splitArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
 
-	// Per spec, for .env files, strip surrounding quotes from the value.
-	val = trimQuotes(val)
+// isCI reports whether make-lite runs in continuous integration, as most CI
+// systems announce by setting CI (to `true`, `1` or a system name).
+func isCI() bool {
+	value := strings.ToLower(os.Getenv("CI"))
+	return value != "" && value != "0" && value != "false"
+}
 
-	return key, val, true
+// envKey returns the name that identifies a variable in an environment. Windows
+// treats variable names case-insensitively, so there `Path` and `PATH` are one.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// platformVariables describes the machine make-lite runs on. HOSTNAME is left
+// out if the host name cannot be determined.
+func platformVariables() map[string]string {
+	vars := map[string]string{
+		OSVar:   runtime.GOOS,
+		ArchVar: runtime.GOARCH,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		vars[HostnameVar] = hostname
+	}
+	return vars
+}
+
+// newBuildID returns a random identifier for one make-lite invocation.
+func newBuildID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(b)
 }
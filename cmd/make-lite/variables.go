@@ -3,10 +3,13 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,26 +20,73 @@ const (
 	sourceEnvFile
 	sourceShellEnv
 	sourceMakefileUnconditional
+	sourceProfile
+	sourceBuiltin // Read-only variables provided by make-lite, such as CURDIR
 )
 
+// String names a variable source for introspection output.
+func (s varSource) String() string {
+	switch s {
+	case sourceMakefileConditional:
+		return "makefile-default"
+	case sourceEnvFile:
+		return "env-file"
+	case sourceShellEnv:
+		return "environment"
+	case sourceProfile:
+		return "profile"
+	case sourceBuiltin:
+		return "built-in"
+	default:
+		return "makefile"
+	}
+}
+
 type varEntry struct {
 	value      string
 	source     varSource
 	originFile string
 	originLine int
+	macro      bool // Defined by a define block: value is unexpanded and expanded where it is used
 }
 
 type VariableStore struct {
 	vars              map[string]varEntry
+	scope             map[string]string       // Target-specific values in effect while a recipe runs
+	locals            map[string]string       // Loop variables of $(foreach ...) and arguments of $(call ...) while they expand
+	expanding         []string                // `define` blocks being expanded, outermost first, to name the chain of a circular reference
+	exported          map[string]bool         // Variables marked with `export`
+	unexported        map[string]bool         // Variables marked with `unexport` or assigned with `private`, kept out of the environment
+	constants         map[string]string       // Variables declared with `const` or `.READONLY:`, and where they were declared
+	namespace         string                  // Namespace of the fragment or rule being expanded, whose variables shadow global ones
+	namespaces        map[string]bool         // Every namespace given with `include ... as name`
+	scopePrivate      map[string]bool         // Target-specific values assigned with `private`
+	envAllow          []string                // `.ENV_ALLOW:` patterns; if any, other inherited variables are withheld
+	envDeny           []string                // `.ENV_DENY:` patterns of inherited variables to withhold
+	exportAll         bool                    // Set by `.EXPORT_ALL_VARIABLES:` or a bare `export`
+	parsing           bool                    // Set while the makefile is parsed; env file values are withheld from $(shell ...)
+	probing           bool                    // Set while a recipe is expanded only to compare it; side effects fail with errProbeSideEffect
+	strict            bool                    // Referencing an undefined variable is an error
+	legacyDollar      bool                    // An undefined `$name` expands to nothing instead of being kept for the shell
+	shellFallback     string                  // "on", "off" or "auto": whether `$(command args)` may run in the shell
+	traced            map[string]bool         // Variables named with --trace-var
+	evaluate          func(text string) error // Reads $(eval ...) text as makefile lines; set only during the first parsing pass
 	isDebug           bool
 	isExpandingForEnv bool // Flag to prevent shell recursion
 	cachedEnv         []string
+	vcsDir            string // Where to look for the repository when a VCS_* variable is first used
+	vcsLoaded         bool
 }
 
 func NewVariableStore(isDebug bool) *VariableStore {
 	vs := &VariableStore{
-		vars:    make(map[string]varEntry),
-		isDebug: isDebug,
+		vars:       make(map[string]varEntry),
+		locals:     make(map[string]string),
+		exported:   make(map[string]bool),
+		unexported: make(map[string]bool),
+		constants:  make(map[string]string),
+		namespaces: make(map[string]bool),
+		isDebug:    isDebug,
 	}
 	for _, envPair := range os.Environ() {
 		parts := strings.SplitN(envPair, "=", 2)
@@ -44,12 +94,20 @@ func NewVariableStore(isDebug bool) *VariableStore {
 			vs.vars[parts[0]] = varEntry{value: parts[1], source: sourceShellEnv, originFile: "shell environment", originLine: 0}
 		}
 	}
+	vs.vcsDir, _ = os.Getwd()
 	return vs
 }
 
 func (vs *VariableStore) Set(key, value string, source varSource, originFile string, originLine int) {
+	if _, ok := vs.constants[key]; ok {
+		return
+	}
+	vs.loadVCS(key)
 	vs.cachedEnv = nil // Invalidate env cache on any variable change.
 	existing, exists := vs.vars[key]
+	if vs.traced[key] {
+		defer vs.traceAssignment(key, value, source, originFile, originLine, existing, exists, false)
+	}
 
 	if source == sourceMakefileConditional {
 		if !exists {
@@ -68,18 +126,380 @@ func (vs *VariableStore) Set(key, value string, source varSource, originFile str
 	}
 }
 
-func (vs *VariableStore) Get(key string) (string, bool) {
+// Append implements `VAR += value`: value is added to the variable's current
+// value, after a space, and the result is a makefile assignment. Unlike a
+// second `=`, it is not reported as a redefinition. Profile and built-in
+// values cannot be appended to, as they cannot be reassigned.
+func (vs *VariableStore) Append(key, value string, originFile string, originLine int) {
+	if _, ok := vs.constants[key]; ok {
+		return
+	}
+	vs.loadVCS(key)
+	existing, exists := vs.vars[key]
+	if vs.traced[key] {
+		defer vs.traceAssignment(key, value, sourceMakefileUnconditional, originFile, originLine, existing, exists, true)
+	}
+	if exists && existing.source > sourceMakefileUnconditional {
+		return
+	}
+	if exists && existing.value != "" {
+		value = existing.value + " " + value
+	}
+	vs.cachedEnv = nil
+	vs.vars[key] = varEntry{value: value, source: sourceMakefileUnconditional, originFile: originFile, originLine: originLine}
+}
+
+// SetMacro defines a variable from a `define` block. Its body is stored as
+// written and expanded each time the variable is used, so it can refer to the
+// $(1), $(2), ... arguments of $(call ...).
+func (vs *VariableStore) SetMacro(key, body string, originFile string, originLine int) {
+	vs.Set(key, body, sourceMakefileUnconditional, originFile, originLine)
+	if entry := vs.vars[key]; entry.originFile == originFile && entry.originLine == originLine {
+		entry.macro = true
+		vs.vars[key] = entry
+	}
+}
+
+// SetBuiltin defines a read-only variable provided by make-lite. No assignment,
+// env file or environment variable can change it afterwards.
+func (vs *VariableStore) SetBuiltin(key, value string) {
+	vs.cachedEnv = nil
+	if vs.traced[key] {
+		existing, exists := vs.vars[key]
+		defer vs.traceAssignment(key, value, sourceBuiltin, "built-in", 0, existing, exists, false)
+	}
+	vs.vars[key] = varEntry{value: value, source: sourceBuiltin, originFile: "built-in"}
+}
+
+// IsReadOnly reports whether a variable was defined with SetBuiltin.
+func (vs *VariableStore) IsReadOnly(key string) bool {
 	entry, ok := vs.vars[key]
+	return ok && entry.source == sourceBuiltin
+}
+
+// SetConstant implements `const VAR = value`. The value replaces any other,
+// including one from the environment or a profile, and nothing can change it
+// afterwards: makefile assignments are errors and other sources are ignored.
+func (vs *VariableStore) SetConstant(key, value string, originFile string, originLine int) {
+	vs.cachedEnv = nil
+	if vs.traced[key] {
+		existing, exists := vs.vars[key]
+		defer vs.traceAssignment(key, value, sourceMakefileUnconditional, originFile, originLine, existing, exists, false)
+	}
+	vs.vars[key] = varEntry{value: value, source: sourceMakefileUnconditional, originFile: originFile, originLine: originLine}
+	vs.constants[key] = traceLocation(originFile, originLine)
+}
+
+// Freeze implements `.READONLY: VAR`: the variable keeps its current value, as
+// if it had been declared with `const` at originFile:originLine.
+func (vs *VariableStore) Freeze(key string, originFile string, originLine int) bool {
+	if _, ok := vs.vars[key]; !ok {
+		return false
+	}
+	if _, ok := vs.constants[key]; !ok {
+		vs.constants[key] = traceLocation(originFile, originLine)
+	}
+	return true
+}
+
+// ConstantOrigin reports where a variable was made constant, if it was.
+func (vs *VariableStore) ConstantOrigin(key string) (string, bool) {
+	origin, ok := vs.constants[key]
+	return origin, ok
+}
+
+// Export marks a variable for the environment of recipes and shell commands.
+func (vs *VariableStore) Export(key string) {
+	vs.cachedEnv = nil
+	delete(vs.unexported, key)
+	vs.exported[key] = true
+}
+
+// Unexport keeps a variable out of the environment of recipes and shell
+// commands, even one inherited from the environment or loaded from an env file,
+// or under `.EXPORT_ALL_VARIABLES`, until it is exported again by name.
+func (vs *VariableStore) Unexport(key string) {
+	vs.cachedEnv = nil
+	delete(vs.exported, key)
+	vs.unexported[key] = true
+}
+
+// ExportAll exports every variable, as make-lite did before `export` existed.
+func (vs *VariableStore) ExportAll() {
+	vs.cachedEnv = nil
+	vs.exportAll = true
+}
+
+// isExported reports whether a variable belongs in the recipe environment.
+// Besides exported variables, this covers env file values and variables that
+// override one already in the environment, which keep their place there.
+func (vs *VariableStore) isExported(key string, source varSource, inEnv bool) bool {
+	if isSpecialVariable(key) || vs.unexported[key] {
+		return false
+	}
+	return vs.exportAll || vs.exported[key] || source == sourceEnvFile || inEnv
+}
+
+// AllowEnv restricts the variables inherited from the calling shell that are
+// passed on to recipes and shell commands to those matching one of the
+// patterns given so far, in which `%` matches any part of a name.
+func (vs *VariableStore) AllowEnv(patterns []string) {
+	vs.cachedEnv = nil
+	vs.envAllow = append(vs.envAllow, patterns...)
+}
+
+// DenyEnv withholds the inherited variables matching any of the patterns,
+// even ones that `.ENV_ALLOW:` allows.
+func (vs *VariableStore) DenyEnv(patterns []string) {
+	vs.cachedEnv = nil
+	vs.envDeny = append(vs.envDeny, patterns...)
+}
+
+// inheritsEnv reports whether a variable from make-lite's own environment is
+// passed on to subprocesses. The variables make-lite itself puts there, such
+// as BUILD_ID and SRCDIR, always are.
+func (vs *VariableStore) inheritsEnv(name string) bool {
+	if builtinVariables[name] || name == SourceDirVar {
+		return true
+	}
+	for _, pattern := range vs.envDeny {
+		if _, ok := matchWordPattern(pattern, name); ok {
+			return false
+		}
+	}
+	if len(vs.envAllow) == 0 {
+		return true
+	}
+	for _, pattern := range vs.envAllow {
+		if _, ok := matchWordPattern(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExported reports whether the global value of a variable is passed to
+// recipes, for `make-lite vars`.
+func (vs *VariableStore) IsExported(key string) bool {
+	entry, ok := vs.vars[key]
+	if !ok {
+		return false
+	}
+	_, inEnv := os.LookupEnv(key)
+	inEnv = inEnv && vs.inheritsEnv(key)
+	if entry.source == sourceShellEnv {
+		return inEnv && vs.isExported(key, entry.source, true)
+	}
+	return vs.isExported(key, entry.source, inEnv)
+}
+
+// SetParsing marks the start and end of parsing. While parsing, `$(shell ...)`
+// commands embedded in the makefile do not see values loaded from env files,
+// which often hold credentials, unless a value is exported by name.
+func (vs *VariableStore) SetParsing(parsing bool) {
+	vs.cachedEnv = nil
+	vs.parsing = parsing
+}
+
+// SetEvaluator installs the function that $(eval ...) passes its text to, or
+// removes it with nil once variables and rules can no longer be added.
+func (vs *VariableStore) SetEvaluator(evaluate func(text string) error) {
+	vs.evaluate = evaluate
+}
+
+// SetShellFallback sets whether an unknown expression containing blanks, such
+// as `$(date +%Y)`, runs as a shell command: "on", "off", or "auto", which
+// turns the fallback off in strict mode and in CI.
+func (vs *VariableStore) SetShellFallback(mode string) error {
+	switch mode {
+	case "auto", "on", "off":
+		vs.shellFallback = mode
+		return nil
+	}
+	return fmt.Errorf(ErrorUnknownShellFallback, mode)
+}
+
+// allowShellFallback reports whether an unknown `$(command args)` may run in the shell.
+func (vs *VariableStore) allowShellFallback() bool {
+	switch vs.shellFallback {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return !vs.strict && !isCI()
+}
+
+// SetLegacyDollar restores the expansion of an undefined `$name` to nothing,
+// as before `$name` was kept as written for the shell.
+func (vs *VariableStore) SetLegacyDollar(legacy bool) {
+	vs.legacyDollar = legacy
+}
+
+// SetStrict makes references to undefined variables an error. Only names
+// spelled like environment variables are checked, so implicit shell calls such
+// as $(pwd) and shell positional parameters keep working.
+func (vs *VariableStore) SetStrict(strict bool) {
+	vs.strict = strict
+}
+
+// SetScope installs the target-specific variables that override global ones
+// until the scope is replaced or cleared with nil. Names in private were
+// assigned with `private` and are kept out of the recipe environment.
+func (vs *VariableStore) SetScope(scope map[string]string, private map[string]bool) {
+	vs.cachedEnv = nil
+	vs.scope = scope
+	vs.scopePrivate = private
+	if vs.traced != nil {
+		vs.traceScope(scope)
+	}
+}
+
+// SetNamespace makes the variables of a namespaced fragment visible by their
+// short names, while the fragment is read or one of its rules runs. An empty
+// namespace goes back to the global variables only.
+func (vs *VariableStore) SetNamespace(namespace string) {
+	vs.cachedEnv = nil
+	vs.namespace = namespace
+	if namespace != "" {
+		vs.namespaces[namespace] = true
+	}
+}
+
+// resolve returns the key a reference to name reads: the variable of the
+// current namespace if it defines one, or else the global variable.
+func (vs *VariableStore) resolve(name string) string {
+	if vs.namespace == "" {
+		return name
+	}
+	qualified := vs.namespace + "." + name
+	if _, ok := vs.vars[qualified]; ok {
+		return qualified
+	}
+	return name
+}
+
+// inNamespace reports whether a key belongs to a namespaced fragment.
+func (vs *VariableStore) inNamespace(key string) bool {
+	i := strings.LastIndexByte(key, '.')
+	return i > 0 && vs.namespaces[key[:i]]
+}
+
+func (vs *VariableStore) Get(key string) (string, bool) {
+	if val, ok := vs.locals[key]; ok {
+		return val, true
+	}
+	if val, ok := vs.scope[key]; ok {
+		return val, true
+	}
+	vs.loadVCS(key)
+	entry, ok := vs.vars[vs.resolve(key)]
 	if !ok {
 		return "", false
 	}
 	return entry.value, true
 }
 
+// lookup returns the value of a variable for a reference to it. The body of a
+// `define` block is expanded at this point, so a macro that refers to itself,
+// directly or through other macros, is reported as circular with the chain of
+// references that led back to it.
+func (vs *VariableStore) lookup(name string, visiting map[string]bool) (string, bool, error) {
+	value, ok := vs.Get(name)
+	if !ok || !vs.isMacro(name) {
+		if vs.traced[name] {
+			vs.traceExpansion(name, value, ok)
+		}
+		return value, ok, nil
+	}
+	if visiting[name] {
+		return "", false, fmt.Errorf(ErrorCircularVariable, vs.circularChain(name))
+	}
+	visiting[name] = true
+	vs.expanding = append(vs.expanding, name)
+	defer func() {
+		delete(visiting, name)
+		vs.expanding = vs.expanding[:len(vs.expanding)-1]
+	}()
+	expanded, err := vs.expand(value, true, visiting)
+	if err == nil && vs.traced[name] {
+		vs.traceExpansion(name, expanded, true)
+	}
+	return expanded, true, err
+}
+
+// circularChain formats the references from the expansion of name back to
+// name itself, such as `A -> B -> A`.
+func (vs *VariableStore) circularChain(name string) string {
+	start := 0
+	for i := len(vs.expanding) - 1; i >= 0; i-- {
+		if vs.expanding[i] == name {
+			start = i
+			break
+		}
+	}
+	return strings.Join(vs.expanding[start:], " -> ") + " -> " + name
+}
+
+// isMacro reports whether a name currently refers to a `define` block rather
+// than to a loop variable, call argument or target-specific value.
+func (vs *VariableStore) isMacro(name string) bool {
+	if _, ok := vs.locals[name]; ok {
+		return false
+	}
+	if _, ok := vs.scope[name]; ok {
+		return false
+	}
+	return vs.vars[vs.resolve(name)].macro
+}
+
+// bindLocals binds variables that shadow all others until the returned
+// function restores the previous bindings.
+func (vs *VariableStore) bindLocals(bindings map[string]string) func() {
+	saved := make(map[string]string)
+	for name, value := range bindings {
+		if old, ok := vs.locals[name]; ok {
+			saved[name] = old
+		}
+		vs.locals[name] = value
+	}
+	return func() {
+		for name := range bindings {
+			if old, ok := saved[name]; ok {
+				vs.locals[name] = old
+			} else {
+				delete(vs.locals, name)
+			}
+		}
+	}
+}
+
+// Source reports where the current value of a variable came from.
+func (vs *VariableStore) Source(key string) (varSource, bool) {
+	entry, ok := vs.vars[key]
+	return entry.source, ok
+}
+
+// setShellStatus records the exit status of a shell command in .SHELLSTATUS:
+// 0 on success, the command's exit code, or 127 if it could not be run. The
+// variable is read-only and never exported, so the environment cache is kept.
+func (vs *VariableStore) setShellStatus(err error) {
+	status := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status = exitErr.ExitCode()
+	} else if err != nil {
+		status = 127
+	}
+	vs.vars[ShellStatusVar] = varEntry{value: strconv.Itoa(status), source: sourceBuiltin, originFile: "built-in"}
+}
+
 func (vs *VariableStore) runShellCmd(command string) (string, error) {
 	if vs.isExpandingForEnv {
 		return "", nil
 	}
+	if vs.probing {
+		return "", errProbeSideEffect
+	}
 
 	if vs.isDebug {
 		fmt.Fprintf(os.Stderr, DebugShellCommand, command)
@@ -90,6 +510,7 @@ func (vs *VariableStore) runShellCmd(command string) (string, error) {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	err := cmd.Run()
+	vs.setShellStatus(err)
 	if vs.isDebug {
 		if stdout.Len() > 0 {
 			fmt.Fprintf(os.Stderr, DebugShellStdout, strings.TrimRight(stdout.String(), "\n\r"))
@@ -110,6 +531,13 @@ func (vs *VariableStore) expand(input string, unescape bool, visiting map[string
 	i := 0
 	for i < len(input) {
 		char := input[i]
+		if !unescape && char == '\\' && i+1 < len(input) && input[i+1] == '$' {
+			// In recipes `\$` is the shell's escape, so make-lite does not expand
+			// what follows and leaves it for the shell: `echo "\$HOME"` prints $HOME.
+			result.WriteString(`\$`)
+			i += 2
+			continue
+		}
 		if unescape && char == '\\' {
 			if i+1 < len(input) {
 				result.WriteByte(input[i+1])
@@ -131,6 +559,17 @@ func (vs *VariableStore) expand(input string, unescape bool, visiting map[string
 			case '$':
 				result.WriteByte('$')
 				i += 2
+			case '@', '<', '*':
+				// Automatic variables are only defined while `.SECONDEXPANSION`
+				// prerequisites are expanded, in the recipes of rules inferred from
+				// suffix rules and (`$@` only) in those of `.ATOMIC` rules; elsewhere
+				// they are passed through to the shell.
+				if val, ok := vs.Get(input[i+1 : i+2]); ok {
+					result.WriteString(val)
+				} else {
+					result.WriteString(input[i : i+2])
+				}
+				i += 2
 			case '(':
 				start := i + 2
 				balance := 1
@@ -152,6 +591,23 @@ func (vs *VariableStore) expand(input string, unescape bool, visiting map[string
 				content := input[start:end]
 				i = end + 1
 
+				if name, rawArgs, ok := cutFunctionCall(content); ok {
+					value, err := vs.callFunction(name, rawArgs, visiting)
+					if err != nil {
+						return "", err
+					}
+					result.WriteString(value)
+					continue
+				}
+				if name, from, to, ok := cutSubstitutionRef(content); ok {
+					value, err := vs.substitutionRef(name, from, to, visiting)
+					if err != nil {
+						return "", err
+					}
+					result.WriteString(value)
+					continue
+				}
+
 				expandedContent, err := vs.expand(content, true, visiting)
 				if err != nil {
 					return "", err
@@ -163,11 +619,29 @@ func (vs *VariableStore) expand(input string, unescape bool, visiting map[string
 					return "", fmt.Errorf(ErrorUnsupportedFunction, functionName)
 				}
 
-				if strings.HasPrefix(expandedContent, "shell ") {
+				if command, ok := strings.CutPrefix(expandedContent, "shell? "); ok {
+					// `$(shell? ...)` tolerates failure: the result is empty and the
+					// exit status is left in .SHELLSTATUS for the makefile to check.
+					// A probing expansion still stops here, so the output it would
+					// depend on keeps the rule out of digests and the cache.
+					finalValue, err = vs.runShellCmd(strings.TrimSpace(command))
+					if err != nil && !errors.Is(err, errProbeSideEffect) {
+						finalValue, err = "", nil
+					}
+				} else if strings.HasPrefix(expandedContent, "shell ") {
 					cmdStr := strings.TrimSpace(expandedContent[len("shell"):])
 					finalValue, err = vs.runShellCmd(cmdStr)
-				} else if val, ok := vs.Get(expandedContent); ok {
-					finalValue = val
+				} else if val, ok, lookupErr := vs.lookup(expandedContent, visiting); ok || lookupErr != nil {
+					finalValue, err = val, lookupErr
+				} else if vs.strict && envVarName.MatchString(expandedContent) {
+					return "", fmt.Errorf(ErrorStrictUndefinedVariable, expandedContent)
+				} else if expandedContent != content && isVariableName(expandedContent) {
+					// A computed name such as `$($(PLATFORM)_FLAGS)` is always a variable
+					// reference; an undefined one is empty rather than a command to run.
+					finalValue = ""
+				} else if strings.ContainsAny(expandedContent, " \t") && !vs.allowShellFallback() {
+					// A mistyped function name or a stray `$(rm -rf ...)` must not run silently.
+					return "", fmt.Errorf(ErrorShellFallbackDisabled, expandedContent, expandedContent)
 				} else {
 					finalValue, err = vs.runShellCmd(expandedContent)
 				}
@@ -185,11 +659,18 @@ func (vs *VariableStore) expand(input string, unescape bool, visiting map[string
 					continue
 				}
 				i += 1 + len(varName)
-				if visiting[varName] {
-					return "", fmt.Errorf("circular variable reference detected for '%s'", varName)
+				val, ok, err := vs.lookup(varName, visiting)
+				if err != nil {
+					return "", err
 				}
-				if val, ok := vs.Get(varName); ok {
+				if ok {
 					result.WriteString(val)
+				} else if vs.strict && envVarName.MatchString(varName) {
+					return "", fmt.Errorf(ErrorStrictUndefinedVariable, varName)
+				} else if !vs.legacyDollar {
+					// Not a make-lite variable, so it is left for the shell: `$1` in
+					// an awk script or a shell variable set earlier in the recipe.
+					result.WriteString("$" + varName)
 				}
 			}
 		} else {
@@ -213,22 +694,76 @@ func (vs *VariableStore) getEnvironment() []string {
 	}
 	vs.isExpandingForEnv = true
 	defer func() { vs.isExpandingForEnv = false }()
+	// Variables are keyed with envKey, so a value set for `PATH` replaces an
+	// inherited `Path` on Windows instead of passing both. The first spelling is kept.
 	envMap := make(map[string]string)
+	names := make(map[string]string)
+	set := func(name, value string) {
+		key := envKey(name)
+		if _, ok := names[key]; !ok {
+			names[key] = name
+		}
+		envMap[key] = value
+	}
+	unset := func(name string) {
+		delete(envMap, envKey(name))
+		delete(names, envKey(name))
+	}
 	for _, pair := range os.Environ() {
 		parts := strings.SplitN(pair, "=", 2)
-		if len(parts) == 2 {
-			envMap[parts[0]] = parts[1]
+		if len(parts) == 2 && vs.inheritsEnv(parts[0]) {
+			set(parts[0], parts[1])
+		}
+	}
+	for key, varEntry := range vs.vars {
+		_, inEnv := envMap[envKey(key)]
+		if vs.parsing && varEntry.source == sourceEnvFile && !vs.exported[key] {
+			continue
+		}
+		if varEntry.source != sourceShellEnv && !vs.inNamespace(key) && vs.isExported(key, varEntry.source, inEnv) {
+			set(key, varEntry.value)
 		}
 	}
+	// The exported variables of the current namespace are passed by their short
+	// names; those of other fragments are not passed at all.
 	for key, varEntry := range vs.vars {
-		if varEntry.source != sourceShellEnv {
-			envMap[key] = varEntry.value
+		name, ok := strings.CutPrefix(key, vs.namespace+".")
+		if vs.namespace == "" || !ok || strings.Contains(name, ".") {
+			continue
+		}
+		_, inEnv := envMap[envKey(name)]
+		if vs.isExported(key, varEntry.source, inEnv) {
+			set(name, varEntry.value)
 		}
 	}
-	env := make([]string, 0, len(envMap))
-	for k, v := range envMap {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	for key := range vs.unexported {
+		unset(key)
+	}
+	for key, value := range vs.scope {
+		_, inEnv := envMap[envKey(key)]
+		if vs.scopePrivate[key] {
+			unset(key)
+		} else if vs.isExported(key, sourceMakefileUnconditional, inEnv) {
+			set(key, value)
+		}
+	}
+	// Recipes that print their environment, and tools that hash it, should see
+	// the same order every run, so variables are sorted by name.
+	keys := make([]string, 0, len(envMap))
+	for key := range envMap {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return names[keys[i]] < names[keys[j]] })
+	env := make([]string, 0, len(keys))
+	for _, key := range keys {
+		env = append(env, names[key]+"="+envMap[key])
 	}
 	vs.cachedEnv = env
 	return env
 }
+
+// isSpecialVariable reports whether a name is one of make-lite's dot-prefixed
+// settings (such as .WORKER) or an automatic variable such as `@`, which are never exported.
+func isSpecialVariable(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "@" || name == "<" || name == "*"
+}
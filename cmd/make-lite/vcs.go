@@ -0,0 +1,152 @@
+// cmd/make-lite/vcs.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// VCS abstracts the version control system holding the source tree, so that
+// features built on it work the same with git, Mercurial, or no VCS at all.
+type VCS interface {
+	// Name is "git", "hg", or "none".
+	Name() string
+	// Revision returns the ID of the checked-out commit.
+	Revision() (string, error)
+	// Branch returns the name of the current branch (or bookmark).
+	Branch() (string, error)
+	// Dirty reports whether tracked files have uncommitted changes.
+	Dirty() (bool, error)
+}
+
+// detectVCS finds the repository that contains dir by walking up to the
+// first directory with a .git or .hg entry. Without one, or without the
+// matching tool installed, it returns a VCS that reports nothing.
+func detectVCS(dir string) VCS {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			if _, err := exec.LookPath("git"); err == nil {
+				return gitVCS{root: d}
+			}
+			break
+		}
+		if _, err := os.Stat(filepath.Join(d, ".hg")); err == nil {
+			if _, err := exec.LookPath("hg"); err == nil {
+				return hgVCS{root: d}
+			}
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return noVCS{}
+}
+
+// runVCS runs a VCS command in the repository root and returns its trimmed output.
+func runVCS(root, tool string, args ...string) (string, error) {
+	cmd := exec.Command(tool, args...)
+	cmd.Dir = root
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %w\nstderr: %s", tool, strings.Join(args, " "), err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+type gitVCS struct{ root string }
+
+func (g gitVCS) Name() string { return "git" }
+
+func (g gitVCS) Revision() (string, error) {
+	return runVCS(g.root, "git", "rev-parse", "HEAD")
+}
+
+func (g gitVCS) Branch() (string, error) {
+	branch, err := runVCS(g.root, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	if branch == "HEAD" {
+		return "", err // Detached HEAD
+	}
+	return branch, err
+}
+
+func (g gitVCS) Dirty() (bool, error) {
+	status, err := runVCS(g.root, "git", "status", "--porcelain", "--untracked-files=no")
+	return status != "", err
+}
+
+type hgVCS struct{ root string }
+
+func (h hgVCS) Name() string { return "hg" }
+
+func (h hgVCS) Revision() (string, error) {
+	return runVCS(h.root, "hg", "log", "-r", ".", "--template", "{node}")
+}
+
+func (h hgVCS) Branch() (string, error) {
+	return runVCS(h.root, "hg", "branch")
+}
+
+func (h hgVCS) Dirty() (bool, error) {
+	status, err := runVCS(h.root, "hg", "status", "--modified", "--added", "--removed", "--deleted")
+	return status != "", err
+}
+
+// noVCS is the plain-directory fallback: it has no history and nothing is dirty.
+type noVCS struct{}
+
+func (noVCS) Name() string              { return "none" }
+func (noVCS) Revision() (string, error) { return "", nil }
+func (noVCS) Branch() (string, error)   { return "", nil }
+func (noVCS) Dirty() (bool, error)      { return false, nil }
+
+// vcsVariables returns the metadata variables describing the source tree.
+// Errors from the VCS leave the affected variable empty, since a build should
+// not fail just because, say, a repository has no commits yet.
+func vcsVariables(vcs VCS) map[string]string {
+	revision, _ := vcs.Revision()
+	branch, _ := vcs.Branch()
+	dirty, _ := vcs.Dirty()
+	vars := map[string]string{
+		VCSTypeVar:     vcs.Name(),
+		VCSRevisionVar: revision,
+		VCSBranchVar:   branch,
+		VCSDirtyVar:    "",
+	}
+	if dirty {
+		vars[VCSDirtyVar] = "dirty"
+	}
+	return vars
+}
+
+// loadVCS sets the VCS_* variables the first time one of them is used, so
+// builds that never refer to them do not run the VCS. Like the rest of the
+// environment, a value already there is kept, so CI can supply it for trees
+// checked out without history, and the values reach recipes from then on.
+func (vs *VariableStore) loadVCS(name string) {
+	switch name {
+	case VCSTypeVar, VCSRevisionVar, VCSBranchVar, VCSDirtyVar:
+	default:
+		return
+	}
+	if vs.vcsLoaded || vs.vcsDir == "" {
+		return
+	}
+	vs.vcsLoaded = true
+	for name, value := range vcsVariables(detectVCS(vs.vcsDir)) {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		_ = os.Setenv(name, value)
+		if _, exists := vs.vars[name]; !exists {
+			vs.vars[name] = varEntry{value: value, source: sourceShellEnv, originFile: "shell environment"}
+		}
+	}
+	vs.cachedEnv = nil
+}
@@ -0,0 +1,118 @@
+// cmd/make-lite/verify.go
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileStamp is the part of a file's metadata that changes when it is written.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotWorkspace records every regular file under the working directory,
+// skipping version control metadata and make-lite's own state directory.
+func snapshotWorkspace() (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != "." && (d.Name() == ".git" || path == StateDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	return files, nil
+}
+
+// verifyIOContract compares the workspace after a recipe ran with the snapshot
+// taken before it. Every declared output must have been created or modified,
+// and no other file may have been written. A rule none of whose targets exist
+// before or after is symbolic, so only its undeclared writes are checked.
+func verifyIOContract(rule *Rule, before map[string]fileStamp) error {
+	after, err := snapshotWorkspace()
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	declared := make(map[string]bool)
+	var declaredDirs []string
+	produced := 0
+	for _, target := range rule.Targets {
+		path := filepath.Clean(target)
+		declared[path] = true
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			// Anything written inside a declared output directory is part of that output.
+			declaredDirs = append(declaredDirs, path+string(filepath.Separator))
+			produced++
+			continue
+		}
+		if _, exists := after[path]; exists {
+			produced++
+		}
+	}
+
+	if produced > 0 {
+		for _, target := range rule.Targets {
+			path := filepath.Clean(target)
+			old, existed := before[path]
+			current, exists := after[path]
+			switch {
+			case !exists:
+				if info, err := os.Stat(path); err != nil || !info.IsDir() {
+					violations = append(violations, fmt.Sprintf(ViolationNotProduced, target))
+				}
+			case existed && old == current:
+				violations = append(violations, fmt.Sprintf(ViolationNotModified, target))
+			}
+		}
+	}
+
+	for path, current := range after {
+		if declared[path] || insideAny(path, declaredDirs) {
+			continue
+		}
+		if old, existed := before[path]; !existed || old != current {
+			violations = append(violations, fmt.Sprintf(ViolationUndeclared, path))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Strings(violations)
+	return fmt.Errorf(ErrorIOContract, strings.Join(violations, ViolationListSeparator))
+}
+
+// insideAny reports whether path lies under one of the given directory prefixes.
+func insideAny(path string, dirPrefixes []string) bool {
+	for _, prefix := range dirPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+// cmd/make-lite/worker.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// workRequest and workResponse follow the JSON flavor of the Bazel persistent
+// worker protocol: one JSON object per line on the worker's stdin and stdout.
+type workRequest struct {
+	Arguments []string `json:"arguments"`
+	RequestID int      `json:"requestId"`
+}
+
+type workResponse struct {
+	ExitCode  int    `json:"exitCode"`
+	Output    string `json:"output"`
+	RequestID int    `json:"requestId"`
+}
+
+// workerProcess is a running persistent worker.
+type workerProcess struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mu     sync.Mutex // One request at a time, as recipes may share the worker
+}
+
+// worker returns the named persistent worker, starting it on first use. Its
+// sources are prerequisites of every rule that uses it, so they are built by
+// then. It is called with the variable store locked.
+func (e *Engine) worker(name string) (*workerProcess, error) {
+	if w, ok := e.workers[name]; ok {
+		return w, nil
+	}
+	rule, ok := e.makefile.Workers[name]
+	if !ok {
+		return nil, fmt.Errorf(ErrorUnknownWorker, name)
+	}
+	var script []string
+	for _, cmdLine := range rule.Recipe {
+		if strings.TrimSpace(cmdLine) == "" {
+			continue
+		}
+		expandedCmd, mods, err := e.expandRecipeLine(rule, cmdLine)
+		if err != nil {
+			return nil, err
+		}
+		if !mods.silent {
+			fmt.Println(expandedCmd)
+		}
+		script = append(script, expandedCmd)
+	}
+
+	cmd := exec.Command(e.shellPath, "-c", strings.Join(script, "\n"))
+	cmd.Env = e.vars.getEnvironment()
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start worker '%s': %w", name, err)
+	}
+	w := &workerProcess{name: name, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	e.workers[name] = w
+	return w, nil
+}
+
+// do sends one request to the worker and waits for its response.
+func (w *workerProcess) do(args []string) (workResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var resp workResponse
+	req, err := json.Marshal(workRequest{Arguments: args})
+	if err != nil {
+		return resp, err
+	}
+	if _, err := w.stdin.Write(append(req, '\n')); err != nil {
+		return resp, fmt.Errorf(ErrorWorkerExited, w.name, err)
+	}
+	line, err := w.stdout.ReadBytes('\n')
+	if err != nil {
+		return resp, fmt.Errorf(ErrorWorkerExited, w.name, err)
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return resp, fmt.Errorf("invalid response from worker '%s': %w", w.name, err)
+	}
+	return resp, nil
+}
+
+// executeWithWorker sends each recipe line, split into arguments, to a
+// persistent worker instead of a new shell.
+func (e *Engine) executeWithWorker(rule *Rule, name string) error {
+	var w *workerProcess
+	err := e.inRuleScope(rule, func() (err error) {
+		w, err = e.worker(name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, cmdLine := range rule.Recipe {
+		if strings.TrimSpace(cmdLine) == "" {
+			continue
+		}
+		var expandedCmd string
+		var mods lineModifiers
+		err := e.inRuleScope(rule, func() (err error) {
+			expandedCmd, mods, err = e.expandRecipeLine(rule, cmdLine)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !mods.silent {
+			e.echo(rule, expandedCmd)
+		}
+		if e.isDebug {
+			fmt.Fprintf(os.Stderr, DebugWorkerRequest, name, expandedCmd)
+		}
+		e.buildLog.command(rule, expandedCmd)
+		resp, err := w.do(splitArgs(expandedCmd))
+		if err != nil {
+			return err
+		}
+		if resp.Output != "" {
+			stdout, _, done := e.recipeOutput(rule)
+			_, _ = io.WriteString(stdout, resp.Output)
+			if !strings.HasSuffix(resp.Output, "\n") {
+				_, _ = io.WriteString(stdout, "\n")
+			}
+			done()
+		}
+		if resp.ExitCode != 0 {
+			err := fmt.Errorf(ErrorWorkerFailed, name, resp.ExitCode)
+			if !mods.ignoreError {
+				return err
+			}
+			fmt.Printf(StatusErrorIgnored, rule.Targets[0], err)
+		}
+	}
+	return nil
+}
+
+// Shutdown closes the stdin of every persistent worker, which tells it to
+// exit, and waits for it to finish. Workers are shut down in name order, so
+// the error reported when several fail is the same every run.
+func (e *Engine) Shutdown() error {
+	names := make([]string, 0, len(e.workers))
+	for name := range e.workers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var firstErr error
+	for _, name := range names {
+		w := e.workers[name]
+		if err := w.stdin.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := w.cmd.Wait(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf(ErrorWorkerExited, name, err)
+		}
+		delete(e.workers, name)
+	}
+	return firstErr
+}
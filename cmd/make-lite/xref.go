@@ -0,0 +1,120 @@
+// cmd/make-lite/xref.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xrefSite is one line of `make-lite xref` output.
+type xrefSite struct {
+	origin string
+	text   string
+}
+
+// writeXref lists every assignment of a variable in definition order, then its
+// target-specific and profile assignments, followed by every line that
+// references it.
+func writeXref(w io.Writer, makefile *Makefile, name string) error {
+	var assignments []xrefSite
+	for _, def := range makefile.Variables {
+		if def.Name != name {
+			continue
+		}
+		text := fmt.Sprintf("%s %s %s", def.Name, def.Op, def.RawValue)
+		if def.Op == opLoadEnv {
+			// Env file values are often secrets, so only the key is shown.
+			text = fmt.Sprintf("%s (from env file)", def.Name)
+		}
+		assignments = append(assignments, xrefSite{origin: def.Origin, text: strings.TrimSpace(text)})
+	}
+
+	// One target-specific assignment is stored once per target it names.
+	targetsByOrigin := make(map[string][]string)
+	values := make(map[string]TargetVar)
+	for target, tvs := range makefile.TargetVars {
+		for _, tv := range tvs {
+			if tv.Name == name {
+				targetsByOrigin[tv.Origin] = append(targetsByOrigin[tv.Origin], target)
+				values[tv.Origin] = tv
+			}
+		}
+	}
+	for _, pv := range makefile.PatternVars {
+		if pv.Name == name {
+			targetsByOrigin[pv.Origin] = append(targetsByOrigin[pv.Origin], pv.Pattern)
+			values[pv.Origin] = pv.TargetVar
+		}
+	}
+	var targetSpecific, profiles []xrefSite
+	for origin, targets := range targetsByOrigin {
+		sort.Strings(targets)
+		tv := values[origin]
+		text := fmt.Sprintf("%s: %s %s %s", strings.Join(targets, " "), tv.Name, tv.Op, tv.Value)
+		targetSpecific = append(targetSpecific, xrefSite{origin: origin, text: text})
+	}
+	for _, profile := range makefile.Profiles {
+		if value, ok := profile.Vars[name]; ok {
+			text := fmt.Sprintf("profile %s: %s=%s", profile.Name, name, value)
+			profiles = append(profiles, xrefSite{origin: profile.Origin, text: text})
+		}
+	}
+	// Map iteration order is random, so these are put in file order.
+	for _, sites := range [][]xrefSite{targetSpecific, profiles} {
+		sort.Slice(sites, func(i, j int) bool { return originLess(sites[i].origin, sites[j].origin) })
+	}
+	assignments = append(assignments, targetSpecific...)
+	assignments = append(assignments, profiles...)
+
+	var references []xrefSite
+	for _, ref := range makefile.ReferenceSites {
+		if ref.Name != name {
+			continue
+		}
+		// A line that mentions the variable twice is listed once.
+		if n := len(references); n > 0 && references[n-1].origin == ref.Origin {
+			continue
+		}
+		references = append(references, xrefSite{origin: ref.Origin, text: ref.Text})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, StatusXrefAssignments, name)
+	writeXrefSites(&b, assignments)
+	fmt.Fprintf(&b, StatusXrefReferences, name)
+	writeXrefSites(&b, references)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeXrefSites(b *strings.Builder, sites []xrefSite) {
+	if len(sites) == 0 {
+		b.WriteString(StatusXrefNone)
+		return
+	}
+	for _, site := range sites {
+		fmt.Fprintf(b, "  %s  %s\n", site.origin, site.text)
+	}
+}
+
+// originLess orders "file:line" origins by file, then numerically by line.
+func originLess(a, b string) bool {
+	fileA, lineA := splitOrigin(a)
+	fileB, lineB := splitOrigin(b)
+	if fileA != fileB {
+		return fileA < fileB
+	}
+	return lineA < lineB
+}
+
+func splitOrigin(origin string) (string, int) {
+	i := strings.LastIndex(origin, ":")
+	if i < 0 {
+		return origin, 0
+	}
+	line, _ := strconv.Atoi(origin[i+1:])
+	return origin[:i], line
+}
@@ -0,0 +1,98 @@
+#!/usr/bin/env python3
+
+import os
+import sys
+import time
+import shutil
+import tempfile
+import argparse
+import statistics
+import subprocess
+from pathlib import Path
+
+from run_tests import compile_binary, COLOR_BLUE, COLOR_GREEN, COLOR_YELLOW, COLOR_RESET
+
+# --- Configuration ---
+MAKEFILE_NAME = "Makefile.mk-lite"
+
+def generate_project(root, rules, headers):
+    """
+    Writes a synthetic project: `rules` objects, each built from its own source
+    file and every one of `headers` shared headers, all already up to date, so
+    that a build only checks freshness.
+
+    Args:
+        root (Path): Directory to write the project to.
+        rules (int): Number of object rules.
+        headers (int): Number of headers every rule depends on.
+    """
+    (root / "include").mkdir()
+    (root / "src").mkdir()
+    (root / "obj").mkdir()
+    header_names = [f"include/h{h}.h" for h in range(headers)]
+    for name in header_names:
+        (root / name).write_text("\n")
+
+    # The goal's prerequisites are continued over several lines, as one line is
+    # limited to 64 KiB.
+    objects = [f"obj/f{i}.o" for i in range(rules)]
+    chunks = [" ".join(objects[i:i + 100]) for i in range(0, rules, 100)]
+    lines = ["all: " + " \\\n\t".join(chunks), ""]
+    for i in range(rules):
+        (root / f"src/f{i}.c").write_text("\n")
+        lines.append(f"obj/f{i}.o: src/f{i}.c " + " ".join(header_names))
+        lines.append(f"\t@touch obj/f{i}.o")
+        lines.append("")
+    (root / MAKEFILE_NAME).write_text("\n".join(lines))
+
+    # Sources are an hour older than the objects, so nothing needs to be built.
+    old = time.time() - 3600
+    for path in list((root / "include").iterdir()) + list((root / "src").iterdir()):
+        os.utime(path, (old, old))
+    for i in range(rules):
+        (root / f"obj/f{i}.o").touch()
+
+def time_build(binary, root, runs):
+    """Returns the median wall time in seconds of an up-to-date build."""
+    # The first run records the recipes in the build state.
+    subprocess.run([str(binary), "all"], cwd=root, check=True, capture_output=True)
+    times = []
+    for _ in range(runs):
+        start = time.perf_counter()
+        subprocess.run([str(binary), "all"], cwd=root, check=True, capture_output=True)
+        times.append(time.perf_counter() - start)
+    return statistics.median(times)
+
+def main():
+    parser = argparse.ArgumentParser(
+        description="Times an up-to-date build of a synthetic makefile, where freshness checks dominate.")
+    parser.add_argument('--rules', type=int, default=10000, help="Number of rules (default: 10000)")
+    parser.add_argument('--headers', type=int, default=20,
+                        help="Shared headers every rule depends on (default: 20)")
+    parser.add_argument('--runs', type=int, default=5, help="Timed runs; the median is reported (default: 5)")
+    parser.add_argument('--baseline', metavar='BINARY',
+                        help="Another make-lite binary, such as a previous release, to compare against.")
+    args = parser.parse_args()
+
+    binary_path = compile_binary()
+    project_dir = Path(tempfile.mkdtemp(prefix="make-lite-bench-"))
+    try:
+        print(f"{COLOR_YELLOW}--- Generating {args.rules} rules with {args.headers} shared headers in {project_dir} ---{COLOR_RESET}")
+        generate_project(project_dir, args.rules, args.headers)
+
+        candidates = [("make-lite", binary_path)]
+        if args.baseline:
+            candidates.append(("baseline", Path(args.baseline).resolve()))
+        results = {}
+        for label, binary in candidates:
+            print(f"{COLOR_BLUE}Timing:{COLOR_RESET} {label} ({binary})", end=' ... ', flush=True)
+            results[label] = time_build(binary, project_dir, args.runs)
+            print(f"{COLOR_GREEN}{results[label]:.3f}s{COLOR_RESET}")
+
+        if args.baseline:
+            print(f"\nmake-lite is {results['baseline'] / results['make-lite']:.2f}x the speed of the baseline.")
+    finally:
+        shutil.rmtree(project_dir)
+
+if __name__ == "__main__":
+    main()